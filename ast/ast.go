@@ -18,8 +18,40 @@ type Expr interface {
 	exprNode()
 }
 
+// TypeExpr is implemented by the nodes which describe a type
+type TypeExpr interface {
+	Node
+	typeNode()
+}
+
+// ArrayLit is an array literal, such as [1 2 3]
+type ArrayLit struct {
+	Lbrack token.Pos
+	Elems  []Expr
+	Rbrack token.Pos
+}
+
+// ArrayType is the type of an array, such as [10]int, or of a list, such
+// as []int, when Len is nil
+type ArrayType struct {
+	Lbrack token.Pos
+	Len    *BasicLit
+	Elem   TypeExpr
+}
+
+// AssignExpr assigns Value to the variable Name, or to the field of Name
+// selected by Fields, such as p.x. If Index is not nil the element Index of
+// that array or list is assigned instead.
 type AssignExpr struct {
-	Equal token.Pos
+	Equal  token.Pos
+	Name   *Ident
+	Fields []*Ident
+	Index  Expr
+	Value  Expr
+}
+
+// Binding binds Name to the value of Value in a let expression
+type Binding struct {
 	Name  *Ident
 	Value Expr
 }
@@ -37,15 +69,43 @@ type BinaryExpr struct {
 	List  []Expr
 }
 
+// BranchExpr is a break or continue of the innermost for loop. The Value of
+// a break, if any, becomes the result of the loop.
+type BranchExpr struct {
+	TokPos token.Pos
+	Tok    token.Token
+	Value  Expr
+}
+
+// CallExpr calls the function Fun, which is usually the name of a function
+// but may be any expression resulting in a function
 type CallExpr struct {
-	Name *Ident
+	Fun  Expr
 	Args []Expr
 }
 
+// Clause is a clause of a cond or switch expression, such as ((< n 2) 1).
+// Body is evaluated when Test, a condition or a case label respectively,
+// matches.
+type Clause struct {
+	Lparen token.Pos
+	Test   Expr
+	Body   Expr
+}
+
+// CondExpr evaluates the body of the first of Clauses whose test is true or,
+// if none are, Else
+type CondExpr struct {
+	Cond    token.Pos
+	Type    TypeExpr
+	Clauses []*Clause
+	Else    Expr
+}
+
 type DefineStmt struct {
 	Define token.Pos
 	Name   *Ident
-	Type   *Ident
+	Type   TypeExpr
 	Kind   Kind
 	Body   Expr
 }
@@ -56,16 +116,27 @@ type File struct {
 
 type ForExpr struct {
 	For  token.Pos
-	Type *Ident
+	Type TypeExpr
 	Cond Expr
 	Body []Expr
 }
 
+// FuncExpr is a function, such as (func (x:int):int x). A generic function
+// declares its type parameters before its parameters, such as
+// (func [T] (x:T):T x).
 type FuncExpr struct {
+	Func       token.Pos
+	TypeParams []*Ident
+	Type       TypeExpr
+	Params     []*Param
+	Body       []Expr
+}
+
+// FuncType is the type of a function value, such as (func int int):int
+type FuncType struct {
 	Func   token.Pos
-	Type   *Ident
-	Params []*Param
-	Body   []Expr
+	Params []TypeExpr
+	Result TypeExpr
 }
 
 type Ident struct {
@@ -76,12 +147,47 @@ type Ident struct {
 
 type IfExpr struct {
 	If   token.Pos
-	Type *Ident
+	Type TypeExpr
 	Cond Expr
 	Then Expr
 	Else Expr
 }
 
+// InstanceExpr is a generic function instantiated with explicit type
+// arguments, such as max[int]
+type InstanceExpr struct {
+	Name   *Ident
+	Lbrack token.Pos
+	Types  []TypeExpr
+}
+
+// LetExpr binds each of Bindings in turn, so a binding may refer to those
+// before it, and evaluates Body with them in scope
+type LetExpr struct {
+	Let      token.Pos
+	Bindings []*Binding
+	Body     []Expr
+}
+
+// MatchArm is an arm of a match expression, such as ((Ok v) v). The arm is
+// taken when the value matched is the variant Name, binding the fields of
+// the variant to Params in order. An arm named _ matches any variant.
+type MatchArm struct {
+	Lparen token.Pos
+	Name   *Ident
+	Params []*Ident
+	Body   Expr
+}
+
+// MatchExpr evaluates the arm of Arms which matches the variant of the
+// union X
+type MatchExpr struct {
+	Match token.Pos
+	Type  TypeExpr
+	X     Expr
+	Arms  []*MatchArm
+}
+
 type Object struct {
 	NamePos token.Pos
 	Name    string
@@ -94,7 +200,7 @@ type Package struct {
 
 type Param struct {
 	Name *Ident
-	Type *Ident
+	Type TypeExpr
 }
 
 type Scope struct {
@@ -102,45 +208,112 @@ type Scope struct {
 	Table  map[string]*Object
 }
 
+// SelectorExpr selects the field Sel of the struct X, such as p.x
+type SelectorExpr struct {
+	X   Expr
+	Sel *Ident
+}
+
+// StructType declares a struct type with the given fields. It may only be
+// the body of a define, which names the type.
+type StructType struct {
+	Struct token.Pos
+	Fields []*Param
+}
+
+// SwitchExpr evaluates the body of the clause of Clauses whose case label
+// is equal to the integer Tag or, if there is none, Else
+type SwitchExpr struct {
+	Switch  token.Pos
+	Type    TypeExpr
+	Tag     Expr
+	Clauses []*Clause
+	Else    Expr
+}
+
+// UnionType declares a union, or sum, type whose values are one of the
+// given variants. Like StructType, it may only be the body of a define.
+type UnionType struct {
+	Union    token.Pos
+	Variants []*Variant
+}
+
 type UnaryExpr struct {
 	OpPos token.Pos
-	Op    string
+	Op    token.Token
 	Value Expr
 }
 
 type VarExpr struct {
 	Var    token.Pos
-	Type   *Ident
+	Type   TypeExpr
 	Params []*Param
 	Body   []Expr
 }
 
-func (a *AssignExpr) Pos() token.Pos { return a.Equal }
-func (b *BasicLit) Pos() token.Pos   { return b.LitPos }
-func (b *BinaryExpr) Pos() token.Pos { return b.OpPos }
-func (c *CallExpr) Pos() token.Pos   { return c.Name.Pos() }
-func (d *DefineStmt) Pos() token.Pos { return d.Define }
-func (f *File) Pos() token.Pos       { return token.NoPos }
-func (f *ForExpr) Pos() token.Pos    { return f.For }
-func (f *FuncExpr) Pos() token.Pos   { return f.Func }
-func (i *Ident) Pos() token.Pos      { return i.NamePos }
-func (i *IfExpr) Pos() token.Pos     { return i.If }
-func (o *Object) Pos() token.Pos     { return o.NamePos }
-func (p *Package) Pos() token.Pos    { return token.NoPos }
-func (p *Param) Pos() token.Pos      { return p.Name.Pos() }
-func (u *UnaryExpr) Pos() token.Pos  { return u.OpPos }
-func (v *VarExpr) Pos() token.Pos    { return v.Var }
-
-func (a *AssignExpr) exprNode() {}
-func (b *BasicLit) exprNode()   {}
-func (b *BinaryExpr) exprNode() {}
-func (c *CallExpr) exprNode()   {}
-func (f *ForExpr) exprNode()    {}
-func (f *FuncExpr) exprNode()   {}
-func (i *IfExpr) exprNode()     {}
-func (i *Ident) exprNode()      {}
-func (u *UnaryExpr) exprNode()  {}
-func (v *VarExpr) exprNode()    {}
+// Variant is a variant of a union type, such as (Ok value:int), which is
+// also the name of the constructor for it
+type Variant struct {
+	Name   *Ident
+	Fields []*Param
+}
+
+func (a *ArrayLit) Pos() token.Pos     { return a.Lbrack }
+func (a *ArrayType) Pos() token.Pos    { return a.Lbrack }
+func (a *AssignExpr) Pos() token.Pos   { return a.Equal }
+func (b *BasicLit) Pos() token.Pos     { return b.LitPos }
+func (b *Binding) Pos() token.Pos      { return b.Name.Pos() }
+func (b *BinaryExpr) Pos() token.Pos   { return b.OpPos }
+func (b *BranchExpr) Pos() token.Pos   { return b.TokPos }
+func (c *CallExpr) Pos() token.Pos     { return c.Fun.Pos() }
+func (c *Clause) Pos() token.Pos       { return c.Lparen }
+func (c *CondExpr) Pos() token.Pos     { return c.Cond }
+func (d *DefineStmt) Pos() token.Pos   { return d.Define }
+func (f *File) Pos() token.Pos         { return token.NoPos }
+func (f *ForExpr) Pos() token.Pos      { return f.For }
+func (f *FuncExpr) Pos() token.Pos     { return f.Func }
+func (f *FuncType) Pos() token.Pos     { return f.Func }
+func (i *Ident) Pos() token.Pos        { return i.NamePos }
+func (i *IfExpr) Pos() token.Pos       { return i.If }
+func (i *InstanceExpr) Pos() token.Pos { return i.Name.Pos() }
+func (l *LetExpr) Pos() token.Pos      { return l.Let }
+func (m *MatchArm) Pos() token.Pos     { return m.Lparen }
+func (m *MatchExpr) Pos() token.Pos    { return m.Match }
+func (o *Object) Pos() token.Pos       { return o.NamePos }
+func (p *Package) Pos() token.Pos      { return token.NoPos }
+func (p *Param) Pos() token.Pos        { return p.Name.Pos() }
+func (s *SelectorExpr) Pos() token.Pos { return s.X.Pos() }
+func (s *StructType) Pos() token.Pos   { return s.Struct }
+func (s *SwitchExpr) Pos() token.Pos   { return s.Switch }
+func (u *UnaryExpr) Pos() token.Pos    { return u.OpPos }
+func (u *UnionType) Pos() token.Pos    { return u.Union }
+func (v *VarExpr) Pos() token.Pos      { return v.Var }
+func (v *Variant) Pos() token.Pos      { return v.Name.Pos() }
+
+func (a *ArrayLit) exprNode()     {}
+func (a *AssignExpr) exprNode()   {}
+func (b *BasicLit) exprNode()     {}
+func (b *BinaryExpr) exprNode()   {}
+func (b *BranchExpr) exprNode()   {}
+func (c *CallExpr) exprNode()     {}
+func (c *CondExpr) exprNode()     {}
+func (f *ForExpr) exprNode()      {}
+func (f *FuncExpr) exprNode()     {}
+func (i *IfExpr) exprNode()       {}
+func (i *Ident) exprNode()        {}
+func (i *InstanceExpr) exprNode() {}
+func (l *LetExpr) exprNode()      {}
+func (m *MatchExpr) exprNode()    {}
+func (s *SelectorExpr) exprNode() {}
+func (s *StructType) exprNode()   {}
+func (s *SwitchExpr) exprNode()   {}
+func (u *UnaryExpr) exprNode()    {}
+func (u *UnionType) exprNode()    {}
+func (v *VarExpr) exprNode()      {}
+
+func (a *ArrayType) typeNode() {}
+func (f *FuncType) typeNode()  {}
+func (i *Ident) typeNode()     {}
 
 func NewScope(parent *Scope) *Scope {
 	return &Scope{Parent: parent, Table: make(map[string]*Object)}
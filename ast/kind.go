@@ -5,6 +5,7 @@ type Kind int
 const (
 	None Kind = iota + 1
 	FuncDecl
+	TypeDecl
 	VarDecl
 )
 
@@ -12,6 +13,8 @@ func (k Kind) String() string {
 	switch k {
 	case FuncDecl:
 		return "function"
+	case TypeDecl:
+		return "type"
 	case VarDecl:
 		return "variable"
 	default:
@@ -17,17 +17,41 @@ func Walk(node Node, v Visitor) {
 	}
 
 	switch n := node.(type) {
+	case *ArrayLit:
+		for _, e := range n.Elems {
+			Walk(e, v)
+		}
 	case *AssignExpr:
+		if n.Index != nil {
+			Walk(n.Index, v)
+		}
 		Walk(n.Value, v)
 	case *BasicLit: /* do nothing */
 	case *BinaryExpr:
 		for _, x := range n.List {
 			Walk(x, v)
 		}
+	case *Binding:
+		Walk(n.Value, v)
+	case *BranchExpr:
+		if n.Value != nil {
+			Walk(n.Value, v)
+		}
 	case *CallExpr:
+		Walk(n.Fun, v)
 		for _, arg := range n.Args {
 			Walk(arg, v)
 		}
+	case *Clause:
+		Walk(n.Test, v)
+		Walk(n.Body, v)
+	case *CondExpr:
+		for _, c := range n.Clauses {
+			Walk(c, v)
+		}
+		if n.Else != nil {
+			Walk(n.Else, v)
+		}
 	case *DefineStmt:
 		Walk(n.Body, v)
 	case *File:
@@ -49,12 +73,39 @@ func Walk(node Node, v Visitor) {
 		if n.Else != nil {
 			Walk(n.Else, v)
 		}
+	case *InstanceExpr: /* do nothing */
+	case *LetExpr:
+		for _, b := range n.Bindings {
+			Walk(b, v)
+		}
+		for _, e := range n.Body {
+			Walk(e, v)
+		}
+	case *MatchArm:
+		Walk(n.Body, v)
+	case *MatchExpr:
+		Walk(n.X, v)
+		for _, arm := range n.Arms {
+			Walk(arm, v)
+		}
 	case *Package:
 		for _, file := range n.Files {
 			Walk(file, v)
 		}
+	case *SelectorExpr:
+		Walk(n.X, v)
+	case *StructType: /* do nothing */
+	case *SwitchExpr:
+		Walk(n.Tag, v)
+		for _, c := range n.Clauses {
+			Walk(c, v)
+		}
+		if n.Else != nil {
+			Walk(n.Else, v)
+		}
 	case *UnaryExpr:
 		Walk(n.Value, v)
+	case *UnionType: /* do nothing */
 	case *VarExpr:
 		for _, e := range n.Body {
 			Walk(e, v)
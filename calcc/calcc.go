@@ -61,10 +61,15 @@ func main() {
 		asm  = flag.Bool("s", false, "generate C code but do not compile")
 		cc   = flag.String("cc", "gcc", "C compiler to use")
 		cfl  = flag.String("cflags", "-c -g -std=gnu99", "C compiler flags")
+		chk  = flag.Bool("checked", false, "trap integer overflow at runtime")
 		cout = flag.String("cout", "--output=", "C compiler output flag")
+		inl  = flag.Int("inline", 10, "largest function body to inline")
 		ld   = flag.String("ld", "gcc", "linker")
 		ldf  = flag.String("ldflags", "", "linker flags")
 		opt  = flag.Bool("o", true, "run optimization pass")
+		pir  = flag.Bool("print-ir", false, "print IR before and after optimizing")
+		rep  = flag.Bool("tailcalls", false, "report tail calls compiled as jumps")
+		trmp = flag.Bool("trampoline", false, "trampoline mutual tail calls")
 		ver  = flag.Bool("v", false, "Print version number and exit")
 	)
 	flag.Parse()
@@ -89,11 +94,19 @@ func main() {
 		fmt.Println(err)
 		os.Exit(1)
 	}
+	opts := comp.Options{Optimize: *opt, InlineSize: *inl, Checked: *chk,
+		Trampoline: *trmp}
+	if *rep {
+		opts.Report = os.Stderr
+	}
+	if *pir {
+		opts.PrintIR = os.Stderr
+	}
 	if fi.IsDir() {
-		err = comp.CompileDir(path, *opt)
+		err = comp.CompileDir(path, opts)
 		path = filepath.Join(path, filepath.Base(path))
 	} else {
-		err = comp.CompileFile(path, *opt)
+		err = comp.CompileFile(path, opts)
 	}
 
 	path = path[:len(path)-len(filepath.Ext(path))]
@@ -0,0 +1,124 @@
+// Copyright (c) 2015, Rob Thornton
+// All rights reserved.
+// This source code is governed by a Simplied BSD-License. Please see the
+// LICENSE included in this distribution for a copy of the full license
+// or, if one is not included, you may also find a copy at
+// http://opensource.org/licenses/BSD-2-Clause
+
+package comp
+
+import (
+	"fmt"
+
+	"github.com/rthornton128/calc/ir"
+)
+
+// compBuiltin compiles a call to builtin b, usually as a loop over the
+// elements of its list argument. The arguments are evaluated once, in
+// order, before the loop begins. The loop only visits the elements in the
+// list when it begins, so elements appended by f are not visited.
+func (c *compiler) compBuiltin(call *ir.Call, b *ir.Builtin) string {
+	if b.Name() == "len" {
+		if t, ok := call.Args[0].Type().(*ir.Array); ok {
+			// the length of an array is constant so avoid copying it
+			if _, ok := call.Args[0].(*ir.Var); !ok {
+				c.compDiscard(call.Args[0])
+			}
+			return fmt.Sprintf("%d", t.Len)
+		}
+	}
+
+	args := make([]string, len(call.Args))
+	for i, a := range call.Args {
+		c.tmp++
+		args[i] = fmt.Sprintf("calc_arg%d", c.tmp)
+		c.emit("%s %s = %s;\n", cType(a.Type()), args[i], c.compObject(a))
+	}
+
+	c.tmp++
+	r, i := fmt.Sprintf("calc_r%d", c.tmp), fmt.Sprintf("calc_i%d", c.tmp)
+	switch b.Name() {
+	case "list":
+		t := cType(call.Type().(*ir.List).Elem)
+		c.emit("calc_list *%s = calc_list_new(sizeof(%s));\n", r, t)
+		for _, a := range args {
+			c.emit("*(%s *)calc_list_push(%s) = %s;\n", t, r, a)
+		}
+		return r
+	case "range":
+		t := cType(call.Args[0].Type())
+		c.emit("calc_list *%s = calc_list_new(sizeof(%s));\n", r, t)
+		c.emit("for (%s %s = %s; %s < %s; %s++)\n", t, i, args[0], i, args[1], i)
+		c.emit("*(%s *)calc_list_push(%s) = %s;\n", t, r, i)
+		return r
+	}
+
+	xs, f := args[0], args[len(args)-1]
+	t := cType(call.Args[0].Type().(*ir.List).Elem)
+	switch b.Name() {
+	case "append":
+		for _, a := range args[1:] {
+			c.emit("*(%s *)calc_list_push(%s) = %s;\n", t, xs, a)
+		}
+		return xs
+	case "get":
+		return fmt.Sprintf("((%s *)%s->data)[%s(%s, %s->len, %s)]", t, xs,
+			indexFunc(call.Args[1].Type()), args[1], xs,
+			c.position(call.Args[1].Pos()))
+	case "len":
+		return fmt.Sprintf("((int32_t)%s->len)", xs)
+	case "slice":
+		bound := func(a ir.Object, s string) string {
+			if ir.IsUnsigned(a.Type()) {
+				return "calc_bound_u(" + s + ")"
+			}
+			return s
+		}
+		return fmt.Sprintf("calc_list_slice(%s, %s, %s, %s)", xs,
+			bound(call.Args[1], args[1]), bound(call.Args[2], args[2]),
+			c.position(call.Pos()))
+	}
+
+	elem := fmt.Sprintf("((%s *)%s->data)[%s]", t, xs, i)
+	apply := func(args ...string) string {
+		s := f + ".fn(" + f + ".env"
+		for _, a := range args {
+			s += ", " + a
+		}
+		return s + ")"
+	}
+	loop := fmt.Sprintf("for (int64_t %s = 0, calc_n%d = %s->len; %s < calc_n%d; "+
+		"%s++)", i, c.tmp, xs, i, c.tmp, i)
+
+	switch b.Name() {
+	case "all":
+		c.emit("bool %s = true;\n", r)
+		c.emit("%s\nif (!%s) { %s = false; break; }\n", loop, apply(elem), r)
+	case "any":
+		c.emit("bool %s = false;\n", r)
+		c.emit("%s\nif (%s) { %s = true; break; }\n", loop, apply(elem), r)
+	case "count":
+		c.emit("%s %s = 0;\n", cType(call.Type()), r)
+		c.emit("%s\nif (%s) %s++;\n", loop, apply(elem), r)
+	case "filter":
+		c.emit("calc_list *%s = calc_list_new(sizeof(%s));\n", r, t)
+		c.emit("%s\nif (%s) *(%s *)calc_list_push(%s) = %s;\n", loop,
+			apply(elem), t, r, elem)
+	case "fold":
+		c.emit("%s %s = %s;\n", cType(call.Type()), r, args[1])
+		c.emit("%s\n%s = %s;\n", loop, r, apply(r, elem))
+	case "map":
+		u := cType(call.Type().(*ir.List).Elem)
+		c.emit("calc_list *%s = calc_list_new(sizeof(%s));\n", r, u)
+		c.emit("%s {\n%s v = %s;\n*(%s *)calc_list_push(%s) = v;\n}\n", loop, u,
+			apply(elem), u, r)
+	case "reduce":
+		c.emit("if (%s->len == 0) calc_panic(%s, \"reduce of empty list\");\n",
+			xs, c.position(call.Pos()))
+		c.emit("%s %s = ((%s *)%s->data)[0];\n", t, r, t, xs)
+		c.emit("for (int64_t %s = 1, calc_n%d = %s->len; %s < calc_n%d; %s++)\n",
+			i, c.tmp, xs, i, c.tmp, i)
+		c.emit("%s = %s;\n", r, apply(r, elem))
+	}
+	return r
+}
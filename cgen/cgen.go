@@ -11,8 +11,11 @@ package comp
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/rthornton128/calc/ast"
@@ -22,15 +25,55 @@ import (
 )
 
 type compiler struct {
-	fp     *os.File
-	fset   *token.FileSet
-	errors token.ErrorList
+	fp      *os.File
+	fset    *token.FileSet
+	errors  token.ErrorList
+	checked bool
+
+	// funcs holds function expressions waiting to be emitted once the
+	// function currently being compiled is complete
+	fn      *ir.Function
+	funcs   []*ir.Function
+	emitted map[*ir.Function]bool
+	tmp     int
+
+	// labels holds the labels a break or continue has jumped to, which
+	// must be emitted at the end of the loop
+	labels map[string]bool
+
+	// tails holds the tail calls compiled as jumps, by the function they
+	// call, and loops the functions jumped to the start of by a tail call.
+	// tramps holds the trampoline of each function which has one.
+	tails  map[*ir.Call]*ir.Function
+	loops  map[*ir.Function]bool
+	tramps map[*ir.Function]*trampoline
+}
+
+// Options control how Calc source is compiled to C
+type Options struct {
+	// Optimize folds constants, inlines small functions and removes dead
+	// code before generating code
+	Optimize bool
+	// InlineSize, when optimizing, is the largest size of the body of a
+	// function whose calls are inlined. Zero disables inlining
+	InlineSize int
+	// Checked traps integer overflow at runtime rather than wrapping around
+	Checked bool
+	// Trampoline runs mutually recursive tail calls through a trampoline so
+	// that, like a function calling itself in tail position, they do not
+	// grow the stack
+	Trampoline bool
+	// Report, if not nil, has each tail call compiled as a jump written to it
+	Report io.Writer
+	// PrintIR, if not nil, has the IR of the package written to it before
+	// and after it is optimized
+	PrintIR io.Writer
 }
 
 // CompileFile generates a C source file for the corresponding file
 // specified by path. The .calc extension for the filename in path is
 // replaced with .c for the C source output.
-func CompileFile(path string, opt bool) error {
+func CompileFile(path string, opts Options) error {
 	fset := token.NewFileSet()
 	f, err := parse.ParseFile(fset, path, "")
 	if err != nil {
@@ -41,37 +84,14 @@ func CompileFile(path string, opt bool) error {
 		Files: []*ast.File{f},
 	}, filepath.Base(path))
 
-	if err := ir.TypeCheck(pkg, fset); err != nil {
-		return err
-	}
-	if opt {
-		pkg = ir.FoldConstants(pkg).(*ir.Package)
-	}
-	//ir.Tag(pkg)
-
 	path = path[:len(path)-len(filepath.Ext(path))]
-	fp, err := os.Create(path + ".c")
-	if err != nil {
-		return err
-	}
-	defer fp.Close()
-
-	c := &compiler{fp: fp}
-
-	c.emitHeaders()
-	c.compPackage(pkg)
-	c.emitMain()
-
-	if c.errors.Count() != 0 {
-		return c.errors
-	}
-	return nil
+	return compile(pkg, fset, path+".c", opts)
 }
 
 // CompileDir generates C source code for the Calc sources found in the
 // directory specified by path. The C source file uses the same name as
 // directory rather than any individual file.
-func CompileDir(path string, opt bool) error {
+func CompileDir(path string, opts Options) error {
 	fset := token.NewFileSet()
 	p, err := parse.ParseDir(fset, path)
 	if err != nil {
@@ -79,25 +99,40 @@ func CompileDir(path string, opt bool) error {
 	}
 
 	pkg := ir.MakePackage(p, filepath.Base(path))
+	return compile(pkg, fset, filepath.Join(path, filepath.Base(path))+".c",
+		opts)
+}
+
+// compile type checks pkg and generates the C source file out for it
+func compile(pkg *ir.Package, fset *token.FileSet, out string,
+	opts Options) error {
 	if err := ir.TypeCheck(pkg, fset); err != nil {
 		return err
 	}
-	if opt {
-		pkg = ir.FoldConstants(pkg).(*ir.Package)
+	if opts.PrintIR != nil {
+		printIR(opts.PrintIR, "before optimization", pkg)
+	}
+	if opts.Optimize {
+		optimize(pkg, opts)
+	}
+	if opts.PrintIR != nil {
+		printIR(opts.PrintIR, "after optimization", pkg)
 	}
 	//ir.Tag(pkg)
 
-	fp, err := os.Create(filepath.Join(path, filepath.Base(path)) + ".c")
+	fp, err := os.Create(out)
 	if err != nil {
 		return err
 	}
 	defer fp.Close()
 
-	c := &compiler{fp: fp, fset: fset}
+	c := &compiler{fp: fp, fset: fset, checked: opts.Checked,
+		emitted: make(map[*ir.Function]bool), labels: make(map[string]bool)}
+	c.planTailCalls(ir.TailCalls(pkg), opts)
 
-	c.emitHeaders()
+	c.emitHeaders(pkg)
 	c.compPackage(pkg)
-	c.emitMain()
+	c.emitMain(pkg)
 
 	if c.errors.Count() != 0 {
 		return c.errors
@@ -105,17 +140,85 @@ func CompileDir(path string, opt bool) error {
 	return nil
 }
 
+// optimize folds the constants of pkg, inlines small functions and removes
+// dead code. Constants are folded again after each since both may leave
+// more to fold.
+func optimize(pkg *ir.Package, opts Options) {
+	ir.FoldConstants(pkg)
+	if opts.InlineSize > 0 {
+		ir.Inline(pkg, opts.InlineSize)
+		ir.FoldConstants(pkg)
+	}
+	ir.EliminateDeadCode(pkg)
+	ir.FoldConstants(pkg)
+}
+
+// printIR writes heading, as a comment, and then each define of pkg, in
+// order of name, to w
+func printIR(w io.Writer, heading string, pkg *ir.Package) {
+	fmt.Fprintf(w, "; %s\n", heading)
+	names := pkg.Scope().Names()
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintln(w, pkg.Scope().Lookup(name))
+	}
+}
+
 /* Utility */
 
 func cType(t ir.Type) string {
 	switch t {
-	case ir.Int:
+	case ir.Int8:
+		return "int8_t"
+	case ir.Int16:
+		return "int16_t"
+	case ir.Int32:
 		return "int32_t"
+	case ir.Int64:
+		return "int64_t"
+	case ir.Uint8:
+		return "uint8_t"
+	case ir.Uint16:
+		return "uint16_t"
+	case ir.Uint32:
+		return "uint32_t"
+	case ir.Uint64:
+		return "uint64_t"
 	case ir.Bool:
 		return "bool"
-	default:
-		return "int"
+	case ir.Float:
+		return "double"
+	}
+	switch t.(type) {
+	case *ir.Array:
+		return fmt.Sprintf("calc_array%d", ir.TypeID(t))
+	case *ir.Named:
+		return fmt.Sprintf("calc_struct%d", ir.TypeID(t))
+	case *ir.Signature:
+		return fmt.Sprintf("calc_func%d", ir.TypeID(t))
+	case *ir.List:
+		return "calc_list *"
+	}
+	return "int"
+}
+
+// zeroValue returns a C expression for the zero value of type t
+func zeroValue(t ir.Type) string {
+	switch t := t.(type) {
+	case *ir.Array, *ir.Named:
+		return fmt.Sprintf("calc_zero%d()", ir.TypeID(t))
+	case *ir.Signature:
+		return fmt.Sprintf("((%s){ NULL, NULL })", cType(t))
+	case *ir.List:
+		return fmt.Sprintf("calc_list_new(sizeof(%s))", cType(t.Elem))
 	}
+	return "0"
+}
+
+// typeSuffix returns the suffix of the runtime helpers used for arithmetic
+// on numbers of type t, which is the same as the Calc type name
+func typeSuffix(t ir.Type) string {
+	return t.String()
 }
 
 // Error adds an error to the compiler at the given position. The remaining
@@ -132,15 +235,181 @@ func (c *compiler) emitln(args ...interface{}) {
 	fmt.Fprintln(c.fp, args...)
 }
 
-func (c *compiler) emitHeaders() {
+// emitHeaders emits the C headers, the runtime and the composite types of
+// package p
+func (c *compiler) emitHeaders(p *ir.Package) {
 	c.emitln("#include <stdio.h>")
 	c.emitln("#include <stdint.h>")
+	c.emitln("#include <inttypes.h>")
 	c.emitln("#include <stdbool.h>")
+	c.emitln("#include <stdlib.h>")
+	c.emitln("#include <string.h>")
+	c.emit("static const bool calc_checked = %t;\n", c.checked)
+	c.emit("%s\n", runtime)
+
+	// arrays and structs are declared before any type is defined since
+	// they may refer to each other through functions and lists
+	types := p.CompositeTypes()
+	for _, t := range types {
+		switch t.(type) {
+		case *ir.Array, *ir.Named:
+			c.emit("typedef struct %s %s;\n", cType(t), cType(t))
+		}
+	}
+
+	// function values pair a C function with the environment holding the
+	// variables it captured
+	for _, t := range types {
+		if sig, ok := t.(*ir.Signature); ok {
+			params := []string{"void **"}
+			for _, p := range sig.Params {
+				params = append(params, cType(p))
+			}
+			c.emit("typedef struct { %s (*fn)(%s); void **env; } %s;\n",
+				cType(sig.Result), strings.Join(params, ", "), cType(t))
+		}
+	}
+
+	// arrays are wrapped in a struct so that they are copied like any other
+	// value. The types of the elements and fields of an array or struct are
+	// defined before it.
+	defined := make(map[ir.Type]bool)
+	var order []ir.Type
+	var define func(t ir.Type)
+	define = func(t ir.Type) {
+		if defined[t] {
+			return
+		}
+		switch u := t.Underlying().(type) {
+		case *ir.Array:
+			defined[t] = true
+			define(u.Elem)
+			c.emit("struct %s { %s a[%d]; };\n", cType(t), cType(u.Elem), u.Len)
+		case *ir.Struct:
+			defined[t] = true
+			for _, f := range u.Fields {
+				define(f.Type)
+			}
+			c.emit("struct %s {", cType(t))
+			for _, f := range u.Fields {
+				c.emit(" %s _%s;", cType(f.Type), f.Name)
+			}
+			if len(u.Fields) == 0 {
+				c.emit(" char calc_empty;")
+			}
+			c.emit(" };\n")
+		case *ir.Union:
+			// a union is a struct of the index of its variant, the tag, and
+			// a C union of the fields of each variant
+			defined[t] = true
+			for _, v := range u.Variants {
+				for _, f := range v.Fields {
+					define(f.Type)
+				}
+			}
+			c.emit("struct %s { int32_t tag; union {", cType(t))
+			for _, v := range u.Variants {
+				if len(v.Fields) == 0 {
+					continue
+				}
+				c.emit(" struct {")
+				for _, f := range v.Fields {
+					if boxed(t, f) {
+						c.emit(" %s *_%s;", cType(f.Type), f.Name)
+						continue
+					}
+					c.emit(" %s _%s;", cType(f.Type), f.Name)
+				}
+				c.emit(" } _%s;", v.Name)
+			}
+			c.emit(" char calc_empty; } u; };\n")
+		default:
+			return
+		}
+		order = append(order, t)
+	}
+	for _, t := range types {
+		define(t)
+	}
+
+	for _, t := range order {
+		c.emit("static inline %s calc_zero%d(void) {\n", cType(t), ir.TypeID(t))
+		c.emit("%s z;\nmemset(&z, 0, sizeof(z));\n", cType(t))
+		switch u := t.Underlying().(type) {
+		case *ir.Array:
+			c.emit("for (int64_t i = 0; i < %d; i++) z.a[i] = %s;\n", u.Len,
+				zeroValue(u.Elem))
+		case *ir.Struct:
+			for _, f := range u.Fields {
+				c.emit("z._%s = %s;\n", f.Name, zeroValue(f.Type))
+			}
+		case *ir.Union:
+			// the zero value of a union is the zero value of its first variant
+			v := u.Variants[0]
+			for _, f := range v.Fields {
+				c.emit("z.u._%s._%s = %s;\n", v.Name, f.Name, zeroValue(f.Type))
+			}
+		}
+		c.emit("return z;\n}\n")
+	}
+
+	for _, t := range order {
+		u, ok := t.Underlying().(*ir.Union)
+		if !ok || !hasBoxed(t, u) {
+			continue
+		}
+		c.emit("static inline %s *calc_box%d(%s v) {\n", cType(t), ir.TypeID(t),
+			cType(t))
+		c.emit("%s *p = calc_alloc(sizeof(%s));\n*p = v;\nreturn p;\n}\n",
+			cType(t), cType(t))
+	}
+}
+
+// boxed returns true if field f of union t is of type t itself. Such a
+// field points to a copy of the union on the heap since the union would
+// otherwise contain itself. Unions are never modified once constructed, so
+// the copy may be shared. The first variant, which is the zero value, never
+// has a boxed field.
+func boxed(t ir.Type, f ir.Field) bool {
+	return f.Type == t
+}
+
+// hasBoxed returns true if union u, the underlying type of t, has a boxed
+// field
+func hasBoxed(t ir.Type, u *ir.Union) bool {
+	for _, v := range u.Variants {
+		for _, f := range v.Fields {
+			if boxed(t, f) {
+				return true
+			}
+		}
+	}
+	return false
 }
 
-func (c *compiler) emitMain() {
+// position returns the source position p as a quoted C string
+func (c *compiler) position(p token.Pos) string {
+	return strconv.Quote(c.fset.Position(p).String())
+}
+
+func (c *compiler) emitMain(p *ir.Package) {
 	c.emitln("int main(void) {")
-	c.emitln("printf(\"%d\\n\", _main());")
+	var t ir.Type
+	if m := p.Scope().Lookup("main"); m != nil {
+		if sig, ok := m.Type().(*ir.Signature); ok {
+			t = sig.Result
+		}
+	}
+	switch {
+	case ir.IsUnsigned(t):
+		c.emit("printf(\"%%\" PRIu64 \"\\n\", (uint64_t)_main.fn(_main.env));\n")
+	case ir.IsInteger(t):
+		c.emit("printf(\"%%\" PRId64 \"\\n\", (int64_t)_main.fn(_main.env));\n")
+	case t == ir.Float:
+		c.emit("printf(\"%%.15g\\n\", _main.fn(_main.env));\n")
+	case t == ir.Bool:
+		c.emit("printf(\"%%d\\n\", _main.fn(_main.env));\n")
+	}
 	c.emitln("return 0;")
 	c.emitln("}")
 }
@@ -149,81 +418,386 @@ func (c *compiler) emitMain() {
 
 func (c *compiler) compObject(o ir.Object) string {
 	switch t := o.(type) {
+	case *ir.ArrayLit:
+		return c.compArrayLit(t)
 	case *ir.Assignment:
 		return c.compAssignment(t)
 	case *ir.Constant:
 		return c.compConstant(t)
 	case *ir.Binary:
 		return c.compBinary(t)
+	case *ir.Branch:
+		return c.compBranch(t)
 	case *ir.Call:
 		return c.compCall(t)
+	case *ir.Cond:
+		return c.compCond(t)
+	case *ir.Conversion:
+		return c.compConversion(t)
 	case *ir.For:
 		return c.compFor(t)
-	//case *ir.Function:
-	//return c.compFunction(t)
+	case *ir.Function:
+		return c.compClosure(t)
 	case *ir.If:
 		return c.compIf(t)
+	case *ir.Instance:
+		return fmt.Sprintf("((%s){ f%d, NULL })", cType(t.Type()), t.Func.ID())
+	case *ir.Match:
+		return c.compMatch(t)
+	case *ir.Selector:
+		return fmt.Sprintf("%s._%s", c.compObject(t.X), t.Sel)
+	case *ir.StructLit:
+		return c.compStructLit(t)
+	case *ir.Switch:
+		return c.compSwitch(t)
 	case *ir.Unary:
 		return c.compUnary(t)
+	case *ir.UnionLit:
+		return c.compUnionLit(t)
 	case *ir.Var:
 		return c.compVar(t)
 	case *ir.Variable:
-		c.emit("%s %s%d = 0;\n", cType(t.Type()), t.Name(), t.ID())
+		c.emit("%s %s%d = %s;\n", cType(t.Type()), t.Name(), t.ID(),
+			zeroValue(t.Type()))
 		return c.compVariable(t)
 	}
 	return ""
 }
 
+// compDiscard compiles an expression whose value is unused, such as any
+// but the last expression in a body, keeping its side effects
+func (c *compiler) compDiscard(o ir.Object) {
+	if s := c.compObject(o); s != "" {
+		c.emit("(void)%s;\n", s)
+	}
+}
+
+func (c *compiler) compArrayLit(a *ir.ArrayLit) string {
+	elems := make([]string, len(a.Elems))
+	for i, e := range a.Elems {
+		elems[i] = c.compObject(e)
+	}
+	return fmt.Sprintf("((%s){ { %s } })", cType(a.Type()),
+		strings.Join(elems, ", "))
+}
+
+func (c *compiler) compStructLit(s *ir.StructLit) string {
+	args := make([]string, len(s.Args))
+	for i, a := range s.Args {
+		args[i] = c.compObject(a)
+	}
+	if len(args) == 0 {
+		args = append(args, "0")
+	}
+	return fmt.Sprintf("((%s){ %s })", cType(s.Type()), strings.Join(args, ", "))
+}
+
+func (c *compiler) compUnionLit(u *ir.UnionLit) string {
+	v := u.Type().Underlying().(*ir.Union).Variants[u.Index]
+	if len(v.Fields) == 0 {
+		return fmt.Sprintf("((%s){ .tag = %d })", cType(u.Type()), u.Index)
+	}
+	args := make([]string, len(u.Args))
+	for i, a := range u.Args {
+		args[i] = c.compObject(a)
+		if boxed(u.Type(), v.Fields[i]) {
+			args[i] = fmt.Sprintf("calc_box%d(%s)", ir.TypeID(u.Type()), args[i])
+		}
+	}
+	return fmt.Sprintf("((%s){ .tag = %d, .u._%s = { %s } })", cType(u.Type()),
+		u.Index, v.Name, strings.Join(args, ", "))
+}
+
 func (c *compiler) compAssignment(a *ir.Assignment) string {
-	c.emit("%s%d = %s;\n", a.Lhs, a.Scope().Lookup(a.Lhs).ID(),
-		c.compObject(a.Rhs))
-	return a.Lhs
+	o := a.Scope().Lookup(a.Lhs)
+	lhs, t := c.compName(o), o.Type()
+	for _, f := range a.Fields {
+		lhs += "._" + f
+		s := t.Underlying().(*ir.Struct)
+		t = s.Fields[s.Field(f)].Type
+	}
+	if a.Index != nil {
+		n := lhs + "->len"
+		if at, ok := t.(*ir.Array); ok {
+			n = fmt.Sprintf("%d", at.Len)
+		}
+		c.tmp++
+		c.emit("int64_t calc_idx%d = %s;\n", c.tmp, c.compBounds(a.Index, n))
+		lhs = fmt.Sprintf("%s[calc_idx%d]", elems(lhs, t), c.tmp)
+	}
+	c.emit("%s = %s;\n", lhs, c.compObject(a.Rhs))
+	return lhs
+}
+
+// compBounds returns index as an int64_t, checking at runtime that it is
+// less than the length n
+func (c *compiler) compBounds(index ir.Object, n string) string {
+	return fmt.Sprintf("%s(%s, %s, %s)", indexFunc(index.Type()),
+		c.compObject(index), n, c.position(index.Pos()))
+}
+
+// indexFunc returns the runtime function checking an index of type t
+func indexFunc(t ir.Type) string {
+	if ir.IsUnsigned(t) {
+		return "calc_index_u"
+	}
+	return "calc_index"
+}
+
+// elems returns a C expression for the elements of the array or list x of
+// type t, which may be indexed
+func elems(x string, t ir.Type) string {
+	if l, ok := t.(*ir.List); ok {
+		return fmt.Sprintf("((%s *)%s->data)", cType(l.Elem), x)
+	}
+	return x + ".a"
 }
 
 func (c *compiler) compBinary(b *ir.Binary) string {
+	var fn string
+	switch b.Op {
+	case token.ADD:
+		fn = "add"
+	case token.SUB:
+		fn = "sub"
+	case token.MUL:
+		fn = "mul"
+	case token.QUO:
+		fn = "quo"
+	case token.REM:
+		fn = "rem"
+	case token.SHL:
+		fn = "shl"
+	case token.SHR:
+		fn = "shr"
+	}
+	if fn != "" && b.Type() != ir.Float {
+		rhs := c.compObject(b.Rhs)
+		// shift counts are passed as int64_t, whatever their type
+		if (b.Op == token.SHL || b.Op == token.SHR) && ir.IsUnsigned(b.Rhs.Type()) {
+			rhs = fmt.Sprintf("calc_bound_u(%s)", rhs)
+		}
+		return fmt.Sprintf("calc_%s_%s(%s, %s, %s)", fn, typeSuffix(b.Type()),
+			c.compObject(b.Lhs), rhs, c.position(b.Pos()))
+	}
 	return fmt.Sprintf("(%s %s %s)",
 		c.compObject(b.Lhs), b.Op.String(), c.compObject(b.Rhs))
 }
 
 func (c *compiler) compCall(call *ir.Call) string {
-	args := make([]string, len(call.Args))
-	for i, a := range call.Args {
-		args[i] = fmt.Sprintf("%s", c.compObject(a))
+	if call.IsIndex() {
+		return c.compIndex(call)
 	}
-	return fmt.Sprintf("_%s(%s)", call.Name(), strings.Join(args, ","))
+	if callee, ok := c.tails[call]; ok {
+		return c.compTailCall(call, callee)
+	}
+	if v, ok := call.Func.(*ir.Var); ok {
+		if b, ok := v.Scope().Lookup(v.Name()).(*ir.Builtin); ok {
+			return c.compBuiltin(call, b)
+		}
+	}
+	fn := c.compObject(call.Func)
+
+	// evaluate the function only once unless it is simply the name of a
+	// function, instance or parameter
+	_, name := call.Func.(*ir.Instance)
+	if v, ok := call.Func.(*ir.Var); ok {
+		switch o := v.Scope().Lookup(v.Name()).(type) {
+		case *ir.Define:
+			name = o.Kind() == ast.FuncDecl
+		case *ir.Param:
+			name = true
+		}
+	}
+	if !name {
+		c.tmp++
+		c.emit("%s calc_fn%d = %s;\n", cType(call.Func.Type()), c.tmp, fn)
+		fn = fmt.Sprintf("calc_fn%d", c.tmp)
+	}
+
+	args := []string{fn + ".env"}
+	for _, a := range call.Args {
+		args = append(args, c.compObject(a))
+	}
+	return fmt.Sprintf("%s.fn(%s)", fn, strings.Join(args, ","))
+}
+
+// compIndex compiles the element of an array indexed by call. The array is
+// first stored in a temporary unless it is a variable.
+func (c *compiler) compIndex(call *ir.Call) string {
+	a := c.compObject(call.Func)
+	if _, ok := call.Func.(*ir.Var); !ok {
+		c.tmp++
+		c.emit("%s calc_a%d = %s;\n", cType(call.Func.Type()), c.tmp, a)
+		a = fmt.Sprintf("calc_a%d", c.tmp)
+	}
+	n := fmt.Sprintf("%d", call.Func.Type().(*ir.Array).Len)
+	return fmt.Sprintf("%s.a[%s]", a, c.compBounds(call.Args[0], n))
+}
+
+// compClosure declares function f, which is emitted once the enclosing
+// function is complete, and returns a function value for it. The
+// parameters captured by f are shared with it through an environment of
+// pointers to their storage.
+func (c *compiler) compClosure(f *ir.Function) string {
+	c.emit("%s;\n", c.compSignature(f))
+	if !c.emitted[f] {
+		c.emitted[f] = true
+		c.funcs = append(c.funcs, f)
+	}
+
+	env := "NULL"
+	if captures := f.Captures(); len(captures) != 0 {
+		c.tmp++
+		env = fmt.Sprintf("calc_env%d", c.tmp)
+		c.emit("void **%s = calc_alloc(%d * sizeof(void *));\n", env,
+			len(captures))
+		for i, p := range captures {
+			c.emit("%s[%d] = %s;\n", env, i, c.compBox(p))
+		}
+	}
+	return fmt.Sprintf("((%s){ f%d, %s })", cType(f.Type()), f.ID(), env)
 }
 
 func (c *compiler) compConstant(con *ir.Constant) string {
-	return con.String()
+	v := con.Value().String()
+	switch con.Type() {
+	case ir.Float:
+		if !strings.ContainsAny(v, ".e") {
+			return v + ".0"
+		}
+	case ir.Int64:
+		if v == "-9223372036854775808" {
+			return "INT64_MIN"
+		}
+		return fmt.Sprintf("INT64_C(%s)", v)
+	case ir.Uint32:
+		return v + "U"
+	case ir.Uint64:
+		return fmt.Sprintf("UINT64_C(%s)", v)
+	}
+	return v
+}
+
+func (c *compiler) compConversion(con *ir.Conversion) string {
+	if con.Args[0].Type() == ir.Float && con.Type() != ir.Float {
+		return fmt.Sprintf("calc_ftoi_%s(%s, %s)", typeSuffix(con.Type()),
+			c.compObject(con.Args[0]), c.position(con.Pos()))
+	}
+	return fmt.Sprintf("((%s)%s)", cType(con.Type()), c.compObject(con.Args[0]))
 }
 
 func (c *compiler) compDefine(d *ir.Define) string {
 	switch t := d.Body.(type) {
 	case *ir.Function:
-		c.emit("%s {\n", c.compSignature(t))
-		c.compFunction(d.Body.(*ir.Function))
+		c.compFuncDecl(t)
 		return ""
 	default:
 		return c.compObject(t)
 	}
 }
 
+// compCond compiles cond o as a chain of if-else statements. Each test is
+// compiled inside the else branch of the one before it so it is only
+// evaluated if every earlier test was false.
+func (c *compiler) compCond(o *ir.Cond) string {
+	c.emit("%s cond%d = %s;\n", cType(o.Type()), o.ID(), zeroValue(o.Type()))
+	for _, cl := range o.Clauses {
+		c.emit("if (%s) {\n", c.compObject(cl.Test))
+		c.emit("cond%d = %s;\n", o.ID(), c.compObject(cl.Body))
+		c.emitln("} else {")
+	}
+	if o.Else != nil {
+		c.emit("cond%d = %s;\n", o.ID(), c.compObject(o.Else))
+	}
+	c.emitln(strings.Repeat("}", len(o.Clauses)))
+	return fmt.Sprintf("cond%d", o.ID())
+}
+
+// compFor compiles loop f. The condition is evaluated at the start of each
+// iteration, since compiling it may emit statements, and the result of the
+// loop is the last expression of the final iteration unless it is left by
+// a break with a value.
 func (c *compiler) compFor(f *ir.For) string {
-	c.emit("%s %s%d = 0;\n", cType(f.Type()), f.Name(), f.ID())
-	c.emit("while (%s) {\n", c.compObject(f.Cond))
+	c.emit("%s %s%d = %s;\n", cType(f.Type()), f.Name(), f.ID(),
+		zeroValue(f.Type()))
+	c.emitln("while (1) {")
+	c.emit("if (!%s) {\nbreak;\n}\n", c.compObject(f.Cond))
 	for _, e := range f.Body[:len(f.Body)-1] {
-		c.compObject(e)
+		c.compDiscard(e)
 	}
-	c.emit("}\n%s%d = %s;\n", f.Name(), f.ID(),
+	c.emit("%s%d = %s;\n", f.Name(), f.ID(),
 		c.compObject(f.Body[len(f.Body)-1]))
+	if label := branchLabel(token.CONTINUE, f); c.labels[label] {
+		c.emit("%s: ;\n", label)
+	}
+	c.emitln("}")
+	if label := branchLabel(token.BREAK, f); c.labels[label] {
+		c.emit("%s: ;\n", label)
+	}
 	return fmt.Sprintf("%s%d", f.Name(), f.ID())
 }
 
+// compBranch compiles b as a jump to the end of the body of its loop, for a
+// continue, or past the loop, for a break. Branches may be nested in a C
+// switch statement, so goto is used rather than break and continue.
+func (c *compiler) compBranch(b *ir.Branch) string {
+	if b.Value != nil {
+		c.emit("%s%d = %s;\n", b.Loop.Name(), b.Loop.ID(), c.compObject(b.Value))
+	}
+	label := branchLabel(b.Tok, b.Loop)
+	c.labels[label] = true
+	c.emit("goto %s;\n", label)
+	return zeroValue(b.Type())
+}
+
+func branchLabel(tok token.Token, f *ir.For) string {
+	return fmt.Sprintf("calc_%s%d", tok, f.ID())
+}
+
 func (c *compiler) compFunction(f *ir.Function) {
+	fn := c.fn
+	c.fn = f
+	if len(f.Captures()) == 0 {
+		c.emitln("(void)calc_env;")
+	}
+	tramp := c.tramps[f]
+	if tramp != nil {
+		for _, p := range f.Params {
+			c.emit("%s %s%d = calc_s->%s%d;\n", cType(p.Type()), p.Name(), p.ID(),
+				p.Name(), p.ID())
+		}
+	}
+	// a tail call of f to itself jumps here, before its parameters are
+	// boxed, so that a function capturing them keeps those of its call
+	if c.loops[f] {
+		c.emit("calc_tail%d: ;\n", f.ID())
+	}
+	for _, p := range f.Params {
+		if p.Captured() {
+			c.emit("%s *%s = calc_alloc(sizeof(%s));\n", cType(p.Type()),
+				c.compBox(p), cType(p.Type()))
+			c.emit("*%s = %s%d;\n", c.compBox(p), p.Name(), p.ID())
+		}
+	}
 	for _, e := range f.Body[:len(f.Body)-1] {
-		c.compObject(e)
+		c.compDiscard(e)
+	}
+	result := c.compObject(f.Body[len(f.Body)-1])
+	if tramp != nil {
+		c.emit("calc_s->result = %s;\ncalc_s->next = NULL;\n}\n", result)
+	} else {
+		c.emit("return %s;\n}\n", result)
+	}
+	c.fn = fn
+
+	// emit any function expressions found in the body
+	for len(c.funcs) > 0 {
+		f := c.funcs[0]
+		c.funcs = c.funcs[1:]
+		c.emit("%s {\n", c.compSignature(f))
+		c.compFunction(f)
 	}
-	c.emit("return %s;\n}\n", c.compObject(f.Body[len(f.Body)-1]))
 }
 
 func (c *compiler) compIdent(i *ir.Var) string {
@@ -231,7 +805,8 @@ func (c *compiler) compIdent(i *ir.Var) string {
 }
 
 func (c *compiler) compIf(i *ir.If) string {
-	c.emit("%s if%d = 0; /* %s */\n", cType(i.Type()), i.ID(), i.Name())
+	c.emit("%s if%d = %s; /* %s */\n", cType(i.Type()), i.ID(),
+		zeroValue(i.Type()), i.Name())
 	c.emit("if (%s) {\n", c.compObject(i.Cond))
 	c.emit("if%d = %s;\n", i.ID(), c.compObject(i.Then))
 	if i.Else != nil {
@@ -242,58 +817,194 @@ func (c *compiler) compIf(i *ir.If) string {
 	return fmt.Sprintf("if%d", i.ID())
 }
 
+// compMatch compiles match m as a switch on the tag of the union matched.
+// The fields of the variant are copied to the parameters of an arm before
+// its body is evaluated.
+func (c *compiler) compMatch(m *ir.Match) string {
+	c.emit("%s match%d = %s;\n", cType(m.Type()), m.ID(), zeroValue(m.Type()))
+	c.emit("%s calc_m%d = %s;\n", cType(m.X.Type()), m.ID(),
+		c.compObject(m.X))
+	c.emit("switch (calc_m%d.tag) {\n", m.ID())
+	for _, a := range m.Arms {
+		if a.IsDefault() {
+			c.emitln("default: {")
+		} else {
+			c.emit("case %d: {\n", a.Ctor.Index)
+		}
+		for i, p := range a.Params {
+			f := a.Ctor.Variant().Fields[i]
+			v := fmt.Sprintf("calc_m%d.u._%s._%s", m.ID(), a.Name, f.Name)
+			if boxed(m.X.Type(), f) {
+				v = "*" + v
+			}
+			if p.Captured() {
+				c.emit("%s *%s = calc_alloc(sizeof(%s));\n", cType(p.Type()),
+					c.compBox(p), cType(p.Type()))
+				c.emit("*%s = %s;\n", c.compBox(p), v)
+				continue
+			}
+			c.emit("%s %s%d = %s;\n", cType(p.Type()), p.Name(), p.ID(), v)
+		}
+		c.emit("match%d = %s;\n", m.ID(), c.compObject(a.Body))
+		c.emitln("break;\n}")
+	}
+	c.emitln("}")
+	return fmt.Sprintf("match%d", m.ID())
+}
+
 func (c *compiler) compPackage(p *ir.Package) {
+	c.emitTrampolines()
 	names := p.Scope().Names()
 	for _, name := range names {
 		// later, this may need to check for import clauses
 		if d, ok := p.Scope().Lookup(name).(*ir.Define); ok {
+			if f, ok := d.Body.(*ir.Function); ok && f.IsGeneric() {
+				for _, inst := range f.Instances {
+					c.emitted[inst] = true
+					c.emit("%s;\n", c.compSignature(inst))
+					defer c.compFuncDecl(inst)
+				}
+				continue
+			}
 			if f, ok := d.Body.(*ir.Function); ok {
+				c.emitted[f] = true
 				c.emit("%s;\n", c.compSignature(f))
-				params := make([]string, len(f.Params))
-				for i, p := range f.Params {
-					params[i] = cType(f.Scope().Lookup(p.Name()).(*ir.Param).Type())
-				}
-				c.emit("%s (*_%s)(%s) = f%d;\n", cType(f.Type()), d.Name(),
-					strings.Join(params, ","), f.ID())
+				c.emit("%s _%s = { f%d, NULL };\n", cType(f.Type()), d.Name(),
+					f.ID())
 				defer c.compDefine(d)
 			}
 		}
 	}
 }
 
+// compFuncDecl emits function f, which is bound by a define or is an
+// instance of a generic function
+func (c *compiler) compFuncDecl(f *ir.Function) {
+	c.emit("%s {\n", c.compSignature(f))
+	if t := c.tramps[f]; t != nil {
+		c.compTrampoline(f, t)
+	}
+	c.compFunction(f)
+}
+
 func (c *compiler) compSignature(f *ir.Function) string {
-	params := make([]string, len(f.Params))
-	for i, p := range f.Params {
+	params := []string{"void **calc_env"}
+	for _, p := range f.Params {
 		param := f.Scope().Lookup(p.Name()).(*ir.Param)
-		params[i] = fmt.Sprintf("%s %s%d", cType(param.Type()), param.Name(),
-			param.ID())
+		params = append(params, fmt.Sprintf("%s %s%d", cType(param.Type()),
+			param.Name(), param.ID()))
 	}
-	return fmt.Sprintf("%s f%d(%s)", cType(f.Type()), f.ID(),
+	return fmt.Sprintf("%s f%d(%s)", cType(f.Result()), f.ID(),
 		strings.Join(params, ","))
 }
 
+func (c *compiler) compSwitch(s *ir.Switch) string {
+	c.emit("%s switch%d = %s;\n", cType(s.Type()), s.ID(), zeroValue(s.Type()))
+	c.emit("switch (%s) {\n", c.compObject(s.Tag))
+	for _, cl := range s.Clauses {
+		c.emit("case %s: {\n", c.compObject(cl.Test))
+		c.emit("switch%d = %s;\n", s.ID(), c.compObject(cl.Body))
+		c.emitln("break;\n}")
+	}
+	if s.Else != nil {
+		c.emitln("default: {")
+		c.emit("switch%d = %s;\n", s.ID(), c.compObject(s.Else))
+		c.emitln("break;\n}")
+	}
+	c.emitln("}")
+	return fmt.Sprintf("switch%d", s.ID())
+}
+
 func (c *compiler) compUnary(u *ir.Unary) string {
-	return fmt.Sprintf("%s%s", u.Op, c.compObject(u.Rhs))
+	var fn string
+	switch u.Op {
+	case token.ADD:
+		fn = "abs"
+	case token.SUB:
+		fn = "neg"
+	case token.BNOT:
+		return fmt.Sprintf("((%s)~%s)", cType(u.Type()), c.compObject(u.Rhs))
+	case token.NOT:
+		return fmt.Sprintf("(!%s)", c.compObject(u.Rhs))
+	}
+	return fmt.Sprintf("calc_%s_%s(%s, %s)", fn, typeSuffix(u.Type()),
+		c.compObject(u.Rhs), c.position(u.Pos()))
 }
 
 func (c *compiler) compVar(v *ir.Var) string {
-	switch t := v.Scope().Lookup(v.Name()).(type) {
+	return c.compName(v.Scope().Lookup(v.Name()))
+}
+
+// compName returns the value of the define or parameter o. Functions bound
+// by a define are referred to by the pointer declared in compPackage.
+func (c *compiler) compName(o ir.Object) string {
+	switch t := o.(type) {
 	case *ir.Define:
+		if _, ok := t.Body.(*ir.Function); ok {
+			return "_" + t.Name()
+		}
 		return c.compDefine(t)
 	case *ir.Param:
-		return fmt.Sprintf("%s%d", t.Name(), t.ID())
+		return c.compParam(t)
 	}
 	panic("unreachable")
 }
 
+// compParam returns the value of parameter p. Captured parameters are
+// stored on the heap, so they may outlive the function or var declaring
+// them, and are used through a pointer.
+func (c *compiler) compParam(p *ir.Param) string {
+	if i := c.captureIndex(p); i >= 0 {
+		return fmt.Sprintf("(*(%s *)calc_env[%d])", cType(p.Type()), i)
+	}
+	if p.Captured() {
+		return fmt.Sprintf("(*%s)", c.compBox(p))
+	}
+	return fmt.Sprintf("%s%d", p.Name(), p.ID())
+}
+
+// compBox returns a pointer to the storage of captured parameter p
+func (c *compiler) compBox(p *ir.Param) string {
+	if i := c.captureIndex(p); i >= 0 {
+		return fmt.Sprintf("calc_env[%d]", i)
+	}
+	return fmt.Sprintf("%s%d_box", p.Name(), p.ID())
+}
+
+// captureIndex returns the index of p in the environment of the function
+// being compiled or -1 if the function did not capture it
+func (c *compiler) captureIndex(p *ir.Param) int {
+	if c.fn == nil {
+		return -1
+	}
+	for i, captured := range c.fn.Captures() {
+		if captured == p {
+			return i
+		}
+	}
+	return -1
+}
+
 func (c *compiler) compVariable(v *ir.Variable) string {
-	for _, p := range v.Params {
+	for i, p := range v.Params {
 		param := v.Scope().Lookup(p.Name()).(*ir.Param)
-		c.emit("%s %s%d = 0;\n", cType(param.Type()), param.Name(), param.ID())
+		init := zeroValue(param.Type())
+		if v.Inits != nil {
+			init = c.compObject(v.Inits[i])
+		}
+		if param.Captured() {
+			c.emit("%s *%s = calc_alloc(sizeof(%s));\n", cType(param.Type()),
+				c.compBox(param), cType(param.Type()))
+			c.emit("*%s = %s;\n", c.compBox(param), init)
+			continue
+		}
+		c.emit("%s %s%d = %s;\n", cType(param.Type()), param.Name(), param.ID(),
+			init)
 	}
 	for _, e := range v.Body[:len(v.Body)-1] {
-		c.compObject(e)
+		c.compDiscard(e)
 	}
-	c.emit("var%d = %s;\n", v.ID(), c.compObject(v.Body[len(v.Body)-1]))
-	return fmt.Sprintf("var%d", v.ID())
+	c.emit("%s%d = %s;\n", v.Name(), v.ID(),
+		c.compObject(v.Body[len(v.Body)-1]))
+	return fmt.Sprintf("%s%d", v.Name(), v.ID())
 }
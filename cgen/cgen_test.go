@@ -8,6 +8,7 @@
 package comp_test
 
 import (
+	"bytes"
 	"io/ioutil"
 	"os"
 	"os/exec"
@@ -43,6 +44,33 @@ func TestFunc(t *testing.T) {
 		"(define main (func:int (fn 1 2)))", "3")
 }
 
+func TestFunctionValue(t *testing.T) {
+	tests := []struct{ src, expected string }{
+		{"(define inc (func (a:int):int (+ a 1)))\n" +
+			"(define apply (func (f:(func int):int a:int):int (f a)))\n" +
+			"(define main (func:int (apply inc 41)))", "42"},
+		{"(define apply (func (f:(func int):int a:int):int (f a)))\n" +
+			"(define main (func:int (apply (func (a:int):int (* a 2)) 21)))",
+			"42"},
+		{"(define inc (func (a:int):int (+ a 1)))\n" +
+			"(define dec (func (a:int):int (- a 1)))\n" +
+			"(define pick (func (up:bool):(func int):int " +
+			"(if up:(func int):int inc dec)))\n" +
+			"(define main (func:int (var (f:(func int):int):int " +
+			"(= f (pick false)) (f 1))))", "0"},
+		{"(define inc (func (a:int):int (+ a 1)))\n" +
+			"(define f inc)\n" +
+			"(define main (func:int (f (f 1))))", "3"},
+		{"(define doubler (func:(func int):int (func (a:int):int (+ a a))))\n" +
+			"(define main (func:int (var (g:(func int):int):int " +
+			"(= g (doubler)) (g 4))))", "8"},
+	}
+	for _, test := range tests {
+		test_handler(t, test.src, test.expected)
+		test_optimized(t, test.src, test.expected)
+	}
+}
+
 func TestIfThenElse(t *testing.T) {
 	test_handler(t, "(define main (func:int (if true :int 99)))", "99")
 	test_handler(t, "(define main (func:int (if false :int 2 3)))", "3")
@@ -51,27 +79,701 @@ func TestIfThenElse(t *testing.T) {
 		"(var (a:int):int (if (< a 3):int 1 3))))", "1")
 }
 
+func TestCond(t *testing.T) {
+	sign := "(define sign (func (n:int):int " +
+		"(cond:int ((< n 0) -1) ((> n 0) 1))))\n"
+	tests := []struct{ src, expected string }{
+		{sign + "(define main (func:int (+ (* 100 (sign -5)) (* 10 (sign 7)) " +
+			"(sign 0))))", "-90"},
+		{"(define fib (func (n:int):int (cond:int ((<= n 0) 0) ((== n 1) 1) " +
+			"(else (+ (fib (- n 1)) (fib (- n 2)))))))\n" +
+			"(define main (func:int (fib 10)))", "55"},
+		{"(define f (func (n:int):int (var (calls:int):int " +
+			"(cond:int ((< (= calls (+ calls 1)) 0) 1) " +
+			"((< (= calls (+ calls 1)) 0) 2) (else calls)))))\n" +
+			"(define main (func:int (f 0)))", "2"},
+	}
+	for _, test := range tests {
+		test_handler(t, test.src, test.expected)
+		test_optimized(t, test.src, test.expected)
+	}
+}
+
+func TestSwitch(t *testing.T) {
+	days := "(define days (func (m:u8):int (switch m:int " +
+		"(2 28) (4 30) (6 30) (9 30) (11 30) (else 31))))\n"
+	tests := []struct{ src, expected string }{
+		{days + "(define main (func:int (+ (days (u8 1)) (days (u8 2)) " +
+			"(days (u8 4)))))", "89"},
+		{"(define main (func:int (var (n:i64):int (= n -3) " +
+			"(switch n:int (-3 (switch (+ n 4):int (1 7))) (3 1)))))", "7"},
+		{"(define main (func:int (switch 2:int (1 1))))", "0"},
+	}
+	for _, test := range tests {
+		test_handler(t, test.src, test.expected)
+		test_optimized(t, test.src, test.expected)
+	}
+}
+
+func TestFor(t *testing.T) {
+	find := "(define find (func (xs:[]int x:int):int (var (i:int):int " +
+		"(for (< i (len xs)):int (if (== (get xs i) x):int (break i)) " +
+		"(= i (+ i 1)) -1))))\n"
+	tests := []struct{ src, expected string }{
+		{"(define main (func:int (var (i:int):int " +
+			"(for (< i 5):int (= i (+ i 1))))))", "5"},
+		{find + "(define main (func:int (find (list 5 6 7) 7)))", "2"},
+		{find + "(define main (func:int (find (list 5 6 7) 8)))", "-1"},
+		{"(define main (func:int (var (i:int s:int):int " +
+			"(for (< i 10):int (= i (+ i 1)) " +
+			"(switch (% i 2):int (0 (continue))) (= s (+ s i))))))", "25"},
+		{"(define main (func:int (var (i:int):int (for true:int " +
+			"(= i (+ i 1)) (cond:int ((> i 6) (break (* i 10)))) 0))))", "70"},
+		{"(define main (func:int (var (i:int j:int n:int):int " +
+			"(for (< i 3):int (= i (+ i 1)) (= j 0) " +
+			"(for true:int (= j (+ j 1)) (if (> j i):int (break)) " +
+			"(= n (+ n 1)))))))", "6"},
+		{"(define main (func:int (var (i:int):int (for (< i 3):int " +
+			"(= i (+ i 1)) (match (None):int ((Some v) v) (_ (break 42)))))))\n" +
+			"(define Opt (union (Some v:int) (None)))", "42"},
+	}
+	for _, test := range tests {
+		test_handler(t, test.src, test.expected)
+		test_optimized(t, test.src, test.expected)
+	}
+}
+
+func TestInference(t *testing.T) {
+	tests := []struct{ src, expected string }{
+		{"(define sq (func (x:u8) (* x x)))\n" +
+			"(define main (func () (int (sq (u8 20)))))", "144"},
+		{"(define fact:int (func (n:int) (if (<= n 1) 1 " +
+			"(* n (fact (- n 1))))))\n" +
+			"(define main (func () (fact 5)))", "120"},
+		{"(define main (func () (var (i:int) " +
+			"(for (< i 10) (= i (+ i 1)) (if (== i 4) (break (* i 100))) i))))",
+			"400"},
+		{"(define pick (func (b:bool) (if b (u8 3) 255)))\n" +
+			"(define main (func () (int (+ (pick true) (pick false)))))", "2"},
+		{"(define adder (func (n:int) (func (x:int) (+ x n))))\n" +
+			"(define main (func () ((adder 2) 40)))", "42"},
+	}
+	for _, test := range tests {
+		test_handler(t, test.src, test.expected)
+		test_optimized(t, test.src, test.expected)
+	}
+}
+
+func TestGeneric(t *testing.T) {
+	const max = "(define max (func [T] (a:T b:T):T (if (> a b) a b)))\n"
+	tests := []struct{ src, expected string }{
+		{max + "(define main (func () (max 3 4)))", "4"},
+		{max + "(define main (func () (+ (int (max (u8 200) 100)) " +
+			"(int (max (i64 1) 2)) (max 1 2))))", "204"},
+		{"(define size (func [T] (xs:[]T):int (len xs)))\n" +
+			"(define main (func () (+ (size (list true)) (size (list 1 2)))))",
+			"3"},
+		{"(define twice (func [T] (f:(func T):T x:T) (f (f x))))\n" +
+			"(define inc (func (x:i64):i64 (+ x 1)))\n" +
+			"(define main (func () (int (twice inc 40))))", "42"},
+		{"(define id (func [T] (x:T) x))\n" +
+			"(define apply (func [A B] (f:(func A):B a:A):B (f a)))\n" +
+			"(define main (func () (apply id[int] 7)))", "7"},
+		{"(define zero (func [T] ():T 0))\n" +
+			"(define main (func () (int (zero[u8]))))", "0"},
+	}
+	for _, test := range tests {
+		test_handler(t, test.src, test.expected)
+		test_optimized(t, test.src, test.expected)
+	}
+}
+
+func TestTailCalls(t *testing.T) {
+	// each would overflow the stack unless its tail calls are jumps
+	tests := []struct{ src, expected string }{
+		{"(define sum (func (n:i64 acc:i64):i64 " +
+			"(if (== n 0) acc (sum (- n 1) (+ acc n)))))\n" +
+			"(define main (func () (int (/ (sum 10000000 0) 10000000))))",
+			"5000000"},
+		{"(define down (func [T] (n:T acc:T):T (cond ((== n 0) acc) " +
+			"(else (down (- n 1) (+ acc 1))))))\n" +
+			"(define main (func () (int (down (i64 10000000) 0))))", "10000000"},
+		{"(define last (func (n:int f:(func int):int):int (match (Some n) " +
+			"((Some v) (if (== v 0) (f 1) (last (- v 1) (func (x:int) (+ x v))))) " +
+			"(_ 0))))\n" +
+			"(define O (union (Some v:int) (None)))\n" +
+			"(define main (func () (last 10000000 (func (x:int) x))))", "2"},
+	}
+	for _, test := range tests {
+		test_handler(t, test.src, test.expected)
+		test_optimized(t, test.src, test.expected)
+	}
+
+	defer tearDown()
+	src := "(define even (func (n:int):bool (if (== n 0) true (odd (- n 1)))))\n" +
+		"(define odd (func (n:int):bool (if (== n 0) false (even (- n 1)))))\n" +
+		"(define main (func:int (if (even 10000000) 1 0)))"
+	var report bytes.Buffer
+	build(t, src, comp.Options{Trampoline: true, Report: &report})
+	output, err := run()
+	if err != nil || string(output) != "1" {
+		t.Fatal("For "+src+" expected 1 got", string(output), err)
+	}
+	expected := "test.calc:1:51: tail call to 'odd' compiled through a " +
+		"trampoline\ntest.calc:2:52: tail call to 'even' compiled through a " +
+		"trampoline\n"
+	if report.String() != expected {
+		t.Fatal("For " + src + " expected report " + expected + " got " +
+			report.String())
+	}
+}
+
+func TestInline(t *testing.T) {
+	tests := []struct{ src, expected string }{
+		{"(define square (func (n:int):int (* n n)))\n" +
+			"(define sum (func (x:int y:int):int (+ (square x) (square y))))\n" +
+			"(define main (func:int (sum 5 3)))", "34"},
+		{"(define n 5)\n(define addn (func (x:int):int (+ x n)))\n" +
+			"(define main (func:int (let ((n 1)) (addn n))))", "6"},
+		{"(define twice (func (x:int):int (+ x x)))\n" +
+			"(define main (func:int (var (a:int) (= a 3) (twice (twice a)))))",
+			"12"},
+		{"(define dec (func (n:int):int (- n 1)))\n" +
+			"(define count (func (n:int acc:int):int " +
+			"(if (== n 0) acc (count (dec n) (+ acc 1)))))\n" +
+			"(define main (func:int (count 10000000 0)))", "10000000"},
+		{"(define id (func [T] (x:T):T x))\n" +
+			"(define main (func:int (id (id 7))))", "7"},
+		{"(define add (func (x:int y:int):int (+ x y)))\n" +
+			"(define main (func:int (var (a:int) (= a 1) (add a (= a 100)))))",
+			"200"},
+		{"(define add (func (x:int y:int):int (+ x y)))\n" +
+			"(define inc (func (p:int):int (+ p 1)))\n" +
+			"(define main (func:int (var (a:int) (= a 1) " +
+			"(add (inc a) (= a (inc 4))))))", "11"},
+	}
+	for _, test := range tests {
+		// inlining must not change the result
+		test_handler(t, test.src, test.expected)
+		func() {
+			defer tearDown()
+			build(t, test.src, comp.Options{Optimize: true, InlineSize: 10})
+			output, err := run()
+			if err != nil || string(output) != test.expected {
+				t.Fatal("For "+test.src+" inlined expected "+test.expected+" got",
+					string(output), err)
+			}
+		}()
+	}
+}
+
+func TestDeadCode(t *testing.T) {
+	tests := []struct{ src, expected string }{
+		{"(define a 0)\n(define b (+ 3 4))\n(define c (if true :int 1))\n" +
+			"(define unused (func (n:int):int (* n 2)))\n" +
+			"(define f (func (n:int):int n (for false n) " +
+			"(cond ((== a 1) 2) ((< a 1) (+ n b)) (else 9))))\n" +
+			"(define main (func:int (+ (f c) (if (> b 5) 10 20))))", "18"},
+		{"(define main (func:int (var (i:int) " +
+			"(for true (= i (+ i 1)) (if (< 3 4) (break i) 0) 1))))", "1"},
+		{"(define main (func:int (var (i:int) " +
+			"(for (< i 5) (= i (+ i 1)) (if (> 3 4) (continue) i)))))", "5"},
+	}
+	for _, test := range tests {
+		test_optimized(t, test.src, test.expected)
+	}
+
+	defer tearDown()
+	src := "(define two (func:int 2))\n(define unused (func:int 3))\n" +
+		"(define main (func:int (if false 1 (two))))"
+	var ir bytes.Buffer
+	build(t, src, comp.Options{Optimize: true, PrintIR: &ir})
+	expected := "; before optimization\n" +
+		"define main[(func):i32] {func:(func):i32 () {{if[i32] false then 1 " +
+		"else {call: two ()}}}}\n" +
+		"define two[(func):i32] {func:(func):i32 () {2}}\n" +
+		"define unused[(func):i32] {func:(func):i32 () {3}}\n" +
+		"; after optimization\n" +
+		"define main[(func):i32] {func:(func):i32 () {{call: two ()}}}\n" +
+		"define two[(func):i32] {func:(func):i32 () {2}}\n"
+	if ir.String() != expected {
+		t.Fatal("For " + src + " expected IR " + expected + " got " + ir.String())
+	}
+}
+
 func TestVarAndAssign(t *testing.T) {
 	test_handler(t, "(define main (func:int (var (a:int):int (= a 42) a)))",
 		"42")
 }
 
+func TestLet(t *testing.T) {
+	tests := []struct{ src, expected string }{
+		{"(define main (func:int (let ((a 2) (b (* a 3)) (a (+ a b))) " +
+			"(- a b))))", "2"},
+		{"(define main (func:int (let ((n (u8 250)) (m (+ n (u8 10)))) " +
+			"(int m))))", "4"},
+		{"(define add (func (n:int):(func int):int " +
+			"(let ((total n)) (func (x:int):int (= total (+ total x)) total))))\n" +
+			"(define main (func:int (let ((f (add 10))) (f 1) (f 2))))", "13"},
+		{"(define P (struct (x:int y:int)))\n" +
+			"(define main (func:int (let ((p (P 1 2)) (xs (list p p))) " +
+			"(= p.x 5) (+ p.x (get xs 0).x))))", "6"},
+	}
+	for _, test := range tests {
+		test_handler(t, test.src, test.expected)
+		test_optimized(t, test.src, test.expected)
+	}
+}
+
 func TestUnary(t *testing.T) {
 	test_handler(t, "(define main (func:int -24))", "-24")
 	test_handler(t, "(define main (func:int\n"+
 		"(var (z:int):int (= z 12) -z)))", "-12")
 	test_handler(t, "(define fn (func (num:int):int -num))\n"+
 		"(define main (func:int (fn -42)))", "42")
+	test_handler(t, "(define fn (func (done:bool):int (if !done:int 1 0)))\n"+
+		"(define main (func:int (fn false)))", "1")
+	test_handler(t, "(define fn (func (a:int):bool (not (== a 0))))\n"+
+		"(define main (func:int (if (fn 0):int 1 0)))", "0")
+	test_optimized(t, "(define main (func:int (if !(< 1 2):int 1 0)))", "0")
+}
+
+func TestOverflow(t *testing.T) {
+	tests := []struct{ src, expected string }{
+		{"(define main (func:int (+ (int 2147483647) 1)))", "-2147483648"},
+		{"(define main (func:int (- (int 0) 2147483647 2)))", "2147483647"},
+		{"(define main (func:int (* (int 65536) 65536)))", "0"},
+		{"(define main (func:int -(int (- 0 2147483647 1))))", "-2147483648"},
+		{"(define fn (func (a:int b:int):int (% a b)))\n" +
+			"(define main (func:int (fn (- 0 2147483647 1) -1)))", "0"},
+	}
+	for _, test := range tests {
+		test_handler(t, test.src, test.expected)
+		test_optimized(t, test.src, test.expected)
+	}
+}
+
+func TestSizedInteger(t *testing.T) {
+	tests := []struct{ src, expected string }{
+		{"(define main (func:u8 (+ (u8 200) (u8 100))))", "44"},
+		{"(define main (func:i64 (* (i64 4294967296) 3)))", "12884901888"},
+		{"(define main (func:u64 (u64 18446744073709551615)))",
+			"18446744073709551615"},
+		{"(define main (func:i64 (- (i64 0) 9223372036854775807 1)))",
+			"-9223372036854775808"},
+		{"(define fn (func (a:int):i8 (i8 a)))\n" +
+			"(define main (func:i8 (fn 200)))", "-56"},
+		{"(define fn (func (a:u16 b:u16):u16 (/ a b)))\n" +
+			"(define main (func:int (i32 (fn 65535 2))))", "32767"},
+		{"(define main (func:u32 -(u32 1)))", "4294967295"},
+	}
+	for _, test := range tests {
+		test_handler(t, test.src, test.expected)
+		test_optimized(t, test.src, test.expected)
+	}
+}
+
+func TestFloat(t *testing.T) {
+	tests := []struct{ src, expected string }{
+		{"(define main (func:float 1.5))", "1.5"},
+		{"(define main (func:float (/ 1.0 4)))", "0.25"},
+		{"(define main (func:float (/ 3 2.0)))", "1.5"},
+		{"(define main (func:float (* 1e3 2.5)))", "2500"},
+		{"(define fn (func (a:float b:float):float (- a b)))\n" +
+			"(define main (func:float (fn 0.5 2)))", "-1.5"},
+		{"(define fn (func (a:float):int (i32 a)))\n" +
+			"(define main (func:int (fn -2.75)))", "-2"},
+		{"(define fn (func (a:int):float (/ (float a) 2)))\n" +
+			"(define main (func:float (fn 3)))", "1.5"},
+		{"(define abs (func (a:float):float +a))\n" +
+			"(define main (func:float (abs -0.125)))", "0.125"},
+		{"(define main (func:int (if (< 0.1 0.2):int 1 0)))", "1"},
+	}
+	for _, test := range tests {
+		test_handler(t, test.src, test.expected)
+		test_optimized(t, test.src, test.expected)
+	}
+	test_checked(t, "(define fn (func (a:float):u8 (u8 a)))\n"+
+		"(define main (func:u8 (fn 256.0)))",
+		"test.calc:1:31: runtime error: float out of range of integer conversion")
+}
+
+func TestIntegerLiteral(t *testing.T) {
+	tests := []struct{ src, expected string }{
+		{"(define main (func:int 0x2A))", "42"},
+		{"(define main (func:int (+ 0o17 0b1_0)))", "17"},
+		{"(define main (func:u8 (u8 0xFF)))", "255"},
+		{"(define main (func:int 1_000_000))", "1000000"},
+		{"(define main (func:u64 (u64 0xFFFF_FFFF_FFFF_FFFF)))",
+			"18446744073709551615"},
+	}
+	for _, test := range tests {
+		test_handler(t, test.src, test.expected)
+		test_optimized(t, test.src, test.expected)
+	}
+}
+
+func TestBitwise(t *testing.T) {
+	tests := []struct{ src, expected string }{
+		{"(define fn (func (a:int b:int):int (& a b)))\n" +
+			"(define main (func:int (fn 12 10)))", "8"},
+		{"(define fn (func (a:int b:int):int (| a b 1)))\n" +
+			"(define main (func:int (fn 12 10)))", "15"},
+		{"(define fn (func (a:u8 b:u8):u8 (^ a b)))\n" +
+			"(define main (func:u8 (fn 0xFF 0x0F)))", "240"},
+		{"(define fn (func (a:u8):u8 ~a))\n" +
+			"(define main (func:u8 (fn 0x0F)))", "240"},
+		{"(define fn (func (a:int n:int):int (<< a n)))\n" +
+			"(define main (func:int (fn 1 31)))", "-2147483648"},
+		{"(define fn (func (a:int n:int):int (<< a n)))\n" +
+			"(define main (func:int (fn 1 32)))", "0"},
+		{"(define fn (func (a:i8 n:i8):i8 (>> a n)))\n" +
+			"(define main (func:i8 (fn -128 100)))", "-1"},
+		{"(define fn (func (a:u64 n:u64):u64 (>> a n)))\n" +
+			"(define main (func:u64 (fn 0xFFFF_FFFF_FFFF_FFFF 60)))", "15"},
+		{"(define main (func:i64 (<< (i64 1) 40)))", "1099511627776"},
+		{"(define main (func:i8 (>> (i8 -1) 200)))", "-1"},
+		{"(define main (func:int (<< (int 1) (u8 3))))", "8"},
+		{"(define fn (func (a:i8 n:u64):i8 (<< a n)))\n" +
+			"(define main (func:i8 (fn 1 0xFFFF_FFFF_FFFF_FFFF)))", "0"},
+		{"(define fn (func (a:u64 n:i8):u64 (>> a n)))\n" +
+			"(define main (func:u64 (fn 256 4)))", "16"},
+	}
+	for _, test := range tests {
+		test_handler(t, test.src, test.expected)
+		test_optimized(t, test.src, test.expected)
+	}
+
+	// shifts discard bits rather than trap in checked mode
+	src := "(define fn (func (a:int n:int):int (<< a n)))\n" +
+		"(define main (func:int (fn (int 0x7FFFFFFF) 1)))"
+	build(t, src, comp.Options{Checked: true})
+	output, err := run()
+	tearDown()
+	if err != nil || strings.TrimSpace(string(output)) != "-2" {
+		t.Fatalf("For %s expected -2 got %s (%v)", src, output, err)
+	}
+	test_checked(t, "(define fn (func (a:int n:int):int (>> a n)))\n"+
+		"(define main (func:int (fn 8 -1)))",
+		"test.calc:1:36: runtime error: negative shift amount")
+}
+
+func TestLambda(t *testing.T) {
+	tests := []struct{ src, expected string }{
+		{"(define main (func:int ((func (x:int):int (* x x)) 4)))", "16"},
+		{"(define adder (func (n:int):(func int):int " +
+			"(func (x:int):int (+ x n))))\n" +
+			"(define main (func:int ((adder 5) 10)))", "15"},
+		{"(define compose (func (f:(func int):int g:(func int):int)" +
+			":(func int):int (func (x:int):int (f (g x)))))\n" +
+			"(define main (func:int ((compose (func (x:int):int (* x 2)) " +
+			"(func (x:int):int (+ x 1))) 4)))", "10"},
+		{"(define main (func:int (var (n:int):int (= n 3) " +
+			"((func (x:int):int (+ x n)) 4))))", "7"},
+	}
+	for _, test := range tests {
+		test_handler(t, test.src, test.expected)
+		test_optimized(t, test.src, test.expected)
+	}
+}
+
+func TestClosure(t *testing.T) {
+	tests := []struct{ src, expected string }{
+		{"(define adder (func (n:int):(func int):int " +
+			"(func (x:int):int (+ x n))))\n" +
+			"(define main (func:int (var (f:(func int):int):int " +
+			"(= f (adder 5)) (f 10))))", "15"},
+		{"(define counter (func:(func int):int (var (c:int):(func int):int " +
+			"(func (x:int):int (= c (+ c x)) c))))\n" +
+			"(define main (func:int (var (f:(func int):int):int " +
+			"(= f (counter)) (f 1) (f 2) (f 3))))", "6"},
+		{"(define main (func:int (var (n:int inc:(func int):int):int " +
+			"(= inc (func (x:int):int (= n (+ n x)) n)) (inc 2) (= n 10) " +
+			"(inc 1))))", "11"},
+		{"(define f (func (a:int):int (var (g:(func int):int):int " +
+			"(= g (func (b:int):int (var (h:(func int):int):int " +
+			"(= h (func (c:int):int (+ a b c))) (h 3)))) (g 2))))\n" +
+			"(define main (func:int (f 1)))", "6"},
+		{"(define main (func:int (var (fact:(func int):int):int " +
+			"(= fact (func (n:int):int (if (< n 2):int 1 " +
+			"(* n (fact (- n 1)))))) (fact 5))))", "120"},
+		{"(define main (func:int (var (xs:[]int f:(func):int) " +
+			"(= f (func:int (len xs))) (append xs 1 2) (f))))", "2"},
+		{"(define T (struct (xs:[]int)))\n" +
+			"(define main (func:int (var (t:T f:(func):int) " +
+			"(= f (func:int (len t.xs))) (append t.xs 1 2 3) (f))))", "3"},
+	}
+	for _, test := range tests {
+		test_handler(t, test.src, test.expected)
+		test_optimized(t, test.src, test.expected)
+	}
+}
+
+func TestArray(t *testing.T) {
+	tests := []struct{ src, expected string }{
+		{"(define main (func:int (var (a:[3]int):int (= a [1 2 3]) " +
+			"(= a 1 (+ (a 1) 40)) (+ (a 0) (a 1) (len a)))))", "46"},
+		{"(define set (func (a:[2]int):[2]int (= a 0 9) a))\n" +
+			"(define main (func:int (var (a:[2]int b:[2]int):int " +
+			"(= b (set a)) (+ (a 0) (b 0)))))", "9"},
+		{"(define main (func:u8 (var (a:[2]u8):u8 (= a [250 1]) " +
+			"(= a 0 (+ (a 0) 10)) (a (u8 0)))))", "4"},
+		{"(define main (func:int (var (m:[2][2]int fs:[1](func int):int):int " +
+			"(= m 1 [3 4]) (= fs 0 (func (x:int):int (* x ((m 1) 1)))) " +
+			"((fs 0) 10))))", "40"},
+		{"(define main (func:int (var (a:[2][]int):int " +
+			"(count (a 1) (func (x:int):bool true)))))", "0"},
+	}
+	for _, test := range tests {
+		test_handler(t, test.src, test.expected)
+		test_optimized(t, test.src, test.expected)
+	}
+	test_checked(t, "(define main (func:int (var (a:[3]int i:int):int "+
+		"(= i 3) (a i))))",
+		"test.calc:1:60: runtime error: index out of range [3] with length 3")
+	test_checked(t, "(define main (func:int (var (a:[3]int i:u64):int "+
+		"(= i ~i) (= a i 0))))",
+		"test.calc:1:63: runtime error: index out of range "+
+			"[18446744073709551615] with length 3")
+}
+
+func TestList(t *testing.T) {
+	tests := []struct{ src, expected string }{
+		{"(define main (func:int (var (xs:[]int):int (= xs (list 1 2)) " +
+			"(append xs 3 4) (+ (get xs 3) (len xs)))))", "8"},
+		{"(define push (func (xs:[]int x:int):[]int (append xs x)))\n" +
+			"(define main (func:int (var (xs:[]int ys:[]int):int " +
+			"(= ys xs) (push ys 7) (push xs 8) (+ (get xs 0) (len ys)))))", "9"},
+		{"(define main (func:int (var (xs:[]int s:[]int):int " +
+			"(= xs (list 1 2 3 4)) (= s (slice xs 1 3)) (= s 0 20) " +
+			"(+ (get xs 1) (get s 0) (get s 1) (len s)))))", "27"},
+		{"(define main (func:int (var (xs:[]int):int (= xs (range 0 100)) " +
+			"(append xs 100) (fold xs 0 (func (a:int b:int):int (+ a b))))))",
+			"5050"},
+		{"(define main (func:int (var (xs:[]int):int (= xs (list 1 2)) " +
+			"(count xs (func (x:int):bool (append xs x) true)))))", "2"},
+		{"(define main (func:int (len (slice (list 1 2) (u8 2) (u8 2)))))",
+			"0"},
+		{"(define f (func (xs:[]int):int (len (append xs 1 2))))\n" +
+			"(define main (func:int (f (list))))", "2"},
+		{"(define main (func:int (len (fold (range 0 3) (list) " +
+			"(func (a:[]int x:int):[]int (append a x))))))", "3"},
+	}
+	for _, test := range tests {
+		test_handler(t, test.src, test.expected)
+		test_optimized(t, test.src, test.expected)
+	}
+	test_checked(t, "(define main (func:int (get (list 1 2) 2)))",
+		"test.calc:1:39: runtime error: index out of range [2] with length 2")
+	test_checked(t, "(define main (func:int (var (xs:[]int):int (= xs 0 1))))",
+		"test.calc:1:49: runtime error: index out of range [0] with length 0")
+	test_checked(t, "(define main (func:int (len (slice (list 1 2) 1 3))))",
+		"test.calc:1:29: runtime error: slice bounds out of range [1:3] with "+
+			"length 2")
+}
+
+func TestStruct(t *testing.T) {
+	point := "(define Point (struct (x:int y:int)))\n"
+	tests := []struct{ src, expected string }{
+		{point + "(define main (func:int (var (p:Point):int " +
+			"(= p (Point 1 2)) (= p.x (+ p.x 40)) (+ p.x p.y))))", "43"},
+		{point + "(define move (func (p:Point):Point (= p.x 9) p))\n" +
+			"(define main (func:int (var (p:Point):int " +
+			"(+ p.x (move p).x))))", "9"},
+		{"(define Line (struct (a:Point b:Point pts:[2]Point)))\n" + point +
+			"(define main (func:int (var (l:Line):int " +
+			"(= l (Line (Point 1 2) (Point 3 4) l.pts)) (= l.pts 1 l.b) " +
+			"(+ l.a.y (l.pts 1).y (l.pts 0).x))))", "6"},
+		{"(define T (struct (xs:[]int f:(func int):int)))\n" +
+			"(define main (func:int (var (t:T):int " +
+			"(= t.f (func (x:int):int (* x 2))) (append t.xs 1 2) " +
+			"(= t.xs 1 20) (t.f (+ (get t.xs 1) (len t.xs))))))", "44"},
+		{"(define List (struct (next:[]List n:int)))\n" +
+			"(define main (func:int (var (l:List):int " +
+			"(append l.next (List (slice l.next 0 0) 5)) (get l.next 0).n)))", "5"},
+		{"(define Empty (struct ()))\n" +
+			"(define main (func:int (var (e:Empty):int (= e (Empty)) 1)))", "1"},
+	}
+	for _, test := range tests {
+		test_handler(t, test.src, test.expected)
+		test_optimized(t, test.src, test.expected)
+	}
+}
+
+func TestUnion(t *testing.T) {
+	result := "(define Result (union (Ok value:int) (Err code:int msg:[]int) " +
+		"(None)))\n"
+	shape := "(define Shape (union (Circle r:int) (Rect w:int h:int)))\n" +
+		"(define area (func (s:Shape):int (match s:int " +
+		"((Circle r) (* 3 r r)) ((Rect w h) (* w h)))))\n"
+	tests := []struct{ src, expected string }{
+		{shape + "(define main (func:int (+ (area (Circle 2)) " +
+			"(area (Rect 3 4)))))", "24"},
+		{result + "(define score (func (r:Result):int (match r:int " +
+			"((Ok v) (var (f:(func int):int):int " +
+			"(= f (func (x:int):int (+ x v))) (f 100))) " +
+			"((Err c m) (+ c (len m))) (_ 0))))\n" +
+			"(define main (func:int (var (r:Result):int " +
+			"(+ (score (Ok 5)) (score (Err 7 (list 1 2))) (score (None)) " +
+			"(score r)))))", "214"},
+		{result + "(define main (func:int (var (rs:[]Result):int " +
+			"(append rs (None) (Ok 3)) (match (get rs 1):int ((Ok v) v) " +
+			"(_ 0)))))", "3"},
+		{"(define Tree (union (Leaf v:int) (Node l:Tree r:Tree)))\n" +
+			"(define sum (func (t:Tree):int (match t:int ((Leaf v) v) " +
+			"((Node l r) (+ (sum l) (sum r))))))\n" +
+			"(define main (func:int (sum (Node (Node (Leaf 1) (Leaf 2)) " +
+			"(Leaf 3)))))", "6"},
+		{"(define L (union (Nil) (Cons h:int t:L)))\n" +
+			"(define main (func:int (var (l:L):int (= l (Cons 1 (Cons 2 l))) " +
+			"(match l:int ((Cons h t) (var (f:(func):int):int " +
+			"(= f (func:int (match t:int ((Cons x _) (+ h x)) (_ 0)))) (f))) " +
+			"(_ 0)))))", "3"},
+	}
+	for _, test := range tests {
+		test_handler(t, test.src, test.expected)
+		test_optimized(t, test.src, test.expected)
+	}
+}
+
+func TestBuiltin(t *testing.T) {
+	tests := []struct{ src, expected string }{
+		{"(define add (func (a:int b:int):int (+ a b)))\n" +
+			"(define main (func:int (fold (range 1 11) 0 add)))", "55"},
+		{"(define main (func:int (count (range -5 5) " +
+			"(func (a:int):bool (< a 0)))))", "5"},
+		{"(define sq (func (a:int):int (* a a)))\n" +
+			"(define even (func (a:int):bool (== (% a 2) 0)))\n" +
+			"(define main (func:int (reduce (map (filter (range 1 10) even) sq) " +
+			"(func (a:int b:int):int (+ a b)))))", "120"},
+		{"(define main (func:bool (any (range 0 0) " +
+			"(func (a:int):bool true))))", "0"},
+		{"(define main (func:bool (all (range (u64 0) 100) " +
+			"(func (a:u64):bool (< a 100)))))", "1"},
+		{"(define main (func:float (fold (map (range 1 4) " +
+			"(func (a:int):float (/ 1.0 (float a)))) 0 " +
+			"(func (a:float b:float):float (+ a b)))))", "1.83333333333333"},
+		{"(define main (func:int (var (n:int):int " +
+			"(count (range 0 10) (func (a:int):bool (= n (+ n a)) true)) n)))",
+			"45"},
+		{"(define sum (func (xs:[]int):int " +
+			"(fold xs 0 (func (a:int b:int):int (+ a b)))))\n" +
+			"(define main (func:int (var (xs:[]int):int (sum xs))))", "0"},
+	}
+	for _, test := range tests {
+		test_handler(t, test.src, test.expected)
+		test_optimized(t, test.src, test.expected)
+	}
+	test_checked(t, "(define main (func:int (reduce (range 1 1) "+
+		"(func (a:int b:int):int a))))",
+		"test.calc:1:24: runtime error: reduce of empty list")
+}
+
+func TestChecked(t *testing.T) {
+	test_checked(t, "(define main (func:int (+ (int 2147483647) 1)))",
+		"test.calc:1:24: runtime error: integer overflow")
+	test_checked(t, "(define main (func:int (* 2 (* (int 65536) 16384))))",
+		"test.calc:1:24: runtime error: integer overflow")
+	test_checked(t, "(define main (func:int -(int (- 0 2147483647 1))))",
+		"test.calc:1:23: runtime error: integer overflow")
+	test_checked(t, "(define main (func:u8 (- (u8 0) 1)))",
+		"test.calc:1:23: runtime error: integer overflow")
+	test_checked(t, "(define main (func:i64 (* (i64 4294967296) 4294967296)))",
+		"test.calc:1:24: runtime error: integer overflow")
+	test_checked(t, "(define fn (func (a:int b:int):int (/ a b)))\n"+
+		"(define main (func:int (fn 1 0)))",
+		"test.calc:1:36: runtime error: integer divide by zero")
+	test_checked(t, "(define fn (func (a:int b:int):int (/ a b)))\n"+
+		"(define main (func:int (fn (- 0 2147483647 1) -1)))",
+		"test.calc:1:36: runtime error: integer division overflow")
+}
+
+func TestDivisionByZero(t *testing.T) {
+	defer tearDown()
+
+	src := "(define main (func:int (/ 1 0)))"
+	err := ioutil.WriteFile("test.calc", []byte(src), os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove("test.calc")
+
+	for _, opt := range []bool{false, true} {
+		err := comp.CompileFile("test.calc", comp.Options{Optimize: opt})
+		if err == nil {
+			t.Fatal("For " + src + " expected division by zero error")
+		}
+	}
+
+	// a divisor only known to be zero once defines are propagated is left
+	// to fail at runtime, whether or not the program is optimized
+	src = "(define z 0)(define main (func:int (/ 1 z)))"
+	for _, opt := range []bool{false, true} {
+		build(t, src, comp.Options{Optimize: opt})
+		output, err := run()
+		if ee, ok := err.(*exec.ExitError); ok {
+			output = []byte(strings.TrimSpace(string(ee.Stderr)))
+		}
+		expected := "test.calc:1:36: runtime error: integer divide by zero"
+		if err == nil || string(output) != expected {
+			t.Fatal("For " + src + " expected " + expected + " got " +
+				string(output))
+		}
+	}
 }
 
 func test_handler(t *testing.T, src, expected string) {
 	defer tearDown()
 
+	build(t, src, comp.Options{})
+	output, err := run()
+	if err != nil {
+		t.Fatal("For "+src+":", err)
+	}
+
+	if string(output) != expected {
+		//t.Log("len output:", len(output))
+		//t.Log("len expected:", len(expected))
+		t.Fatal("For " + src + " expected " + expected + " got " + string(output))
+	}
+}
+
+func test_optimized(t *testing.T, src, expected string) {
+	defer tearDown()
+
+	build(t, src, comp.Options{Optimize: true})
+	output, err := run()
+	if err != nil {
+		t.Fatal("For "+src+":", err)
+	}
+	if string(output) != expected {
+		t.Fatal("For " + src + " optimized expected " + expected + " got " +
+			string(output))
+	}
+}
+
+func test_checked(t *testing.T, src, expected string) {
+	defer tearDown()
+
+	build(t, src, comp.Options{Checked: true})
+	output, err := run()
+	if err == nil {
+		t.Fatal("For " + src + " expected runtime error, got " + string(output))
+	}
+	if ee, ok := err.(*exec.ExitError); ok {
+		output = []byte(strings.TrimSpace(string(ee.Stderr)))
+	}
+	if string(output) != expected {
+		t.Fatal("For " + src + " expected " + expected + " got " + string(output))
+	}
+}
+
+func build(t *testing.T, src string, opts comp.Options) {
 	err := ioutil.WriteFile("test.calc", []byte(src), os.ModePerm)
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = comp.CompileFile("test.calc", false)
+	err = comp.CompileFile("test.calc", opts)
 	if err != nil {
 		t.Log(src)
 		t.Fatal(err)
@@ -84,7 +786,11 @@ func test_handler(t *testing.T, src, expected string) {
 		t.Log(string(out))
 		t.Fatal(err)
 	}
+}
+
+func run() ([]byte, error) {
 	var output []byte
+	var err error
 
 	switch runtime.GOOS {
 	case "windows":
@@ -92,13 +798,7 @@ func test_handler(t *testing.T, src, expected string) {
 	default:
 		output, err = exec.Command("./test").Output()
 	}
-	output = []byte(strings.TrimSpace(string(output)))
-
-	if string(output) != expected {
-		//t.Log("len output:", len(output))
-		//t.Log("len expected:", len(expected))
-		t.Fatal("For " + src + " expected " + expected + " got " + string(output))
-	}
+	return []byte(strings.TrimSpace(string(output))), err
 }
 
 func tearDown() {
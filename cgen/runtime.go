@@ -0,0 +1,240 @@
+// Copyright (c) 2015, Rob Thornton
+// All rights reserved.
+// This source code is governed by a Simplied BSD-License. Please see the
+// LICENSE included in this distribution for a copy of the full license
+// or, if one is not included, you may also find a copy at
+// http://opensource.org/licenses/BSD-2-Clause
+
+package comp
+
+// runtime is the C source emitted ahead of every compiled program.
+//
+// Integer arithmetic is performed by the calc_* helpers rather than the C
+// operators so that overflow, which is undefined in C, wraps around like
+// it does in the constant folder. When calc_checked is true, overflow
+// instead aborts the program with the Calc source position of the failed
+// operation. Division by zero and dividing the minimum integer by -1
+// always abort, as does converting a float to an integer type which cannot
+// hold it. Floats follow IEEE 754 and are computed with the C operators.
+//
+// Shifts discard any bits shifted out of the integer, even in checked mode,
+// so shifting by the width of the type or more results in 0, or -1 when
+// shifting a negative integer right. Shifting by a negative count aborts.
+//
+// Indexing an array or list outside of its bounds aborts with the position
+// of the index.
+const runtime = `
+static void calc_panic(const char *pos, const char *msg) {
+	fflush(stdout);
+	fprintf(stderr, "%s: runtime error: %s\n", pos, msg);
+	exit(2);
+}
+
+static void calc_out_of_memory(void) {
+	fflush(stdout);
+	fprintf(stderr, "runtime error: out of memory\n");
+	exit(2);
+}
+
+// calc_alloc returns zeroed memory for lists, the variables captured by
+// closures and the boxed fields of unions. The memory is never freed.
+static inline void *calc_alloc(size_t n) {
+	void *p = calloc(1, n);
+	if (p == NULL)
+		calc_out_of_memory();
+	return p;
+}
+
+// calc_list holds the elements of a list, each size bytes wide. Lists are
+// shared by reference.
+typedef struct {
+	int64_t len, cap;
+	size_t size;
+	char *data;
+} calc_list;
+
+static inline calc_list *calc_list_new(size_t size) {
+	calc_list *l = calc_alloc(sizeof(calc_list));
+	l->size = size;
+	return l;
+}
+
+// calc_list_push grows list l by one element and returns a pointer to it
+static inline void *calc_list_push(calc_list *l) {
+	if (l->len == l->cap) {
+		l->cap = l->cap == 0 ? 8 : l->cap * 2;
+		l->data = realloc(l->data, l->cap * l->size);
+		if (l->data == NULL)
+			calc_out_of_memory();
+	}
+	return l->data + l->len++ * l->size;
+}
+
+// calc_list_slice returns a new list of the elements of l from lo up to,
+// but not including, hi
+static calc_list *calc_list_slice(calc_list *l, int64_t lo, int64_t hi,
+		const char *pos) {
+	if (lo < 0 || hi < lo || hi > l->len) {
+		char msg[96];
+		snprintf(msg, sizeof(msg), "slice bounds out of range [%" PRId64 ":%"
+			PRId64 "] with length %" PRId64, lo, hi, l->len);
+		calc_panic(pos, msg);
+	}
+	calc_list *s = calc_list_new(l->size);
+	s->len = s->cap = hi - lo;
+	if (s->len > 0) {
+		s->data = calc_alloc(s->len * l->size);
+		memcpy(s->data, l->data + lo * l->size, s->len * l->size);
+	}
+	return s;
+}
+
+// calc_index returns i if it is an index of an array of n elements
+static inline int64_t calc_index(int64_t i, int64_t n, const char *pos) {
+	if (i < 0 || i >= n) {
+		char msg[80];
+		snprintf(msg, sizeof(msg), "index out of range [%" PRId64 "] with "
+			"length %" PRId64, i, n);
+		calc_panic(pos, msg);
+	}
+	return i;
+}
+
+// calc_bound_u converts an unsigned index or shift count to int64_t,
+// clamping those which are out of range of every list or integer
+static inline int64_t calc_bound_u(uint64_t i) {
+	return i > INT64_MAX ? INT64_MAX : (int64_t)i;
+}
+
+static inline int64_t calc_index_u(uint64_t i, int64_t n, const char *pos) {
+	if (i >= (uint64_t)n) {
+		char msg[80];
+		snprintf(msg, sizeof(msg), "index out of range [%" PRIu64 "] with "
+			"length %" PRId64, i, n);
+		calc_panic(pos, msg);
+	}
+	return (int64_t)i;
+}
+
+#define CALC_ARITH_OPS(T, N) \
+static inline T calc_add_##N(T a, T b, const char *pos) { \
+	T r; \
+	if (__builtin_add_overflow(a, b, &r) && calc_checked) \
+		calc_panic(pos, "integer overflow"); \
+	return r; \
+} \
+static inline T calc_sub_##N(T a, T b, const char *pos) { \
+	T r; \
+	if (__builtin_sub_overflow(a, b, &r) && calc_checked) \
+		calc_panic(pos, "integer overflow"); \
+	return r; \
+} \
+static inline T calc_mul_##N(T a, T b, const char *pos) { \
+	T r; \
+	if (__builtin_mul_overflow(a, b, &r) && calc_checked) \
+		calc_panic(pos, "integer overflow"); \
+	return r; \
+} \
+static inline T calc_neg_##N(T a, const char *pos) { \
+	return calc_sub_##N(0, a, pos); \
+}
+
+#define CALC_INT_OPS(T, N, MIN) \
+CALC_ARITH_OPS(T, N) \
+static inline T calc_quo_##N(T a, T b, const char *pos) { \
+	if (b == 0) \
+		calc_panic(pos, "integer divide by zero"); \
+	if (a == MIN && b == -1) \
+		calc_panic(pos, "integer division overflow"); \
+	return a / b; \
+} \
+static inline T calc_rem_##N(T a, T b, const char *pos) { \
+	if (b == 0) \
+		calc_panic(pos, "integer divide by zero"); \
+	if (b == -1) \
+		return 0; \
+	return a % b; \
+} \
+static inline T calc_abs_##N(T a, const char *pos) { \
+	return a < 0 ? calc_neg_##N(a, pos) : a; \
+} \
+static inline T calc_shl_##N(T a, int64_t n, const char *pos) { \
+	if (n < 0) \
+		calc_panic(pos, "negative shift amount"); \
+	if (n >= (int64_t)(sizeof(T) * 8)) \
+		return 0; \
+	return (T)((uint64_t)a << n); \
+} \
+static inline T calc_shr_##N(T a, int64_t n, const char *pos) { \
+	if (n < 0) \
+		calc_panic(pos, "negative shift amount"); \
+	if (n >= (int64_t)(sizeof(T) * 8)) \
+		return a < 0 ? -1 : 0; \
+	return a >> n; \
+}
+
+#define CALC_UINT_OPS(T, N) \
+CALC_ARITH_OPS(T, N) \
+static inline T calc_quo_##N(T a, T b, const char *pos) { \
+	if (b == 0) \
+		calc_panic(pos, "integer divide by zero"); \
+	return a / b; \
+} \
+static inline T calc_rem_##N(T a, T b, const char *pos) { \
+	if (b == 0) \
+		calc_panic(pos, "integer divide by zero"); \
+	return a % b; \
+} \
+static inline T calc_abs_##N(T a, const char *pos) { \
+	(void)pos; \
+	return a; \
+} \
+static inline T calc_shl_##N(T a, int64_t n, const char *pos) { \
+	if (n < 0) \
+		calc_panic(pos, "negative shift amount"); \
+	if (n >= (int64_t)(sizeof(T) * 8)) \
+		return 0; \
+	return (T)((uint64_t)a << n); \
+} \
+static inline T calc_shr_##N(T a, int64_t n, const char *pos) { \
+	if (n < 0) \
+		calc_panic(pos, "negative shift amount"); \
+	if (n >= (int64_t)(sizeof(T) * 8)) \
+		return 0; \
+	return a >> n; \
+}
+
+#define CALC_FTOI(T, N, MIN, MAX) \
+static inline T calc_ftoi_##N(double a, const char *pos) { \
+	if (!(a - (double)MIN > -1.0 && a < (double)MAX + 1.0)) \
+		calc_panic(pos, "float out of range of integer conversion"); \
+	return (T)a; \
+}
+
+CALC_INT_OPS(int8_t, i8, INT8_MIN)
+CALC_INT_OPS(int16_t, i16, INT16_MIN)
+CALC_INT_OPS(int32_t, i32, INT32_MIN)
+CALC_INT_OPS(int64_t, i64, INT64_MIN)
+CALC_UINT_OPS(uint8_t, u8)
+CALC_UINT_OPS(uint16_t, u16)
+CALC_UINT_OPS(uint32_t, u32)
+CALC_UINT_OPS(uint64_t, u64)
+
+CALC_FTOI(int8_t, i8, INT8_MIN, INT8_MAX)
+CALC_FTOI(int16_t, i16, INT16_MIN, INT16_MAX)
+CALC_FTOI(int32_t, i32, INT32_MIN, INT32_MAX)
+CALC_FTOI(int64_t, i64, INT64_MIN, INT64_MAX)
+CALC_FTOI(uint8_t, u8, 0, UINT8_MAX)
+CALC_FTOI(uint16_t, u16, 0, UINT16_MAX)
+CALC_FTOI(uint32_t, u32, 0, UINT32_MAX)
+CALC_FTOI(uint64_t, u64, 0, UINT64_MAX)
+
+static inline double calc_neg_float(double a, const char *pos) {
+	(void)pos;
+	return -a;
+}
+static inline double calc_abs_float(double a, const char *pos) {
+	(void)pos;
+	return a < 0 ? -a : a;
+}
+`
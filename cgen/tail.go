@@ -0,0 +1,205 @@
+// Copyright (c) 2015, Rob Thornton
+// All rights reserved.
+// This source code is governed by a Simplied BSD-License. Please see the
+// LICENSE included in this distribution for a copy of the full license
+// or, if one is not included, you may also find a copy at
+// http://opensource.org/licenses/BSD-2-Clause
+
+package comp
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/rthornton128/calc/ir"
+)
+
+// trampoline runs functions which call each other in tail position. Each
+// function is compiled as a step which, rather than calling the next
+// function, stores its arguments in the state of the trampoline and
+// returns it to be run next, so the stack does not grow.
+type trampoline struct {
+	id     int
+	funcs  []*ir.Function
+	result ir.Type
+}
+
+// planTailCalls decides which of calls are compiled as jumps. A function
+// calling itself jumps back to its start and, if opts.Trampoline is set,
+// functions in a cycle of tail calls are run by a trampoline.
+func (c *compiler) planTailCalls(calls []ir.TailCall, opts Options) {
+	c.tails = make(map[*ir.Call]*ir.Function)
+	c.loops = make(map[*ir.Function]bool)
+	c.tramps = make(map[*ir.Function]*trampoline)
+	if opts.Trampoline {
+		c.makeTrampolines(calls)
+	}
+
+	for _, tc := range calls {
+		var how string
+		switch t := c.tramps[tc.Caller]; {
+		case tc.Caller == tc.Callee:
+			c.loops[tc.Caller] = true
+			how = "as a loop"
+		case t != nil && t == c.tramps[tc.Callee]:
+			how = "through a trampoline"
+		default:
+			continue
+		}
+		c.tails[tc.Call] = tc.Callee
+		if opts.Report != nil {
+			fmt.Fprintf(opts.Report, "%s: tail call to '%s' compiled %s\n",
+				c.fset.Position(tc.Call.Pos()), tc.Call.Name(), how)
+		}
+	}
+}
+
+// makeTrampolines makes a trampoline for each group of functions which may
+// call one another in tail position without end. Such a group is a
+// strongly connected component of the graph of tail calls, which are found
+// by Tarjan's algorithm.
+func (c *compiler) makeTrampolines(calls []ir.TailCall) {
+	var funcs []*ir.Function
+	edges := make(map[*ir.Function][]*ir.Function)
+	for _, tc := range calls {
+		if tc.Caller == tc.Callee || tc.Caller.Result() != tc.Callee.Result() {
+			continue
+		}
+		if _, ok := edges[tc.Caller]; !ok {
+			funcs = append(funcs, tc.Caller)
+		}
+		edges[tc.Caller] = append(edges[tc.Caller], tc.Callee)
+	}
+
+	var stack []*ir.Function
+	index := make(map[*ir.Function]int)
+	low := make(map[*ir.Function]int)
+	onStack := make(map[*ir.Function]bool)
+	var visit func(f *ir.Function)
+	visit = func(f *ir.Function) {
+		index[f] = len(index) + 1
+		low[f] = index[f]
+		stack = append(stack, f)
+		onStack[f] = true
+		for _, g := range edges[f] {
+			switch {
+			case index[g] == 0:
+				visit(g)
+				if low[g] < low[f] {
+					low[f] = low[g]
+				}
+			case onStack[g] && index[g] < low[f]:
+				low[f] = index[g]
+			}
+		}
+		if low[f] != index[f] {
+			return
+		}
+
+		t := &trampoline{id: f.ID(), result: f.Result()}
+		for {
+			g := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[g] = false
+			t.funcs = append(t.funcs, g)
+			if g == f {
+				break
+			}
+		}
+		if len(t.funcs) > 1 {
+			for _, g := range t.funcs {
+				c.tramps[g] = t
+			}
+		}
+	}
+	for _, f := range funcs {
+		if index[f] == 0 {
+			visit(f)
+		}
+	}
+}
+
+// emitTrampolines declares the state and steps of each trampoline. The
+// state holds the step to run next, the result once there is none, and the
+// parameters of every function of the trampoline.
+func (c *compiler) emitTrampolines() {
+	emitted := make(map[*trampoline]bool)
+	for _, f := range c.trampFuncs() {
+		t := c.tramps[f]
+		if emitted[t] {
+			continue
+		}
+		emitted[t] = true
+
+		c.emit("struct calc_tramp%d { void (*next)(void **, "+
+			"struct calc_tramp%d *); %s result;", t.id, t.id, cType(t.result))
+		for _, g := range t.funcs {
+			for _, p := range g.Params {
+				c.emit(" %s %s%d;", cType(p.Type()), p.Name(), p.ID())
+			}
+		}
+		c.emit(" };\n")
+		for _, g := range t.funcs {
+			c.emit("void calc_step%d(void **calc_env, "+
+				"struct calc_tramp%d *calc_s);\n", g.ID(), t.id)
+		}
+	}
+}
+
+// trampFuncs returns the functions run by a trampoline, in order of ID
+func (c *compiler) trampFuncs() []*ir.Function {
+	funcs := make([]*ir.Function, 0, len(c.tramps))
+	for f := range c.tramps {
+		funcs = append(funcs, f)
+	}
+	sort.Slice(funcs, func(i, j int) bool {
+		return funcs[i].ID() < funcs[j].ID()
+	})
+	return funcs
+}
+
+// compTrampoline compiles the body of function f, which is run by
+// trampoline t, as running the trampoline from the step of f. The step
+// itself is then emitted in place of the body of f.
+func (c *compiler) compTrampoline(f *ir.Function, t *trampoline) {
+	c.emit("struct calc_tramp%d calc_s;\n", t.id)
+	c.emitln("memset(&calc_s, 0, sizeof(calc_s));")
+	c.emit("calc_s.next = calc_step%d;\n", f.ID())
+	for _, p := range f.Params {
+		c.emit("calc_s.%s%d = %s%d;\n", p.Name(), p.ID(), p.Name(), p.ID())
+	}
+	c.emitln("while (calc_s.next != NULL) {")
+	c.emitln("calc_s.next(calc_env, &calc_s);")
+	c.emitln("}")
+	c.emitln("return calc_s.result;")
+	c.emitln("}")
+	c.emit("void calc_step%d(void **calc_env, struct calc_tramp%d *calc_s) {\n",
+		f.ID(), t.id)
+}
+
+// compTailCall compiles call, in tail position of the function being
+// compiled, as a jump to callee: back to the start of the function if it
+// calls itself, otherwise to the step of callee in its trampoline. Every
+// argument is evaluated before any parameter is assigned since they may
+// refer to the parameters. The value returned is never used.
+func (c *compiler) compTailCall(call *ir.Call, callee *ir.Function) string {
+	args := make([]string, len(call.Args))
+	for i, a := range call.Args {
+		c.tmp++
+		args[i] = fmt.Sprintf("calc_arg%d", c.tmp)
+		c.emit("%s %s = %s;\n", cType(a.Type()), args[i], c.compObject(a))
+	}
+	if callee == c.fn {
+		for i, p := range callee.Params {
+			c.emit("%s%d = %s;\n", p.Name(), p.ID(), args[i])
+		}
+		c.emit("goto calc_tail%d;\n", callee.ID())
+		return zeroValue(call.Type())
+	}
+	for i, p := range callee.Params {
+		c.emit("calc_s->%s%d = %s;\n", p.Name(), p.ID(), args[i])
+	}
+	c.emit("calc_s->next = calc_step%d;\n", callee.ID())
+	c.emitln("return;")
+	return zeroValue(call.Type())
+}
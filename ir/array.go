@@ -0,0 +1,39 @@
+// Copyright (c) 2015, Rob Thornton
+// All rights reserved.
+// This source code is governed by a Simplied BSD-License. Please see the
+// LICENSE included in this distribution for a copy of the full license
+// or, if one is not included, you may also find a copy at
+// http://opensource.org/licenses/BSD-2-Clause
+
+package ir
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rthornton128/calc/ast"
+)
+
+// ArrayLit is an array literal. Its elements are all of the type of the
+// first typed element or, if every element is an untyped constant, the
+// elements take their type from the array the literal is assigned to.
+type ArrayLit struct {
+	object
+	Elems   []Object
+	untyped bool
+}
+
+func makeArrayLit(pkg *Package, a *ast.ArrayLit) *ArrayLit {
+	return &ArrayLit{
+		object: object{name: "array", pkg: pkg, pos: a.Pos(), scope: pkg.scope},
+		Elems:  MakeExprList(pkg, a.Elems),
+	}
+}
+
+func (a *ArrayLit) String() string {
+	var out []string
+	for _, e := range a.Elems {
+		out = append(out, e.String())
+	}
+	return fmt.Sprintf("[%s]", strings.Join(out, " "))
+}
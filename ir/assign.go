@@ -13,20 +13,42 @@ import (
 	"github.com/rthornton128/calc/ast"
 )
 
+// Assignment assigns Rhs to the variable Lhs, or to the field of Lhs
+// selected by Fields. If Index is not nil an element of that array or list
+// is assigned instead.
 type Assignment struct {
 	object
-	Lhs string
-	Rhs Object
+	Lhs    string
+	Fields []string
+	Index  Object
+	Rhs    Object
 }
 
 func makeAssignment(pkg *Package, a *ast.AssignExpr) *Assignment {
+	var index Object
+	if a.Index != nil {
+		index = MakeExpr(pkg, a.Index)
+	}
+	var fields []string
+	for _, f := range a.Fields {
+		fields = append(fields, f.Name)
+	}
 	return &Assignment{
 		object: object{name: "assign", pkg: pkg, pos: a.Pos(), scope: pkg.scope},
 		Lhs:    a.Name.Name,
+		Fields: fields,
+		Index:  index,
 		Rhs:    MakeExpr(pkg, a.Value),
 	}
 }
 
 func (a *Assignment) String() string {
-	return fmt.Sprintf("{%s=%s}", a.Lhs, a.Rhs.String())
+	lhs := a.Lhs
+	for _, f := range a.Fields {
+		lhs += "." + f
+	}
+	if a.Index != nil {
+		return fmt.Sprintf("{%s[%s]=%s}", lhs, a.Index, a.Rhs)
+	}
+	return fmt.Sprintf("{%s=%s}", lhs, a.Rhs.String())
 }
@@ -35,10 +35,14 @@ func makeBinary(pkg *Package, b *ast.BinaryExpr) *Binary {
 	return lhs.(*Binary)
 }
 
+// binaryType returns the type of a binary expression with operator t. The
+// type of arithmetic is that of its operands, which is determined during
+// type checking.
 func binaryType(t token.Token) Type {
 	switch t {
-	case token.ADD, token.MUL, token.QUO, token.REM, token.SUB:
-		return Int
+	case token.ADD, token.MUL, token.QUO, token.REM, token.SUB,
+		token.BAND, token.BOR, token.BXOR, token.SHL, token.SHR:
+		return Unknown
 	default:
 		return Bool
 	}
@@ -50,13 +54,13 @@ func (b *Binary) String() string {
 
 type Unary struct {
 	object
-	Op  string
+	Op  token.Token
 	Rhs Object
 }
 
 func makeUnary(pkg *Package, u *ast.UnaryExpr) *Unary {
 	return &Unary{
-		object: object{pkg: pkg, pos: u.Pos(), scope: pkg.scope, typ: Int},
+		object: object{pkg: pkg, pos: u.Pos(), scope: pkg.scope},
 		Op:     u.Op,
 		Rhs:    MakeExpr(pkg, u.Value),
 	}
@@ -0,0 +1,168 @@
+// Copyright (c) 2015, Rob Thornton
+// All rights reserved.
+// This source code is governed by a Simplied BSD-License. Please see the
+// LICENSE included in this distribution for a copy of the full license
+// or, if one is not included, you may also find a copy at
+// http://opensource.org/licenses/BSD-2-Clause
+
+package ir
+
+// Builtin is a function provided by the language, like len or fold. The
+// type of a builtin depends on the arguments it is called with so, unlike
+// other functions, a builtin may only be called and not used as a value.
+type Builtin struct {
+	object
+}
+
+// checkBuiltin checks a call to builtin b. Lists are always the first
+// argument and a function, when one is expected, the last:
+//
+//	(list x...)          list of the elements x. A list of no elements
+//	                     takes the type of the list it is converted to
+//	(append xs x...)     appends the elements x to xs and results in xs
+//	(get xs i)           element i of xs
+//	(slice xs lo hi)     new list of the elements of xs from lo up to, but
+//	                     not including, hi
+//	(len xs)             the number of elements in array or list xs
+//	(range lo hi)        list of the integers from lo up to, but not
+//	                     including, hi
+//	(map xs f)           list of the results of calling f on each element
+//	(filter xs f)        list of the elements for which f is true
+//	(fold xs init f)     combines init and each element in turn with f
+//	(reduce xs f)        like fold, using the first element as init
+//	(any xs f)           true if f is true for any element
+//	(all xs f)           true if f is true for every element
+//	(count xs f)         the number of elements for which f is true
+func (tc *typeChecker) checkBuiltin(c *Call, b *Builtin) {
+	nargs := 2
+	switch b.Name() {
+	case "fold", "slice":
+		nargs = 3
+	case "len":
+		nargs = 1
+	case "list":
+		nargs = 0
+	}
+	switch b.Name() {
+	case "append", "list":
+		if len(c.Args) < nargs {
+			tc.error(c.Pos(), "function '%s' expects at least '%d' arguments "+
+				"but received %d", b.Name(), nargs, len(c.Args))
+			return
+		}
+	default:
+		if len(c.Args) != nargs {
+			tc.error(c.Pos(), "function '%s' expects '%d' arguments but "+
+				"received %d", b.Name(), nargs, len(c.Args))
+			return
+		}
+	}
+	for _, a := range c.Args {
+		tc.check(a)
+	}
+
+	switch b.Name() {
+	case "len":
+		if elemType(c.Args[0].Type()) == nil {
+			tc.error(c.Args[0].Pos(), "function 'len' expects an array or list "+
+				"but argument 0 is of type '%s'", c.Args[0].Type())
+			return
+		}
+		c.object.typ = Int
+		return
+	case "list":
+		if len(c.Args) == 0 {
+			c.object.typ = UntypedList
+			tc.empty = append(tc.empty, c)
+			return
+		}
+		c.object.typ = tc.types.listType(tc.checkElems(c.Args, "list"))
+		return
+	case "range":
+		t := c.Args[0].Type()
+		if t == UntypedInt {
+			t = c.Args[1].Type()
+		}
+		c.Args[0], c.Args[1] = tc.convert(c.Args[0], t), tc.convert(c.Args[1], t)
+		if !IsInteger(c.Args[0].Type()) || c.Args[0].Type() != c.Args[1].Type() {
+			tc.error(c.Pos(), "range expects integers of the same type but "+
+				"received '%s' and '%s'", c.Args[0].Type(), c.Args[1].Type())
+			return
+		}
+		c.object.typ = tc.types.listType(c.Args[0].Type())
+		return
+	}
+
+	xs, f := c.Args[0], c.Args[len(c.Args)-1]
+	l, ok := xs.Type().(*List)
+	if !ok {
+		tc.error(xs.Pos(), "function '%s' expects a list but argument 0 is of "+
+			"type '%s'", b.Name(), xs.Type())
+		return
+	}
+	elem := l.Elem
+
+	switch b.Name() {
+	case "append":
+		for i, a := range c.Args[1:] {
+			a = tc.convert(a, elem)
+			c.Args[i+1] = a
+			if a.Type() != elem {
+				tc.error(a.Pos(), "cannot append '%s' to list of '%s'", a.Type(),
+					elem)
+			}
+		}
+		c.object.typ = xs.Type()
+		return
+	case "get":
+		c.Args[1] = tc.checkIndex(c.Args[1], xs.Type())
+		c.object.typ = elem
+		return
+	case "slice":
+		c.Args[1] = tc.checkIndex(c.Args[1], xs.Type())
+		c.Args[2] = tc.checkIndex(c.Args[2], xs.Type())
+		c.object.typ = xs.Type()
+		return
+	}
+
+	var want Type
+	switch b.Name() {
+	case "all", "any", "count", "filter":
+		want = tc.types.funcType([]Type{elem}, Bool)
+	case "fold":
+		init := c.Args[1]
+		if sig, ok := f.Type().(*Signature); ok && len(sig.Params) == 2 {
+			init = tc.convert(init, sig.Params[0])
+		}
+		c.Args[1] = tc.convert(init, init.Type())
+		want = tc.types.funcType([]Type{c.Args[1].Type(), elem}, c.Args[1].Type())
+	case "map":
+		if sig, ok := f.Type().(*Signature); ok {
+			want = tc.types.funcType([]Type{elem}, sig.Result)
+			break
+		}
+		want = tc.types.funcType([]Type{elem}, elem)
+	case "reduce":
+		want = tc.types.funcType([]Type{elem, elem}, elem)
+	}
+	if f.Type() != want {
+		tc.error(f.Pos(), "function '%s' expects argument %d of type '%s' but "+
+			"received '%s'", b.Name(), len(c.Args)-1, want, f.Type())
+		return
+	}
+
+	switch b.Name() {
+	case "all", "any":
+		c.object.typ = Bool
+	case "count":
+		c.object.typ = Int
+	case "filter":
+		c.object.typ = xs.Type()
+	case "fold":
+		c.object.typ = c.Args[1].Type()
+	case "map":
+		c.object.typ = tc.types.listType(want.(*Signature).Result)
+	case "reduce":
+		c.object.typ = elem
+	}
+}
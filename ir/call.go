@@ -14,26 +14,46 @@ import (
 	"github.com/rthornton128/calc/ast"
 )
 
+// Call calls the function resulting from Func. The name of the call is
+// the name of the function, if Func is a Var or Instance, or "func"
+// otherwise. Arrays are indexed with the same form, so a Call whose Func is
+// an array, like (a 0), results in an element of the array instead, see
+// IsIndex.
 type Call struct {
 	object
+	Func Object
 	Args []Object
 }
 
 func makeCall(pkg *Package, c *ast.CallExpr) *Call {
+	name := "func"
+	switch f := c.Fun.(type) {
+	case *ast.Ident:
+		name = f.Name
+	case *ast.InstanceExpr:
+		name = f.Name.Name
+	}
 	args := make([]Object, len(c.Args))
 	for i, a := range c.Args {
 		args[i] = MakeExpr(pkg, a)
 	}
 	return &Call{
-		object: object{name: c.Name.Name, pkg: pkg, pos: c.Pos(), scope: pkg.scope},
+		object: object{name: name, pkg: pkg, pos: c.Pos(), scope: pkg.scope},
+		Func:   MakeExpr(pkg, c.Fun),
 		Args:   args,
 	}
 }
 
+// IsIndex returns true if c indexes an array rather than calling a function
+func (c *Call) IsIndex() bool {
+	_, ok := c.Func.Type().(*Array)
+	return ok
+}
+
 func (c *Call) String() string {
 	var out []string
 	for _, a := range c.Args {
 		out = append(out, a.String())
 	}
-	return fmt.Sprintf("{call: %s (%s)}", c.Name(), strings.Join(out, ","))
+	return fmt.Sprintf("{call: %s (%s)}", c.Func, strings.Join(out, ","))
 }
@@ -0,0 +1,111 @@
+// Copyright (c) 2015, Rob Thornton
+// All rights reserved.
+// This source code is governed by a Simplied BSD-License. Please see the
+// LICENSE included in this distribution for a copy of the full license
+// or, if one is not included, you may also find a copy at
+// http://opensource.org/licenses/BSD-2-Clause
+
+package ir
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rthornton128/calc/ast"
+)
+
+// Cond evaluates to the body of the first clause whose test is true or, if
+// none are, Else. Without an else clause it evaluates to the zero value of
+// its type, like an If without an else clause.
+type Cond struct {
+	object
+	Clauses []*Clause
+	Else    Object
+}
+
+// Switch evaluates to the body of the clause whose case label is equal to
+// the integer Tag or, if none are, Else. Case labels are integer constants.
+type Switch struct {
+	object
+	Tag     Object
+	Clauses []*Clause
+	Else    Object
+}
+
+// Clause is a clause of a Cond or Switch
+type Clause struct {
+	Test Object
+	Body Object
+}
+
+func makeCond(pkg *Package, ce *ast.CondExpr) *Cond {
+	c := &Cond{
+		object: object{
+			id:    pkg.getID(),
+			name:  "cond",
+			pkg:   pkg,
+			pos:   ce.Pos(),
+			scope: pkg.scope,
+			typ:   makeType(pkg, ce.Type),
+		},
+		Clauses: makeClauses(pkg, ce.Clauses),
+	}
+	if ce.Else != nil {
+		c.Else = MakeExpr(pkg, ce.Else)
+	}
+	return c
+}
+
+func makeSwitch(pkg *Package, se *ast.SwitchExpr) *Switch {
+	s := &Switch{
+		object: object{
+			id:    pkg.getID(),
+			name:  "switch",
+			pkg:   pkg,
+			pos:   se.Pos(),
+			scope: pkg.scope,
+			typ:   makeType(pkg, se.Type),
+		},
+		Tag:     MakeExpr(pkg, se.Tag),
+		Clauses: makeClauses(pkg, se.Clauses),
+	}
+	if se.Else != nil {
+		s.Else = MakeExpr(pkg, se.Else)
+	}
+	return s
+}
+
+func makeClauses(pkg *Package, clauses []*ast.Clause) []*Clause {
+	out := make([]*Clause, len(clauses))
+	for i, c := range clauses {
+		out[i] = &Clause{
+			Test: MakeExpr(pkg, c.Test),
+			Body: MakeExpr(pkg, c.Body),
+		}
+	}
+	return out
+}
+
+func (c *Clause) String() string {
+	return fmt.Sprintf("%s: %s", c.Test, c.Body)
+}
+
+func clauseString(clauses []*Clause, els Object) string {
+	out := make([]string, len(clauses))
+	for i, c := range clauses {
+		out[i] = c.String()
+	}
+	if els != nil {
+		out = append(out, "else: "+els.String())
+	}
+	return strings.Join(out, ", ")
+}
+
+func (c *Cond) String() string {
+	return fmt.Sprintf("{cond[%s] {%s}}", c.typ, clauseString(c.Clauses, c.Else))
+}
+
+func (s *Switch) String() string {
+	return fmt.Sprintf("{switch[%s] %s {%s}}", s.typ, s.Tag,
+		clauseString(s.Clauses, s.Else))
+}
@@ -9,7 +9,9 @@ package ir
 
 import (
 	"fmt"
+	"math/big"
 	"strconv"
+	"strings"
 
 	"github.com/rthornton128/calc/ast"
 	"github.com/rthornton128/calc/token"
@@ -20,46 +22,120 @@ type Value interface {
 	Type() Type
 }
 
+// intValue holds the exact value of an integer constant. Whether the value
+// fits is determined by the type of the Constant holding it.
 type (
-	boolValue bool
-	intValue  int64
+	boolValue  bool
+	floatValue float64
+	intValue   struct{ *big.Int }
 )
 
+// Constant is a literal value or the result of folding a constant
+// expression. The name of a literal is its original spelling.
 type Constant struct {
 	object
 	value Value
 }
 
-func makeConstant(b *ast.BasicLit) *Constant {
+func makeConstant(pkg *Package, b *ast.BasicLit) *Constant {
 	var v Value
+	var err error
 	switch b.Kind {
 	case token.BOOL:
-		v, _ = makeBool(b.Lit) // TODO handle error
+		v, err = makeBool(b.Lit)
+	case token.FLOAT:
+		v, err = makeFloat(b.Lit)
 	case token.INTEGER:
-		v, _ = makeInt(b.Lit) // TODO handle error
+		v, err = makeInt(b.Lit)
+	}
+	if err != nil {
+		pkg.typeError(b.Pos(), "%s", err)
 	}
 	return &Constant{
-		object: object{name: v.String(), pos: b.Pos(), typ: v.Type()},
+		object: object{name: b.Lit, pos: b.Pos(), typ: v.Type()},
 		value:  v,
 	}
 }
 
+// setValue replaces the value of c, discarding its original spelling
+func (c *Constant) setValue(v Value) {
+	c.value = v
+	c.name = v.String()
+}
+
 func (c *Constant) String() string {
-	return c.value.String()
+	return c.name
+}
+
+// Value returns the value of the constant
+func (c *Constant) Value() Value {
+	return c.value
 }
 
 func makeBool(lit string) (Value, error) {
 	b, err := strconv.ParseBool(lit)
-	return boolValue(b), err
+	if err != nil {
+		return boolValue(false), fmt.Errorf("invalid boolean %s", lit)
+	}
+	return boolValue(b), nil
 }
 
 func (v boolValue) String() string { return fmt.Sprintf("%v", bool(v)) }
 func (v boolValue) Type() Type     { return Bool }
 
+func makeFloat(lit string) (Value, error) {
+	f, err := strconv.ParseFloat(lit, 64)
+	if err != nil {
+		return floatValue(0), fmt.Errorf("invalid float %s", lit)
+	}
+	return floatValue(f), nil
+}
+
+func (v floatValue) String() string {
+	return strconv.FormatFloat(float64(v), 'g', -1, 64)
+}
+func (v floatValue) Type() Type { return Float }
+
 func makeInt(lit string) (Value, error) {
-	i, err := strconv.ParseInt(lit, 0, 64)
-	return intValue(i), err
+	i, ok := new(big.Int).SetString(lit, 0)
+	// a leading zero would otherwise make a decimal literal octal
+	if !ok || len(lit) > 1 && lit[0] == '0' &&
+		!strings.ContainsRune("xXoObB", rune(lit[1])) {
+		return intValue{new(big.Int)}, fmt.Errorf("invalid integer %s", lit)
+	}
+	return intValue{i}, nil
 }
 
-func (v intValue) String() string { return strconv.FormatInt(int64(v), 10) }
-func (v intValue) Type() Type     { return Int }
+func (v intValue) Type() Type { return UntypedInt }
+
+// minInt returns the smallest value representable by integer type t
+func minInt(t Type) *big.Int {
+	if IsUnsigned(t) {
+		return new(big.Int)
+	}
+	return new(big.Int).Neg(new(big.Int).Lsh(big.NewInt(1), t.(*Basic).Size()-1))
+}
+
+// maxInt returns the largest value representable by integer type t
+func maxInt(t Type) *big.Int {
+	n := t.(*Basic).Size()
+	if !IsUnsigned(t) {
+		n--
+	}
+	return new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), n), big.NewInt(1))
+}
+
+// representable returns true if x fits in integer type t
+func representable(x *big.Int, t Type) bool {
+	return x.Cmp(minInt(t)) >= 0 && x.Cmp(maxInt(t)) <= 0
+}
+
+// wrap truncates x to the width of integer type t using two's complement
+func wrap(x *big.Int, t Type) *big.Int {
+	mod := new(big.Int).Lsh(big.NewInt(1), t.(*Basic).Size())
+	v := new(big.Int).Mod(x, mod)
+	if !representable(v, t) {
+		v.Sub(v, mod)
+	}
+	return v
+}
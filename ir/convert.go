@@ -0,0 +1,36 @@
+// Copyright (c) 2015, Rob Thornton
+// All rights reserved.
+// This source code is governed by a Simplied BSD-License. Please see the
+// LICENSE included in this distribution for a copy of the full license
+// or, if one is not included, you may also find a copy at
+// http://opensource.org/licenses/BSD-2-Clause
+
+package ir
+
+import (
+	"fmt"
+
+	"github.com/rthornton128/calc/ast"
+)
+
+// Conversion is an explicit conversion of an expression to another type.
+// It uses call form with the type name in place of the function name.
+type Conversion struct {
+	object
+	Args []Object
+}
+
+func makeConversion(pkg *Package, c *ast.CallExpr, t Type) *Conversion {
+	return &Conversion{
+		object: object{name: c.Fun.(*ast.Ident).Name, pkg: pkg, pos: c.Pos(),
+			scope: pkg.scope, typ: t},
+		Args: MakeExprList(pkg, c.Args),
+	}
+}
+
+func (c *Conversion) String() string {
+	if len(c.Args) != 1 {
+		return fmt.Sprintf("%s(%v)", c.typ, c.Args)
+	}
+	return fmt.Sprintf("%s(%s)", c.typ, c.Args[0])
+}
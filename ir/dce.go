@@ -0,0 +1,202 @@
+// Copyright (c) 2015, Rob Thornton
+// All rights reserved.
+// This source code is governed by a Simplied BSD-License. Please see the
+// LICENSE included in this distribution for a copy of the full license
+// or, if one is not included, you may also find a copy at
+// http://opensource.org/licenses/BSD-2-Clause
+
+package ir
+
+import (
+	"math/big"
+
+	"github.com/rthornton128/calc/token"
+)
+
+// EliminateDeadCode removes code from o which is never run, or whose result
+// is never used and which has no other effect. An if or cond whose
+// condition is constant is replaced by the branch taken, a loop whose
+// condition is constant false by the zero value of its type, and the
+// expressions of the body of a function, var, let or loop other than the
+// last are removed if they are free of effects. When o is a package, the
+// defines which main does not refer to, directly or through other defines,
+// are removed too. Conditions are best folded by FoldConstants first.
+func EliminateDeadCode(o Object) Object {
+	pkg, ok := o.(*Package)
+	if !ok {
+		return eliminate(o)
+	}
+	for k, v := range pkg.scope.m {
+		pkg.scope.m[k] = eliminate(v)
+	}
+	dropUnreachable(pkg)
+	return pkg
+}
+
+func eliminate(o Object) Object {
+	rewrite(o, eliminate)
+	switch t := o.(type) {
+	case *Cond:
+		return eliminateCond(t)
+	case *For:
+		if isConstant(t.Cond, false) {
+			if z := zero(t.Type(), t.Pos()); z != nil {
+				return z
+			}
+		}
+		t.Body = dropUnused(t.Body)
+	case *Function:
+		if !t.IsGeneric() {
+			t.Body = dropUnused(t.Body)
+		}
+	case *If:
+		switch {
+		case isConstant(t.Cond, true) && t.Then.Type() == t.Type():
+			return t.Then
+		case !isConstant(t.Cond, false):
+		case t.Else == nil:
+			if z := zero(t.Type(), t.Pos()); z != nil {
+				return z
+			}
+		case t.Else.Type() == t.Type():
+			return t.Else
+		}
+	case *Variable:
+		t.Body = dropUnused(t.Body)
+	}
+	return o
+}
+
+// eliminateCond removes the clauses of cond c whose test is constant false
+// and those after the first whose test is constant true, which becomes the
+// else clause. A cond left with only an else clause is replaced by it.
+func eliminateCond(c *Cond) Object {
+	clauses := c.Clauses[:0]
+	for _, cl := range c.Clauses {
+		if isConstant(cl.Test, true) {
+			c.Else = cl.Body
+			break
+		}
+		if !isConstant(cl.Test, false) {
+			clauses = append(clauses, cl)
+		}
+	}
+	c.Clauses = clauses
+	if len(clauses) == 0 && c.Else != nil && c.Else.Type() == c.Type() {
+		return c.Else
+	}
+	return c
+}
+
+// dropUnused returns body without the expressions, other than the last,
+// which are removable since their results are never used
+func dropUnused(body []Object) []Object {
+	out := body[:0]
+	for i, e := range body {
+		if i == len(body)-1 || !removable(e) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// removable returns true if evaluating o has no effect other than its
+// result and cannot fail at runtime. Unlike pure, arithmetic is not
+// removable since it may trap on overflow or division by zero.
+func removable(o Object) bool {
+	switch t := o.(type) {
+	case *ArrayLit:
+		return removableList(t.Elems)
+	case *Binary:
+		switch t.Op {
+		case token.ADD, token.SUB, token.MUL, token.QUO, token.REM, token.SHL,
+			token.SHR:
+			return false
+		}
+		return removable(t.Lhs) && removable(t.Rhs)
+	case *Constant, *Function, *Instance:
+		return true
+	case *Selector:
+		return removable(t.X)
+	case *StructLit:
+		return removableList(t.Args)
+	case *Unary:
+		return (t.Op == token.NOT || t.Op == token.BNOT) && removable(t.Rhs)
+	case *UnionLit:
+		return removableList(t.Args)
+	case *Var:
+		// the body of a define is evaluated where it is used
+		if d, ok := t.Scope().Lookup(t.Name()).(*Define); ok {
+			return removable(d.Body)
+		}
+		return true
+	}
+	return false
+}
+
+func removableList(list []Object) bool {
+	for _, o := range list {
+		if !removable(o) {
+			return false
+		}
+	}
+	return true
+}
+
+// isConstant returns true if o is the constant boolean b
+func isConstant(o Object, b bool) bool {
+	c, ok := o.(*Constant)
+	return ok && c.value == boolValue(b)
+}
+
+// zero returns the zero value of t as a constant, or nil if t is not a
+// boolean or number
+func zero(t Type, pos token.Pos) *Constant {
+	var v Value
+	switch {
+	case t == Bool:
+		v = boolValue(false)
+	case t == Float:
+		v = floatValue(0)
+	case IsInteger(t):
+		v = intValue{new(big.Int)}
+	default:
+		return nil
+	}
+	c := &Constant{object: object{pos: pos, typ: t}}
+	c.setValue(v)
+	return c
+}
+
+// dropUnreachable removes the defines of pkg which main does not refer to,
+// directly or through other defines. A package without main is unchanged.
+func dropUnreachable(pkg *Package) {
+	main, ok := pkg.scope.m["main"].(*Define)
+	if !ok {
+		return
+	}
+	reached := make(map[*Define]bool)
+	var reach func(d *Define)
+	reach = func(d *Define) {
+		if reached[d] {
+			return
+		}
+		reached[d] = true
+		inspect(d, func(o Object) bool {
+			switch o.(type) {
+			case *Instance, *Var:
+				if d, ok := o.Scope().Lookup(o.Name()).(*Define); ok {
+					reach(d)
+				}
+			}
+			return true
+		})
+	}
+	reach(main)
+
+	for k, o := range pkg.scope.m {
+		if d, ok := o.(*Define); ok && !reached[d] {
+			delete(pkg.scope.m, k)
+		}
+	}
+}
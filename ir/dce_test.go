@@ -0,0 +1,80 @@
+// Copyright (c) 2015, Rob Thornton
+// All rights reserved.
+// This source code is governed by a Simplied BSD-License. Please see the
+// LICENSE included in this distribution for a copy of the full license
+// or, if one is not included, you may also find a copy at
+// http://opensource.org/licenses/BSD-2-Clause
+
+package ir_test
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/rthornton128/calc/ast"
+	"github.com/rthornton128/calc/ir"
+	"github.com/rthornton128/calc/parse"
+	"github.com/rthornton128/calc/token"
+)
+
+func TestDeadCode(t *testing.T) {
+	tests := []FoldTest{
+		{src: "(if true 1 2)", expect: "1"},
+		{src: "(if (> 1 2) 1 2)", expect: "2"},
+		{src: "(if (> 1 2):int 1)", expect: "0"},
+		{src: "(func (b:bool):int (if b 1 2))",
+			expect: "func:(func bool):i32 (b[bool]) {{if[i32] b then 1 else 2}}"},
+		{src: "(cond ((== 1 2) 1) ((< 1 2) 2) (else 3))", expect: "2"},
+		{src: "(func (n:int):int (cond ((< n 0) 1) ((== 1 2) 2) ((== 1 1) 3) " +
+			"((> n 9) 4)))",
+			expect: "func:(func i32):i32 (n[i32]) {{cond[i32] {(n < 0): 1, " +
+				"else: 3}}}"},
+		{src: "(for false 1)", expect: "0"},
+		{src: "(func (n:int):int n (for false n) !true (+ n 1) (> n 2) (* n 2))",
+			expect: "func:(func i32):i32 (n[i32]) {(n + 1),(n * 2)}"},
+	}
+	for i, test := range tests {
+		name := fmt.Sprintf("dead%d", i)
+		o := ir.EliminateDeadCode(check_and_fold(t, name, test.src))
+		if o.String() != test.expect {
+			t.Fatalf("%s: expected %s but got %s", name, test.expect, o)
+		}
+	}
+}
+
+func TestUnreachableDefines(t *testing.T) {
+	tests := []struct {
+		src     string
+		defines []string
+	}{
+		{"(define f (func:int 1))(define g (func:int (f)))" +
+			"(define h (func:int 2))(define main (func:int (g)))",
+			[]string{"f", "g", "main"}},
+		{"(define f (func:int (g)))(define g (func:int (f)))" +
+			"(define main (func:int 0))", []string{"main"}},
+		{"(define id (func [T] (x:T):T x))(define k (var (n:int) (= n 3)))" +
+			"(define main (func:int (id k)))", []string{"id", "k", "main"}},
+		{"(define f (func:int 1))(define g (func:int 2))",
+			[]string{"f", "g"}},
+	}
+	for i, test := range tests {
+		name := fmt.Sprintf("reach%d", i)
+		fset := token.NewFileSet()
+		f, err := parse.ParseFile(fset, name, test.src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkg := ir.MakePackage(&ast.Package{Files: []*ast.File{f}}, name)
+		if err := ir.TypeCheck(pkg, fset); err != nil {
+			t.Fatal(err)
+		}
+		ir.EliminateDeadCode(pkg)
+		names := pkg.Scope().Names()
+		sort.Strings(names)
+		if fmt.Sprint(names) != fmt.Sprint(test.defines) {
+			t.Fatalf("%s: expected defines %v but got %v", name, test.defines,
+				names)
+		}
+	}
+}
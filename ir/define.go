@@ -22,7 +22,20 @@ func MakeDefine(pkg *Package, d *ast.DefineStmt) *Define {
 	body := MakeExpr(pkg, d.Body)
 	t := body.Type()
 	if d.Type != nil {
-		t = typeFromString(d.Type.Name)
+		t = makeType(pkg, d.Type)
+
+		// the type of a function may be given by its result type alone
+		_, isFunc := t.(*Signature)
+		if f, ok := body.(*Function); ok {
+			if !isFunc {
+				t = signatureOf(pkg.types, f.Params, t)
+			}
+			// a function without a result type takes that of the define
+			if f.typ == nil {
+				f.typ = signatureOf(pkg.types, f.Params,
+					t.(*Signature).Result)
+			}
+		}
 	}
 
 	return &Define{
@@ -11,14 +11,31 @@ import "github.com/rthornton128/calc/ast"
 
 func MakeExpr(pkg *Package, e ast.Expr) Object {
 	switch t := e.(type) {
+	case *ast.ArrayLit:
+		return makeArrayLit(pkg, t)
 	case *ast.AssignExpr:
 		return makeAssignment(pkg, t)
 	case *ast.BasicLit:
-		return makeConstant(t)
+		return makeConstant(pkg, t)
 	case *ast.BinaryExpr:
 		return makeBinary(pkg, t)
 	case *ast.CallExpr:
+		if id, ok := t.Fun.(*ast.Ident); ok {
+			if c, ok := pkg.Lookup(id.Name).(*Constructor); ok {
+				return makeUnionLit(pkg, t, c)
+			}
+			if tn, ok := pkg.Lookup(id.Name).(*TypeName); ok {
+				if _, ok := tn.Type().(*Basic); ok {
+					return makeConversion(pkg, t, tn.Type())
+				}
+				return makeStructLit(pkg, t, tn.Type())
+			}
+		}
 		return makeCall(pkg, t)
+	case *ast.BranchExpr:
+		return makeBranch(pkg, t)
+	case *ast.CondExpr:
+		return makeCond(pkg, t)
 	case *ast.ForExpr:
 		return makeFor(pkg, t)
 	case *ast.FuncExpr:
@@ -27,6 +44,16 @@ func MakeExpr(pkg *Package, e ast.Expr) Object {
 		return makeVar(pkg, t)
 	case *ast.IfExpr:
 		return makeIf(pkg, t)
+	case *ast.InstanceExpr:
+		return makeInstance(pkg, t)
+	case *ast.LetExpr:
+		return makeLet(pkg, t)
+	case *ast.MatchExpr:
+		return makeMatch(pkg, t)
+	case *ast.SelectorExpr:
+		return makeSelector(pkg, t)
+	case *ast.SwitchExpr:
+		return makeSwitch(pkg, t)
 	case *ast.UnaryExpr:
 		return makeUnary(pkg, t)
 	case *ast.VarExpr:
@@ -7,125 +7,520 @@
 
 package ir
 
-import "github.com/rthornton128/calc/token"
+import (
+	"fmt"
+	"math"
+	"math/big"
 
+	"github.com/rthornton128/calc/token"
+)
+
+type folder struct {
+	folded map[*Define]bool
+	// params holds the parameters of lets which are bound to a constant
+	// and never assigned, so may be replaced by it
+	params map[*Param]*Constant
+}
+
+// FoldConstants evaluates any expressions in o whose operands are constant
+// and replaces them with the result. Variables referring to a define, or a
+// parameter of a let, bound to a constant are replaced by the constant and,
+// when folding a package, defines bound to a constant which are then unused
+// are removed. An operation which would fail at runtime, like division by
+// zero, is left unfolded so that it fails the same way whether or not the
+// program is optimized.
 func FoldConstants(o Object) Object {
+	f := &folder{
+		folded: make(map[*Define]bool),
+		params: make(map[*Param]*Constant),
+	}
 	if pkg, ok := o.(*Package); ok {
 		for k, v := range pkg.scope.m {
-			pkg.scope.m[k] = fold(v)
+			pkg.scope.m[k] = f.fold(v)
 		}
+		dropConstants(pkg)
 		return pkg
 	}
-	return fold(o)
+	return f.fold(o)
 }
 
-func fold(o Object) Object {
+// dropConstants removes the defines of pkg bound to a constant which are no
+// longer referred to, since folding has propagated the constant to each use
+func dropConstants(pkg *Package) {
+	used := make(map[Object]bool)
+	for _, o := range pkg.scope.m {
+		inspect(o, func(o Object) bool {
+			if v, ok := o.(*Var); ok {
+				used[v.Scope().Lookup(v.Name())] = true
+			}
+			return true
+		})
+	}
+	for k, o := range pkg.scope.m {
+		if d, ok := o.(*Define); ok && !used[d] {
+			if _, ok := d.Body.(*Constant); ok {
+				delete(pkg.scope.m, k)
+			}
+		}
+	}
+}
+
+func (f *folder) fold(o Object) Object {
 	switch t := o.(type) {
+	case *ArrayLit:
+		for i, e := range t.Elems {
+			t.Elems[i] = f.fold(e)
+		}
 	case *Assignment:
-		t.Rhs = fold(t.Rhs)
+		if t.Index != nil {
+			t.Index = f.fold(t.Index)
+		}
+		t.Rhs = f.fold(t.Rhs)
 	case *Binary:
-		t.Lhs = fold(t.Lhs)
-		t.Rhs = fold(t.Rhs)
-		return foldBinary(t)
+		t.Lhs = f.fold(t.Lhs)
+		t.Rhs = f.fold(t.Rhs)
+		return f.foldBinary(t)
+	case *Branch:
+		if t.Value != nil {
+			t.Value = f.fold(t.Value)
+		}
 	case *Call:
+		t.Func = f.fold(t.Func)
+		for i, e := range t.Args {
+			t.Args[i] = f.fold(e)
+		}
+	case *Cond:
+		return f.foldCond(t)
+	case *Conversion:
 		for i, e := range t.Args {
-			t.Args[i] = fold(e)
+			t.Args[i] = f.fold(e)
 		}
+		return foldConversion(t)
 	case *Define:
-		t.Body = fold(t.Body)
+		// defines may be folded early when referenced by another define so
+		// only fold each one once
+		if f.folded[t] {
+			return t
+		}
+		f.folded[t] = true
+		t.Body = f.fold(t.Body)
 	case *For:
-		t.Cond = fold(t.Cond)
+		t.Cond = f.fold(t.Cond)
 		for i, e := range t.Body {
-			t.Body[i] = fold(e)
+			t.Body[i] = f.fold(e)
 		}
 	case *Function:
+		// only the instances of a generic function have been checked
+		if t.IsGeneric() {
+			for _, inst := range t.Instances {
+				f.fold(inst)
+			}
+			return t
+		}
 		for i, e := range t.Body {
-			t.Body[i] = fold(e)
+			t.Body[i] = f.fold(e)
 		}
 	case *If:
-		t.Cond = fold(t.Cond)
-		t.Then = fold(t.Then)
+		return f.foldIf(t)
+	case *Match:
+		t.X = f.fold(t.X)
+		for _, a := range t.Arms {
+			a.Body = f.fold(a.Body)
+		}
+	case *Selector:
+		t.X = f.fold(t.X)
+	case *StructLit:
+		for i, e := range t.Args {
+			t.Args[i] = f.fold(e)
+		}
+	case *Switch:
+		t.Tag = f.fold(t.Tag)
+		f.foldClauses(t.Clauses)
 		if t.Else != nil {
-			t.Else = fold(t.Else)
+			t.Else = f.fold(t.Else)
 		}
 	case *Unary:
-		t.Rhs = fold(t.Rhs)
+		t.Rhs = f.fold(t.Rhs)
 		return foldUnary(t)
+	case *UnionLit:
+		for i, e := range t.Args {
+			t.Args[i] = f.fold(e)
+		}
+	case *Var:
+		switch d := t.Scope().Lookup(t.Name()).(type) {
+		case *Define:
+			f.fold(d)
+			if c, ok := d.Body.(*Constant); ok {
+				return propagate(t, c)
+			}
+		case *Param:
+			if c := f.params[d]; c != nil {
+				return propagate(t, c)
+			}
+		}
 	case *Variable:
+		for i, e := range t.Inits {
+			t.Inits[i] = f.fold(e)
+		}
+		f.bindParams(t)
 		for i, e := range t.Body {
-			t.Body[i] = fold(e)
+			t.Body[i] = f.fold(e)
 		}
+		return f.foldLet(t)
 	}
 	return o
 }
 
-func foldBinary(b *Binary) Object {
+// bindParams records each parameter of let v which is bound to a constant,
+// is never assigned and is not captured by a nested function. Variables
+// referring to it are then folded to the constant.
+func (f *folder) bindParams(v *Variable) {
+	if v.Inits == nil {
+		return
+	}
+	for i, p := range v.Params {
+		c, ok := v.Inits[i].(*Constant)
+		if !ok || p.Captured() {
+			continue
+		}
+		assigned := false
+		for _, e := range v.Body {
+			inspect(e, func(o Object) bool {
+				if a, ok := o.(*Assignment); ok && a.Scope().Lookup(a.Lhs) == p {
+					assigned = true
+				}
+				return !assigned
+			})
+		}
+		if !assigned {
+			f.params[p] = c
+		}
+	}
+}
+
+// foldLet removes the parameters of let v which have been replaced by the
+// constant they are bound to. A let left with no parameters and a body of
+// a single expression is replaced by that expression.
+func (f *folder) foldLet(v *Variable) Object {
+	if v.Inits == nil {
+		return v
+	}
+	params, inits := v.Params[:0], v.Inits[:0]
+	for i, p := range v.Params {
+		if f.params[p] == nil {
+			params = append(params, p)
+			inits = append(inits, v.Inits[i])
+		}
+	}
+	v.Params, v.Inits = params, inits
+	if len(params) == 0 && len(v.Body) == 1 {
+		return v.Body[0]
+	}
+	return v
+}
+
+// foldIf folds if i. When its condition is constant only the branch taken
+// is folded, and replaces the if.
+func (f *folder) foldIf(i *If) Object {
+	i.Cond = f.fold(i.Cond)
+	switch {
+	case isConstant(i.Cond, true) && i.Then.Type() == i.Type():
+		return f.fold(i.Then)
+	case !isConstant(i.Cond, false):
+	case i.Else == nil:
+		if z := zero(i.Type(), i.Pos()); z != nil {
+			return z
+		}
+	case i.Else.Type() == i.Type():
+		return f.fold(i.Else)
+	}
+	i.Then = f.fold(i.Then)
+	if i.Else != nil {
+		i.Else = f.fold(i.Else)
+	}
+	return i
+}
+
+// foldCond folds cond c. Clauses whose test is constant false are removed,
+// as are those after the first whose test is constant true, which becomes
+// the else clause. A cond left with only an else clause is replaced by it.
+func (f *folder) foldCond(c *Cond) Object {
+	clauses := c.Clauses[:0]
+	for _, cl := range c.Clauses {
+		cl.Test = f.fold(cl.Test)
+		if isConstant(cl.Test, true) {
+			c.Else = cl.Body
+			break
+		}
+		if !isConstant(cl.Test, false) {
+			cl.Body = f.fold(cl.Body)
+			clauses = append(clauses, cl)
+		}
+	}
+	c.Clauses = clauses
+	if c.Else == nil {
+		return c
+	}
+	if len(clauses) == 0 && c.Else.Type() == c.Type() {
+		return f.fold(c.Else)
+	}
+	c.Else = f.fold(c.Else)
+	return c
+}
+
+func (f *folder) foldClauses(clauses []*Clause) {
+	for _, c := range clauses {
+		c.Test = f.fold(c.Test)
+		c.Body = f.fold(c.Body)
+	}
+}
+
+// propagate returns a copy of constant c in place of variable v, which
+// refers to it. Constants are copied since folding one changes it.
+func propagate(v *Var, c *Constant) *Constant {
+	k := *c
+	k.pos, k.scope = v.pos, v.scope
+	return &k
+}
+
+// binaryError returns the error binary b fails with at runtime when its
+// operands are known to make it fail, such as a divisor of zero, or an
+// empty string otherwise. The type checker reports such errors for
+// operands which are constant, and folding leaves b to fail at runtime when
+// they are only known after propagating defines or lets.
+func binaryError(b *Binary) string {
+	r, ok := constInt(b.Rhs)
+	if !ok {
+		return ""
+	}
+	switch b.Op {
+	case token.QUO, token.REM:
+		if r.Sign() == 0 {
+			return "division by zero"
+		}
+		t := b.Lhs.Type()
+		l, ok := constInt(b.Lhs)
+		if ok && b.Op == token.QUO && t != UntypedInt &&
+			r.Cmp(big.NewInt(-1)) == 0 && l.Cmp(minInt(t)) == 0 {
+			return fmt.Sprintf("constant division overflows '%s'", t)
+		}
+	case token.SHL, token.SHR:
+		if r.Sign() < 0 {
+			return fmt.Sprintf("negative shift count %s", r)
+		}
+	}
+	return ""
+}
+
+func (f *folder) foldBinary(b *Binary) Object {
+	if binaryError(b) != "" {
+		return b
+	}
+
 	lhs, lhsOk := b.Lhs.(*Constant)
 	rhs, rhsOk := b.Rhs.(*Constant)
+	if !lhsOk || !rhsOk {
+		return b
+	}
 
-	if lhsOk && rhsOk {
-		switch b.Type() {
-		case Int:
-			l, r := int64(lhs.value.(intValue)), int64(rhs.value.(intValue))
-			switch b.Op {
-			case token.ADD:
-				lhs.value = intValue(l + r)
-			case token.MUL:
-				lhs.value = intValue(l * r)
-			case token.QUO:
-				// TODO div by zero
-				lhs.value = intValue(l / r)
-			case token.REM:
-				lhs.value = intValue(l % r)
-			case token.SUB:
-				lhs.value = intValue(l - r)
+	switch l := lhs.value.(type) {
+	case boolValue:
+		r := rhs.value.(boolValue)
+		switch b.Op {
+		case token.EQL:
+			lhs.setValue(boolValue(l == r))
+		case token.NEQ:
+			lhs.setValue(boolValue(l != r))
+		}
+	case floatValue:
+		r := rhs.value.(floatValue)
+		switch b.Op {
+		case token.EQL:
+			lhs.setValue(boolValue(l == r))
+		case token.NEQ:
+			lhs.setValue(boolValue(l != r))
+		case token.GTT:
+			lhs.setValue(boolValue(l > r))
+		case token.GTE:
+			lhs.setValue(boolValue(l >= r))
+		case token.LST:
+			lhs.setValue(boolValue(l < r))
+		case token.LTE:
+			lhs.setValue(boolValue(l <= r))
+		default:
+			v, ok := foldFloat(b.Op, float64(l), float64(r))
+			if !ok {
+				return b
 			}
-			return lhs
-		case Bool:
-			switch lhs.Type() {
-			case Bool:
-				l, r := bool(lhs.value.(boolValue)), bool(rhs.value.(boolValue))
-				switch b.Op {
-				case token.EQL:
-					lhs.value = boolValue(l == r)
-				case token.NEQ:
-					lhs.value = boolValue(l != r)
-				}
-			case Int:
-				l, r := int64(lhs.value.(intValue)), int64(rhs.value.(intValue))
-				switch b.Op {
-				case token.EQL:
-					lhs.value = boolValue(l == r)
-				case token.NEQ:
-					lhs.value = boolValue(l != r)
-				case token.GTT:
-					lhs.value = boolValue(l > r)
-				case token.GTE:
-					lhs.value = boolValue(l >= r)
-				case token.LST:
-					lhs.value = boolValue(l < r)
-				case token.LTE:
-					lhs.value = boolValue(l <= r)
-				}
+			lhs.setValue(floatValue(v))
+		}
+	case intValue:
+		r := rhs.value.(intValue)
+		cmp := l.Cmp(r.Int)
+		switch b.Op {
+		case token.EQL:
+			lhs.setValue(boolValue(cmp == 0))
+		case token.NEQ:
+			lhs.setValue(boolValue(cmp != 0))
+		case token.GTT:
+			lhs.setValue(boolValue(cmp > 0))
+		case token.GTE:
+			lhs.setValue(boolValue(cmp >= 0))
+		case token.LST:
+			lhs.setValue(boolValue(cmp < 0))
+		case token.LTE:
+			lhs.setValue(boolValue(cmp <= 0))
+		default:
+			v, ok := foldInt(b.Op, lhs.Type(), l.Int, r.Int)
+			if !ok {
+				return b
 			}
-			return lhs
+			lhs.setValue(intValue{v})
 		}
 	}
-	return b
+	lhs.object.typ = b.Type()
+	if b.Type() == Unknown {
+		lhs.object.typ = rhs.Type()
+	}
+	return lhs
+}
+
+// foldInt performs the integer operation op on l and r. Integers wrap on
+// overflow at runtime, or trap in checked mode, so false is returned if
+// the result does not fit in type t and the operation is left to be
+// performed at runtime instead
+func foldInt(op token.Token, t Type, l, r *big.Int) (*big.Int, bool) {
+	v := new(big.Int)
+	switch op {
+	case token.ADD:
+		v.Add(l, r)
+	case token.MUL:
+		v.Mul(l, r)
+	case token.QUO:
+		v.Quo(l, r)
+	case token.REM:
+		v.Rem(l, r)
+	case token.SUB:
+		v.Sub(l, r)
+	case token.BAND:
+		v.And(l, r)
+	case token.BOR:
+		v.Or(l, r)
+	case token.BXOR:
+		v.Xor(l, r)
+	case token.SHL, token.SHR:
+		return foldShift(op, t, l, r), true
+	}
+	return v, representable(v, t)
+}
+
+// foldShift shifts l by the non-negative count r. Bits shifted out of an
+// integer are discarded, so shifting by the width of t or more results in 0
+// or, when shifting a negative value right, -1. Untyped values are shifted
+// exactly
+func foldShift(op token.Token, t Type, l, r *big.Int) *big.Int {
+	n := t.(*Basic).Size()
+	if t == UntypedInt || (r.IsUint64() && r.Uint64() < uint64(n)) {
+		n = uint(r.Uint64())
+	}
+	v := new(big.Int)
+	if op == token.SHR {
+		return v.Rsh(l, n)
+	}
+	v.Lsh(l, n)
+	if t != UntypedInt {
+		v = wrap(v, t)
+	}
+	return v
+}
+
+// foldFloat performs the floating-point operation op on l and r. Results
+// which are infinite or not a number have no constant representation in C
+// and are left to be computed at runtime
+func foldFloat(op token.Token, l, r float64) (float64, bool) {
+	var v float64
+	switch op {
+	case token.ADD:
+		v = l + r
+	case token.MUL:
+		v = l * r
+	case token.QUO:
+		v = l / r
+	case token.SUB:
+		v = l - r
+	}
+	return v, !math.IsInf(v, 0) && !math.IsNaN(v)
 }
 
 func foldUnary(u *Unary) Object {
-	if c, ok := u.Rhs.(*Constant); ok {
+	c, ok := u.Rhs.(*Constant)
+	if !ok {
+		return u
+	}
+	switch v := c.value.(type) {
+	case boolValue:
+		if u.Op != token.NOT {
+			return u
+		}
+		c.setValue(!v)
+	case floatValue:
 		switch u.Op {
-		case "+":
-			val := intValue(+int64(c.value.(intValue)))
-			if val < 0 {
-				c.value = val * -1
-			}
-		case "-":
-			c.value = intValue(-int64(c.value.(intValue)))
+		case token.ADD:
+			c.setValue(floatValue(math.Abs(float64(v))))
+		case token.SUB:
+			c.setValue(-v)
+		default:
+			return u
 		}
+	case intValue:
+		x := new(big.Int).Set(v.Int)
+		switch u.Op {
+		case token.ADD:
+			x.Abs(x)
+		case token.SUB:
+			x.Neg(x)
+		case token.BNOT:
+			x = wrap(x.Not(x), c.Type())
+		default:
+			return u
+		}
+		if !representable(x, c.Type()) {
+			return u
+		}
+		c.setValue(intValue{x})
+	}
+	return c
+}
+
+// foldConversion converts a constant to the type of the conversion.
+// Integers are truncated to fit and floats are truncated toward zero when
+// converted to an integer. Floats which do not fit the integer type are
+// left to be converted, and fail, at runtime
+func foldConversion(c *Conversion) Object {
+	if len(c.Args) != 1 {
 		return c
 	}
-	return u
+	k, ok := c.Args[0].(*Constant)
+	if !ok {
+		return c
+	}
+	switch v := k.value.(type) {
+	case floatValue:
+		if c.Type() != Float {
+			i, _ := big.NewFloat(float64(v)).Int(nil)
+			if math.IsInf(float64(v), 0) || !representable(i, c.Type()) {
+				return c
+			}
+			k.setValue(intValue{i})
+		}
+	case intValue:
+		if c.Type() == Float {
+			f, _ := new(big.Float).SetInt(v.Int).Float64()
+			k.setValue(floatValue(f))
+		} else {
+			k.setValue(intValue{wrap(v.Int, c.Type())})
+		}
+	}
+	k.object.typ = c.Type()
+	return k
 }
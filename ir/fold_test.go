@@ -9,6 +9,7 @@ package ir_test
 
 import (
 	"fmt"
+	"sort"
 	"testing"
 
 	"github.com/rthornton128/calc/ast"
@@ -24,8 +25,7 @@ type FoldTest struct {
 func TestAssignmentFolding(t *testing.T) {
 	test := FoldTest{src: "(= a (* 1 1))", expect: "1"}
 	name := "assign"
-	expr, _ := parse.ParseExpression(name, test.src)
-	o := ir.FoldConstants(ir.MakeExpr(ir.MakePackage(&ast.Package{}, name), expr))
+	o := fold_expression(t, name, test.src)
 	validate_constant(t, name, o.(*ir.Assignment).Rhs, test)
 }
 
@@ -55,21 +55,111 @@ func TestBinaryFolding(t *testing.T) {
 	}
 }
 
+func TestOverflowFolding(t *testing.T) {
+	tests := []string{
+		"(+ 2147483647 1)",
+		"(- 0 2147483647 2)",
+		"(* 65536 65536)",
+		"-(- 0 2147483647 1)",
+		"+(- 0 2147483647 1)",
+	}
+	for i, src := range tests {
+		name := fmt.Sprintf("overflow%d", i)
+		if o, ok := fold_expression(t, name, src).(*ir.Constant); ok {
+			t.Fatalf("%s: expected %s not to be folded but got: %s", name, src, o)
+		}
+	}
+}
+
+func TestSizedFolding(t *testing.T) {
+	tests := []FoldTest{
+		{src: "(+ (u8 200) (u8 55))", expect: "255"},
+		{src: "(* (i64 4294967296) 3)", expect: "12884901888"},
+		{src: "(- (i8 -100) 28)", expect: "-128"},
+		{src: "(/ (u64 18446744073709551615) 5)", expect: "3689348814741910323"},
+		{src: "(u8 (i32 -1))", expect: "255"},
+		{src: "(i8 (i32 200))", expect: "-56"},
+		{src: "(< (u16 3) 4)", expect: "true"},
+	}
+	for i, test := range tests {
+		name := fmt.Sprintf("sized%d", i)
+		validate_constant(t, name, check_and_fold(t, name, test.src), test)
+	}
+
+	overflow := []string{
+		"(+ (u8 200) (u8 56))",
+		"(- (u32 0) 1)",
+		"(* (i16 256) 128)",
+		"-(i8 -128)",
+		"-(u8 1)",
+	}
+	for i, src := range overflow {
+		name := fmt.Sprintf("sizedoverflow%d", i)
+		if o, ok := check_and_fold(t, name, src).(*ir.Constant); ok {
+			t.Fatalf("%s: expected %s not to be folded but got: %s", name, src, o)
+		}
+	}
+}
+
+func TestFloatFolding(t *testing.T) {
+	tests := []FoldTest{
+		{src: "(+ 1.5 2.25)", expect: "3.75"},
+		{src: "(* 0.5 3)", expect: "1.5"},
+		{src: "(/ 1.0 4)", expect: "0.25"},
+		{src: "(- 1e3 1)", expect: "999"},
+		{src: "(< 1.5 2.5)", expect: "true"},
+		{src: "-2.5", expect: "-2.5"},
+		{src: "+(- 0 2.5)", expect: "2.5"},
+		{src: "(float 3)", expect: "3"},
+		{src: "(i32 -2.75)", expect: "-2"},
+		{src: "(u8 255.9)", expect: "255"},
+	}
+	for i, test := range tests {
+		name := fmt.Sprintf("float%d", i)
+		validate_constant(t, name, check_and_fold(t, name, test.src), test)
+	}
+
+	unfolded := []string{
+		"(/ 1.0 0)",
+		"(u8 256.0)",
+		"(i32 1e20)",
+	}
+	for i, src := range unfolded {
+		name := fmt.Sprintf("floatunfolded%d", i)
+		if o, ok := check_and_fold(t, name, src).(*ir.Constant); ok {
+			t.Fatalf("%s: expected %s not to be folded but got: %s", name, src, o)
+		}
+	}
+}
+
 func TestCallFolding(t *testing.T) {
 	src := "(fn (== 3 2) (+ 2 2))"
 	name := "call"
-	expr, _ := parse.ParseExpression(name, src)
-	o := ir.FoldConstants(ir.MakeExpr(ir.MakePackage(&ast.Package{}, name), expr))
+	o := fold_expression(t, name, src)
 	validate_constant(t, name, o.(*ir.Call).Args[0], FoldTest{src, "false"})
 	validate_constant(t, name, o.(*ir.Call).Args[1], FoldTest{src, "4"})
 }
 
+func TestListFolding(t *testing.T) {
+	src := "(len (append (list (+ 1 2)) (* 2 3)))"
+	name := "list"
+	o := check_and_fold(t, name, src)
+	appendCall, ok := o.(*ir.Call).Args[0].(*ir.Call)
+	if !ok {
+		t.Fatalf("%s: expected list operations to be left alone, got %s", name, o)
+	}
+	validate_constant(t, name, appendCall.Args[0].(*ir.Call).Args[0],
+		FoldTest{src, "3"})
+	validate_constant(t, name, appendCall.Args[1], FoldTest{src, "6"})
+}
+
 func TestIfFolding(t *testing.T) {
 	src := "(if (== false (!= 3 3)):int (/ 9 3) (* 1 2 3))"
 	name := "if"
-	expr, _ := parse.ParseExpression(name, src)
-	o := ir.FoldConstants(ir.MakeExpr(ir.MakePackage(&ast.Package{}, name), expr))
-	validate_constant(t, name, o.(*ir.If).Cond, FoldTest{src, "true"})
+	validate_constant(t, name, check_and_fold(t, name, src), FoldTest{src, "3"})
+
+	src = "(func (b:bool):int (if b:int (/ 9 3) (* 1 2 3)))"
+	o := fold_expression(t, name, src).(*ir.Function).Body[0]
 	validate_constant(t, name, o.(*ir.If).Then, FoldTest{src, "3"})
 	validate_constant(t, name, o.(*ir.If).Else, FoldTest{src, "6"})
 }
@@ -88,12 +178,99 @@ func TestPackageFolding(t *testing.T) {
 		FoldTest{"", "16"})
 }
 
+func TestInlineFolding(t *testing.T) {
+	square := "(define square (func (n:int):int (* n n)))"
+	tests := []struct {
+		src    string
+		size   int
+		expect string // last expression of main
+	}{
+		{square + "(define main (func:int (square 4)))", 10, "16"},
+		{square + "(define main (func:int (square 4)))", 2, "{call: square (4)}"},
+		{square + "(define sum (func (x:int y:int):int (+ (square x) (square y))))" +
+			"(define main (func:int (sum 3 4)))", 10, "25"},
+		{square + "(define main (func (m:int):int (square (+ m 1))))", 10,
+			"let:i32 (n[i32]=(m + 1)) {(n * n)}"},
+		{"(define id (func [T] (x:T):T x))(define main (func:int (id 3)))", 10,
+			"3"},
+		{"(define f (func (n:int):int (if (< n 1) 0 (f (- n 1)))))" +
+			"(define main (func:int (f 3)))", 10, "{call: f (3)}"},
+		{"(define f (func (n:int):int (g n)))(define g (func (n:int):int (f n)))" +
+			"(define main (func:int (f 3)))", 10, "{call: f (3)}"},
+		{"(define f (func (n:int):int (for (< n 3) n)))" +
+			"(define main (func:int (f 3)))", 10, "{call: f (3)}"},
+		{"(define safe (func (n:int):int (if (== n 0):int 0 (/ 10 n))))" +
+			"(define main (func:int (+ (safe 0) (safe 5))))", 10, "2"},
+		{"(define safe (func (n:int):int (cond ((== n 0) 0) (else (% 10 n)))))" +
+			"(define main (func:int (safe 0)))", 10, "0"},
+		{"(define shl (func (n:int):int (if (< n 0):int 0 (<< 1 n))))" +
+			"(define main (func:int (shl -1)))", 10, "0"},
+		{square + "(define main (func (m:int):int (var (a:int) (= a m) " +
+			"(square (+ m a)))))", 10,
+			"var:i32 (a[i32]) {{a=m},{call: square ((m + a))}}"},
+		{square + "(define main (func (m:int):int (square (square m))))", 10,
+			"let:i32 (n[i32]=let:i32 (n[i32]=m) {(n * n)}) {(n * n)}"},
+		{"(define div (func (n:int b:bool):int (if b 0 (/ 10 n))))" +
+			"(define main (func (b:bool):int (div 0 b)))", 10,
+			"let:i32 (b[bool]=b) {{if[i32] b then 0 else (10 / 0)}}"},
+		{"(define shl (func (n:int b:bool):int (if b 0 (<< 1 n))))" +
+			"(define main (func (b:bool):int (shl -1 b)))", 10,
+			"let:i32 (b[bool]=b) {{if[i32] b then 0 else (1 << -1)}}"},
+	}
+	for i, test := range tests {
+		name := fmt.Sprintf("inline%d", i)
+		fset := token.NewFileSet()
+		f, err := parse.ParseFile(fset, name, test.src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkg := ir.MakePackage(&ast.Package{Files: []*ast.File{f}}, name)
+		if err := ir.TypeCheck(pkg, fset); err != nil {
+			t.Fatal(err)
+		}
+		ir.Inline(pkg, test.size)
+		ir.FoldConstants(pkg)
+		main := pkg.Scope().Lookup("main").(*ir.Define).Body.(*ir.Function)
+		if s := main.Body[len(main.Body)-1].String(); s != test.expect {
+			t.Fatalf("%s: expected %s but got %s", name, test.expect, s)
+		}
+	}
+}
+
+func TestDefinePropagation(t *testing.T) {
+	src := "(define a 0)(define b (+ 3 4))(define c (+ a b))" +
+		"(define d (var (n:int) (= n b)))" +
+		"(define f (func (n:int):int (* b (+ c n) d)))"
+	fset := token.NewFileSet()
+	f, err := parse.ParseFile(fset, "propagate", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg := ir.MakePackage(&ast.Package{Files: []*ast.File{f}}, "propagate")
+	if err := ir.TypeCheck(pkg, fset); err != nil {
+		t.Fatal(err)
+	}
+	ir.FoldConstants(pkg)
+	names := pkg.Scope().Names()
+	sort.Strings(names)
+	if fmt.Sprint(names) != "[d f]" {
+		t.Fatalf("propagate: expected defines [d f] but got %v", names)
+	}
+	fn := pkg.Scope().Lookup("f").(*ir.Define).Body.(*ir.Function)
+	if s := fn.Body[0].String(); s != "((7 * (7 + n)) * d)" {
+		t.Fatalf("propagate: expected ((7 * (7 + n)) * d) but got %s", s)
+	}
+}
+
 func TestUnaryFolding(t *testing.T) {
 	tests := []FoldTest{
 		{src: "-42)", expect: "-42"},
 		{src: "+42", expect: "42"},
 		{src: "+(- 2 4)", expect: "2"},
 		{src: "-(+ 2 4)", expect: "-6"},
+		{src: "!true", expect: "false"},
+		{src: "(not (== 1 2))", expect: "true"},
+		{src: "!!false", expect: "false"},
 	}
 	for i, test := range tests {
 		test_folding(t, fmt.Sprintf("unary%d", i), test)
@@ -103,15 +280,134 @@ func TestUnaryFolding(t *testing.T) {
 func TestVarFolding(t *testing.T) {
 	test := FoldTest{src: "(var (a:int):int (= a (/ 24 3)))", expect: "8"}
 	name := "var"
-	expr, _ := parse.ParseExpression(name, test.src)
-	o := ir.FoldConstants(ir.MakeExpr(ir.MakePackage(&ast.Package{}, name), expr))
+	o := fold_expression(t, name, test.src)
 	o = o.(*ir.Variable).Body[0].(*ir.Assignment).Rhs
 	validate_constant(t, name, o, test)
 }
 
+func TestConstantDivision(t *testing.T) {
+	tests := []Test{
+		{src: "(/ 1 0)", pass: false},
+		{src: "(% 1 0)", pass: false},
+		{src: "(/ 6 (- 2 2))", pass: false},
+		{src: "(/ 4 2 0)", pass: false},
+		{src: "(/ (int (- 0 2147483647 1)) -1)", pass: false},
+		{src: "(func (a:int):int (/ a 0))", pass: false},
+		{src: "(func (a:int):int (/ 0 a))", pass: true},
+		{src: "(/ 4 2)", pass: true},
+	}
+	for i, test := range tests {
+		name := fmt.Sprintf("division%d", i)
+		expr, err := parse.ParseExpression(name, test.src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fset := token.NewFileSet()
+		fset.Add(name, len(test.src))
+		o := ir.MakeExpr(ir.MakePackage(&ast.Package{}, name), expr)
+		if err := ir.TypeCheck(o, fset); (err == nil) != test.pass {
+			t.Fatalf("%s: expected pass to be %v, got error: %v", name, test.pass,
+				err)
+		}
+	}
+}
+
+func TestDefineDivisionFolding(t *testing.T) {
+	tests := []struct {
+		src, name string
+		expect    string // body of define name, or last expression of a func
+	}{
+		{"(define a 0)(define b (/ 1 a))", "b", "(1 / 0)"},
+		{"(define b (% 1 a))(define a (- 2 2))", "b", "(1 % 0)"},
+		{"(define a 0)(define b (+ 1 a))(define f (func:int (/ 1 b)))", "f",
+			"1"},
+		{"(define a 0)(define f (func (a:int):int (/ 1 a)))", "f", "(1 / a)"},
+		{"(define a 0)(define f (func:int (/ 1 a)))", "f", "(1 / 0)"},
+		{"(define a -1)(define f (func:int (<< 1 a)))", "f", "(1 << -1)"},
+		{"(define z 0)(define f (func:int (if (== z 0) 0 (/ 10 z))))", "f",
+			"0"},
+		{"(define z 0)(define f (func:int (cond ((!= z 0) (% 10 z)) " +
+			"(else 0))))", "f", "0"},
+		{"(define z -1)(define f (func:int (if (< z 0) 0 (>> 1 z))))", "f",
+			"0"},
+	}
+	for i, test := range tests {
+		name := fmt.Sprintf("define%d", i)
+		fset := token.NewFileSet()
+		f, err := parse.ParseFile(fset, name, test.src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkg := ir.MakePackage(&ast.Package{Files: []*ast.File{f}}, name)
+		if err := ir.TypeCheck(pkg, fset); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		ir.FoldConstants(pkg)
+		o := pkg.Scope().Lookup(test.name).(*ir.Define).Body
+		if fn, ok := o.(*ir.Function); ok {
+			o = fn.Body[len(fn.Body)-1]
+		}
+		if s := o.String(); s != test.expect {
+			t.Fatalf("%s: expected %s but got %s", name, test.expect, s)
+		}
+	}
+}
+
+func check_and_fold(t *testing.T, name, src string) ir.Object {
+	expr, _ := parse.ParseExpression(name, src)
+	fset := token.NewFileSet()
+	fset.Add(name, len(src))
+	o := ir.MakeExpr(ir.MakePackage(&ast.Package{}, name), expr)
+	if err := ir.TypeCheck(o, fset); err != nil {
+		t.Fatal(err)
+	}
+	return ir.FoldConstants(o)
+}
+
+func TestBitwiseFolding(t *testing.T) {
+	tests := []FoldTest{
+		{src: "(& 12 10)", expect: "8"},
+		{src: "(| 12 10 1)", expect: "15"},
+		{src: "(^ 12 10)", expect: "6"},
+		{src: "(<< 1 40)", expect: "1099511627776"},
+		{src: "(>> -8 1)", expect: "-4"},
+		{src: "(<< (u8 0xF0) 4)", expect: "0"},
+		{src: "(<< (i8 1) 7)", expect: "-128"},
+		{src: "(<< (int 1) 32)", expect: "0"},
+		{src: "(>> (int -1) 100)", expect: "-1"},
+		{src: "(>> (u32 0xFFFFFFFF) 32)", expect: "0"},
+		{src: "~(u8 0x0F)", expect: "240"},
+		{src: "~(int 0)", expect: "-1"},
+	}
+	for i, test := range tests {
+		name := fmt.Sprintf("bitwise%d", i)
+		validate_constant(t, name, check_and_fold(t, name, test.src), test)
+	}
+
+	negative := []string{
+		"(<< 1 -1)",
+		"(>> (i64 8) (i64 -2))",
+	}
+	for i, src := range negative {
+		name := fmt.Sprintf("negativeshift%d", i)
+		expr, _ := parse.ParseExpression(name, src)
+		fset := token.NewFileSet()
+		fset.Add(name, len(src))
+		o := ir.MakeExpr(ir.MakePackage(&ast.Package{}, name), expr)
+		if err := ir.TypeCheck(o, fset); err == nil {
+			t.Fatalf("%s: expected negative shift count error for %s", name, src)
+		}
+	}
+}
+
+func fold_expression(t *testing.T, name, src string) ir.Object {
+	expr, _ := parse.ParseExpression(name, src)
+	return ir.FoldConstants(ir.MakeExpr(ir.MakePackage(&ast.Package{}, name),
+		expr))
+}
+
 func test_folding(t *testing.T, name string, test FoldTest) {
-	expr, _ := parse.ParseExpression(name, test.src)
-	o := ir.FoldConstants(ir.MakeExpr(ir.MakePackage(&ast.Package{}, name), expr))
+	o := fold_expression(t, name, test.src)
 	validate_constant(t, name, o, test)
 }
 
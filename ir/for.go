@@ -5,6 +5,7 @@ import (
 	"strings"
 
 	"github.com/rthornton128/calc/ast"
+	"github.com/rthornton128/calc/token"
 )
 
 type For struct {
@@ -13,14 +14,25 @@ type For struct {
 	Body []Object
 }
 
+// Branch is a break or continue, as given by Tok, of the innermost For
+// enclosing it, which is found by TypeCheck. A break may have a Value,
+// which becomes the result of the loop. A branch never produces a value
+// itself so it takes whatever type is expected of it.
+type Branch struct {
+	object
+	Tok   token.Token
+	Value Object
+	Loop  *For
+}
+
 func makeFor(pkg *Package, f *ast.ForExpr) *For {
 	body := make([]Object, len(f.Body))
 	for i, e := range f.Body {
 		body[i] = MakeExpr(pkg, e)
 	}
 	return &For{
-		object: object{id: pkg.getID(), pos: f.Pos(), scope: pkg.scope,
-			typ: typeFromString(f.Type.Name)},
+		object: object{id: pkg.getID(), name: "for", pos: f.Pos(),
+			scope: pkg.scope, typ: makeType(pkg, f.Type)},
 		Cond: MakeExpr(pkg, f.Cond),
 		Body: body,
 	}
@@ -33,3 +45,22 @@ func (f *For) String() string {
 	}
 	return fmt.Sprintf("{for[%s] %s {%s}}", f.typ, f.Cond, strings.Join(body, ","))
 }
+
+func makeBranch(pkg *Package, b *ast.BranchExpr) *Branch {
+	br := &Branch{
+		object: object{name: b.Tok.String(), pkg: pkg, pos: b.Pos(),
+			scope: pkg.scope},
+		Tok: b.Tok,
+	}
+	if b.Value != nil {
+		br.Value = MakeExpr(pkg, b.Value)
+	}
+	return br
+}
+
+func (b *Branch) String() string {
+	if b.Value == nil {
+		return "{" + b.name + "}"
+	}
+	return fmt.Sprintf("{%s %s}", b.name, b.Value)
+}
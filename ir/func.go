@@ -16,11 +16,47 @@ import (
 
 type Function struct {
 	object
-	Params []*Param
-	Body   []Object
+	Params   []*Param
+	Body     []Object
+	captures []*Param
+
+	// TypeParams are the type parameters of a generic function, whose
+	// Instances are made for each list of type arguments it is used with.
+	// TypeArgs are the type arguments of an instance.
+	TypeParams []*TypeName
+	Instances  []*Function
+	TypeArgs   []Type
+	decl       *ast.FuncExpr
 }
 
+// makeFunc makes the function f. The type parameters of a generic function
+// are declared in a scope of their own, enclosing that of the function.
 func makeFunc(pkg *Package, f *ast.FuncExpr) *Function {
+	if len(f.TypeParams) == 0 {
+		return makeFuncBody(pkg, f)
+	}
+	pkg.newScope()
+	defer pkg.closeScope()
+
+	var params []*TypeName
+	for _, id := range f.TypeParams {
+		tn := &TypeName{object: object{
+			kind:  ast.TypeDecl,
+			name:  id.Name,
+			pkg:   pkg,
+			pos:   id.Pos(),
+			scope: pkg.scope,
+			typ:   &TypeParam{name: id.Name},
+		}}
+		pkg.Insert(tn)
+		params = append(params, tn)
+	}
+	fn := makeFuncBody(pkg, f)
+	fn.TypeParams, fn.decl = params, f
+	return fn
+}
+
+func makeFuncBody(pkg *Package, f *ast.FuncExpr) *Function {
 	pkg.newScope()
 	defer pkg.closeScope()
 
@@ -31,18 +67,96 @@ func makeFunc(pkg *Package, f *ast.FuncExpr) *Function {
 			pkg:   pkg,
 			pos:   f.Pos(),
 			scope: pkg.scope,
-			typ:   typeFromString(f.Type.Name),
-			// TODO typ: SignatureType{},
 		},
 		Params: makeParamList(pkg, f.Params),
 		Body:   MakeExprList(pkg, f.Body),
 	}
-
-	pkg.InsertTop(fn)
+	if f.Type != nil {
+		fn.typ = signatureOf(pkg.types, fn.Params, makeType(pkg, f.Type))
+	}
 
 	return fn
 }
 
+// signatureOf returns the type, from table tt, of a function with
+// parameters params and result type result
+func signatureOf(tt *typeTable, params []*Param, result Type) Type {
+	types := make([]Type, len(params))
+	for i, p := range params {
+		types[i] = p.Type()
+	}
+	return tt.funcType(types, result)
+}
+
+// Result returns the type of the value returned by the function. If the
+// result type was not declared it is Unknown until inferred by TypeCheck.
+func (f *Function) Result() Type {
+	if f.typ == nil {
+		return Unknown
+	}
+	return f.typ.(*Signature).Result
+}
+
+// IsGeneric returns true if the function declares type parameters
+func (f *Function) IsGeneric() bool {
+	return len(f.TypeParams) != 0
+}
+
+// instance returns the instance of generic function f for the type
+// arguments args and true if it was made by this call rather than an
+// earlier one. An instance is made from the declaration of f with each
+// type parameter declared as the name of its type argument instead.
+func (f *Function) instance(args []Type) (*Function, bool) {
+next:
+	for _, inst := range f.Instances {
+		for i, t := range inst.TypeArgs {
+			if t != args[i] {
+				continue next
+			}
+		}
+		return inst, false
+	}
+
+	pkg, scope := f.pkg, f.pkg.scope
+	pkg.scope = NewScope(f.TypeParams[0].Scope().parent)
+	for i, tp := range f.TypeParams {
+		pkg.Insert(&TypeName{object: object{
+			kind:  ast.TypeDecl,
+			name:  tp.Name(),
+			pkg:   pkg,
+			pos:   tp.Pos(),
+			scope: pkg.scope,
+			typ:   args[i],
+		}})
+	}
+	// any errors making types were already recorded making f itself
+	n := len(pkg.typeErrors)
+	inst := makeFuncBody(pkg, f.decl)
+	pkg.typeErrors = pkg.typeErrors[:n]
+	pkg.scope = scope
+
+	inst.TypeArgs = args
+	f.Instances = append(f.Instances, inst)
+	return inst, true
+}
+
+// Captures returns the parameters of enclosing functions and vars used by
+// the function, in the order they were first used. Captures are found
+// during type checking.
+func (f *Function) Captures() []*Param {
+	return f.captures
+}
+
+func (f *Function) capture(p *Param) {
+	p.captured = true
+	for _, c := range f.captures {
+		if c == p {
+			return
+		}
+	}
+	f.captures = append(f.captures, p)
+}
+
 func (f *Function) String() string {
 	params := make([]string, len(f.Params))
 	for i, p := range f.Params {
@@ -54,24 +168,33 @@ func (f *Function) String() string {
 		exprs[i] = s.String()
 	}
 
-	return fmt.Sprintf("func:%s (%s) {%s}", f.typ, strings.Join(params, ","),
+	return fmt.Sprintf("func:%s (%s) {%s}", f.Type(), strings.Join(params, ","),
 		strings.Join(exprs, ","))
 }
 
 type Param struct {
 	object
+	captured bool
 }
 
 func makeParam(pkg *Package, p *ast.Param) *Param {
-	return &Param{object{
-		id:   pkg.getID(),
-		kind: ast.VarDecl,
-		name: p.Name.Name,
-		pos:  p.Pos(),
-		typ:  typeFromString(p.Type.Name),
+	return &Param{object: object{
+		id:    pkg.getID(),
+		kind:  ast.VarDecl,
+		name:  p.Name.Name,
+		pkg:   pkg,
+		pos:   p.Pos(),
+		scope: pkg.scope,
+		typ:   makeType(pkg, p.Type),
 	}}
 }
 
+// Captured returns true if the parameter is used by a function nested
+// within the function or var which declares it
+func (p *Param) Captured() bool {
+	return p.captured
+}
+
 func (p *Param) String() string {
 	return fmt.Sprintf("%s[%s]", p.name, p.typ)
 }
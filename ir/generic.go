@@ -0,0 +1,208 @@
+// Copyright (c) 2015, Rob Thornton
+// All rights reserved.
+// This source code is governed by a Simplied BSD-License. Please see the
+// LICENSE included in this distribution for a copy of the full license
+// or, if one is not included, you may also find a copy at
+// http://opensource.org/licenses/BSD-2-Clause
+
+package ir
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rthornton128/calc/ast"
+)
+
+// maxInstances is the most instances a generic function may have, which
+// stops a function calling itself with ever larger type arguments, such as
+// []T then [][]T, from being instantiated forever
+const maxInstances = 100
+
+// Instance is a generic function instantiated with type arguments, either
+// given explicitly, as in max[int], or inferred from the arguments of a
+// call. Func is the function made for the type arguments by TypeCheck.
+type Instance struct {
+	object
+	TypeArgs []Type
+	Func     *Function
+}
+
+func makeInstance(pkg *Package, e *ast.InstanceExpr) *Instance {
+	args := make([]Type, len(e.Types))
+	for i, t := range e.Types {
+		args[i] = makeType(pkg, t)
+	}
+	return &Instance{
+		object:   object{name: e.Name.Name, pkg: pkg, pos: e.Pos(), scope: pkg.scope},
+		TypeArgs: args,
+	}
+}
+
+func (i *Instance) String() string {
+	args := make([]string, len(i.TypeArgs))
+	for j, t := range i.TypeArgs {
+		args[j] = t.String()
+	}
+	return fmt.Sprintf("%s[%s]", i.name, strings.Join(args, " "))
+}
+
+// checkInstance checks instance i of a generic function. The function for
+// its type arguments is made and checked the first time they are used.
+func (tc *typeChecker) checkInstance(i *Instance) {
+	d, _ := i.Scope().Lookup(i.Name()).(*Define)
+	if d == nil {
+		tc.error(i.Pos(), "'%s' is not a generic function", i.Name())
+		return
+	}
+	f, ok := d.Body.(*Function)
+	if !ok || !f.IsGeneric() {
+		tc.error(i.Pos(), "'%s' is not a generic function", i.Name())
+		return
+	}
+	if len(i.TypeArgs) != len(f.TypeParams) {
+		tc.error(i.Pos(), "generic function '%s' expects %d type arguments but "+
+			"received %d", i.Name(), len(f.TypeParams), len(i.TypeArgs))
+		return
+	}
+	for _, t := range i.TypeArgs {
+		if t == Unknown {
+			return
+		}
+	}
+
+	inst, made := f.instance(i.TypeArgs)
+	if made {
+		if len(f.Instances) > maxInstances {
+			tc.error(i.Pos(), "generic function '%s' has more than %d instances",
+				i.Name(), maxInstances)
+			return
+		}
+		// like defines, instances are not nested in the function using them
+		funcs, loops := tc.funcs, tc.loops
+		tc.funcs, tc.loops = nil, nil
+		tc.pending[inst] = true
+		tc.check(inst)
+		delete(tc.pending, inst)
+		tc.funcs, tc.loops = funcs, loops
+	}
+	if tc.pending[inst] && inst.typ == nil {
+		tc.error(i.Pos(), "type of '%s' must be declared since it refers to "+
+			"itself", i.Name())
+		return
+	}
+	i.Func = inst
+	i.object.typ = inst.Type()
+}
+
+// checkGenericCall checks call c of the generic function f bound by define
+// d. The type arguments are inferred by unifying the types of the
+// parameters of f with those of the arguments. A type parameter given only
+// untyped constants is int. The call is then made to the instance of f for
+// the type arguments.
+func (tc *typeChecker) checkGenericCall(c *Call, d *Define, f *Function) {
+	if len(c.Args) != len(f.Params) {
+		tc.error(c.Pos(), "function '%s' expects '%d' arguments but received %d",
+			c.Name(), len(f.Params), len(c.Args))
+		return
+	}
+	for _, a := range c.Args {
+		tc.check(a)
+		if a.Type() == Unknown {
+			return
+		}
+	}
+
+	inferred := make(map[*TypeParam]Type)
+	for i, a := range c.Args {
+		p := f.Params[i].Type()
+		if a.Type() != UntypedInt && a.Type() != UntypedList &&
+			!unify(p, a.Type(), inferred) {
+			tc.error(c.Pos(), "parameter %d of function '%s' expects type '%s' "+
+				"but argument %d is of type '%s'", i, c.Name(),
+				substitute(tc.types, p, inferred), i, a.Type())
+			return
+		}
+	}
+	for i, a := range c.Args {
+		tp, ok := f.Params[i].Type().(*TypeParam)
+		if ok && a.Type() == UntypedInt && inferred[tp] == nil {
+			inferred[tp] = Int
+		}
+	}
+
+	args := make([]Type, len(f.TypeParams))
+	for i, tn := range f.TypeParams {
+		args[i] = inferred[tn.Type().(*TypeParam)]
+		if args[i] == nil {
+			tc.error(c.Pos(), "cannot infer type parameter '%s' of function '%s'",
+				tn.Name(), c.Name())
+			return
+		}
+	}
+
+	inst := &Instance{
+		object: object{name: d.Name(), pkg: c.pkg, pos: c.Func.Pos(),
+			scope: c.Scope()},
+		TypeArgs: args,
+	}
+	c.Func = inst
+	tc.checkInstance(inst)
+	if inst.Func != nil {
+		tc.checkArgs(c, inst.Type().(*Signature))
+	}
+}
+
+// unify returns true if type t, which may contain type parameters, is type
+// x once each type parameter is replaced by the type inferred for it. Type
+// parameters not yet inferred are inferred from x.
+func unify(t, x Type, inferred map[*TypeParam]Type) bool {
+	switch t := t.(type) {
+	case *TypeParam:
+		if y, ok := inferred[t]; ok {
+			return x == y
+		}
+		inferred[t] = x
+		return true
+	case *Signature:
+		s, ok := x.(*Signature)
+		if !ok || len(s.Params) != len(t.Params) {
+			return false
+		}
+		for i, p := range t.Params {
+			if !unify(p, s.Params[i], inferred) {
+				return false
+			}
+		}
+		return unify(t.Result, s.Result, inferred)
+	case *Array:
+		a, ok := x.(*Array)
+		return ok && a.Len == t.Len && unify(t.Elem, a.Elem, inferred)
+	case *List:
+		l, ok := x.(*List)
+		return ok && unify(t.Elem, l.Elem, inferred)
+	}
+	return t == x
+}
+
+// substitute returns type t, from table tt, with each type parameter
+// replaced by the type inferred for it, if there is one
+func substitute(tt *typeTable, t Type, inferred map[*TypeParam]Type) Type {
+	switch t := t.(type) {
+	case *TypeParam:
+		if x, ok := inferred[t]; ok {
+			return x
+		}
+	case *Signature:
+		params := make([]Type, len(t.Params))
+		for i, p := range t.Params {
+			params[i] = substitute(tt, p, inferred)
+		}
+		return tt.funcType(params, substitute(tt, t.Result, inferred))
+	case *Array:
+		return tt.arrayType(substitute(tt, t.Elem, inferred), t.Len)
+	case *List:
+		return tt.listType(substitute(tt, t.Elem, inferred))
+	}
+	return t
+}
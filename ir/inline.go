@@ -0,0 +1,285 @@
+// Copyright (c) 2015, Rob Thornton
+// All rights reserved.
+// This source code is governed by a Simplied BSD-License. Please see the
+// LICENSE included in this distribution for a copy of the full license
+// or, if one is not included, you may also find a copy at
+// http://opensource.org/licenses/BSD-2-Clause
+
+package ir
+
+import "github.com/rthornton128/calc/ast"
+
+type inliner struct {
+	pkg  *Package
+	size int
+
+	// bodies holds a copy of the body of each function which may be
+	// inlined, taken before any calls are inlined into it
+	bodies map[*Function][]Object
+	// assigned holds the parameters which are assigned anywhere
+	assigned map[*Param]bool
+}
+
+// Inline replaces each call of a small function bound by a define, or of an
+// instance of a generic one, with a copy of the body of the function in a
+// let which binds its parameters to the arguments of the call, so that each
+// argument is still evaluated once. A call is only inlined if evaluating
+// its arguments earlier, as the let does, gives the same values. The copy
+// has new IDs and refers to the names in scope of the function rather than
+// those of the call. A function is small if its body is made of no more
+// than size objects, none of which are a var, let, for, match, assignment
+// or function. Functions which may call themselves, even through other
+// functions, are never inlined.
+//
+// The package must have been type checked. Folding the package again then
+// reduces calls with constant arguments to a constant.
+func Inline(pkg *Package, size int) {
+	in := &inliner{
+		pkg:      pkg,
+		size:     size,
+		bodies:   make(map[*Function][]Object),
+		assigned: make(map[*Param]bool),
+	}
+	for _, o := range pkg.scope.m {
+		inspect(o, func(o Object) bool {
+			if a, ok := o.(*Assignment); ok {
+				if p, ok := a.Scope().Lookup(a.Lhs).(*Param); ok {
+					in.assigned[p] = true
+				}
+			}
+			return true
+		})
+		d, ok := o.(*Define)
+		if !ok {
+			continue
+		}
+		f, ok := d.Body.(*Function)
+		if !ok {
+			continue
+		}
+		funcs := []*Function{f}
+		if f.IsGeneric() {
+			funcs = f.Instances
+		}
+		for _, fn := range funcs {
+			if in.isSmall(fn) && !isRecursive(fn) {
+				in.bodies[fn] = in.cloneList(fn.Body, fn.Scope())
+			}
+		}
+	}
+	for _, o := range pkg.scope.m {
+		in.inline(o)
+	}
+}
+
+// inline inlines the calls made within o, then o itself if it is a call,
+// and returns the result
+func (in *inliner) inline(o Object) Object {
+	rewrite(o, in.inline)
+	c, ok := o.(*Call)
+	if !ok {
+		return o
+	}
+	f := calledFunc(c)
+	body, ok := in.bodies[f]
+	if !ok || !in.stable(c.Args) {
+		return o
+	}
+
+	s := NewScope(f.Scope().parent)
+	v := &Variable{
+		object: object{
+			id:    in.pkg.getID(),
+			kind:  ast.VarDecl,
+			name:  "let",
+			pkg:   in.pkg,
+			pos:   c.Pos(),
+			scope: s,
+			typ:   f.Result(),
+		},
+		Inits: c.Args,
+	}
+	for _, p := range f.Params {
+		param := &Param{object: p.object}
+		param.id, param.scope = in.pkg.getID(), s
+		s.Insert(param)
+		v.Params = append(v.Params, param)
+	}
+	for _, e := range body {
+		v.Body = append(v.Body, in.inline(in.clone(e, s)))
+	}
+	return v
+}
+
+// stable returns true if the values of args are the same whenever they are
+// evaluated, since they call no functions and read no variables which may
+// be assigned. The arguments of an inlined call are evaluated by the let
+// replacing it, before the rest of the expression containing the call,
+// rather than when the call itself is made.
+func (in *inliner) stable(args []Object) bool {
+	ok := true
+	for _, a := range args {
+		inspect(a, func(o Object) bool {
+			switch t := o.(type) {
+			case *Assignment, *Branch, *Call, *For:
+				ok = false
+			case *Var:
+				if p, _ := t.Scope().Lookup(t.Name()).(*Param); in.assigned[p] {
+					ok = false
+				}
+			}
+			return ok
+		})
+	}
+	return ok
+}
+
+// isSmall returns true if the body of f is small enough to be inlined and
+// is made only of objects which may be copied by clone
+func (in *inliner) isSmall(f *Function) bool {
+	n, small := 0, true
+	for _, e := range f.Body {
+		inspect(e, func(o Object) bool {
+			switch o.(type) {
+			case *Assignment, *Branch, *For, *Function, *Match, *Variable:
+				small = false
+			}
+			n++
+			return small
+		})
+	}
+	return small && n <= in.size
+}
+
+// isRecursive returns true if f refers to itself, directly or through the
+// functions it refers to
+func isRecursive(f *Function) bool {
+	seen := make(map[*Function]bool)
+	var refers func(g *Function) bool
+	refers = func(g *Function) bool {
+		found := false
+		for _, e := range g.Body {
+			inspect(e, func(o Object) bool {
+				h := referredFunc(o)
+				switch {
+				case h == f:
+					found = true
+				case h != nil && !seen[h]:
+					seen[h] = true
+					found = refers(h)
+				}
+				return !found
+			})
+			if found {
+				return true
+			}
+		}
+		return false
+	}
+	return refers(f)
+}
+
+// referredFunc returns the function o refers to if o is a variable naming a
+// function bound by a define or is an instance of a generic one, otherwise
+// nil
+func referredFunc(o Object) *Function {
+	switch t := o.(type) {
+	case *Instance:
+		return t.Func
+	case *Var:
+		if d, ok := t.Scope().Lookup(t.Name()).(*Define); ok {
+			if f, ok := d.Body.(*Function); ok && !f.IsGeneric() {
+				return f
+			}
+		}
+	}
+	return nil
+}
+
+// clone returns a copy of o, and the objects it is made of, in scope s.
+// Objects with an ID are given a new one so that each copy is distinct.
+func (in *inliner) clone(o Object, s *Scope) Object {
+	switch t := o.(type) {
+	case nil:
+		return nil
+	case *ArrayLit:
+		c := *t
+		c.scope, c.Elems = s, in.cloneList(t.Elems, s)
+		return &c
+	case *Binary:
+		c := *t
+		c.scope, c.Lhs, c.Rhs = s, in.clone(t.Lhs, s), in.clone(t.Rhs, s)
+		return &c
+	case *Call:
+		c := *t
+		c.scope, c.Func, c.Args = s, in.clone(t.Func, s), in.cloneList(t.Args, s)
+		return &c
+	case *Cond:
+		c := *t
+		c.id, c.scope = in.pkg.getID(), s
+		c.Clauses = in.cloneClauses(t.Clauses, s)
+		c.Else = in.clone(t.Else, s)
+		return &c
+	case *Constant:
+		c := *t
+		c.scope = s
+		return &c
+	case *Conversion:
+		c := *t
+		c.scope, c.Args = s, in.cloneList(t.Args, s)
+		return &c
+	case *If:
+		c := *t
+		c.id, c.scope = in.pkg.getID(), s
+		c.Cond, c.Then = in.clone(t.Cond, s), in.clone(t.Then, s)
+		c.Else = in.clone(t.Else, s)
+		return &c
+	case *Instance:
+		c := *t
+		c.scope = s
+		return &c
+	case *Selector:
+		c := *t
+		c.scope, c.X = s, in.clone(t.X, s)
+		return &c
+	case *StructLit:
+		c := *t
+		c.scope, c.Args = s, in.cloneList(t.Args, s)
+		return &c
+	case *Switch:
+		c := *t
+		c.id, c.scope = in.pkg.getID(), s
+		c.Tag, c.Clauses = in.clone(t.Tag, s), in.cloneClauses(t.Clauses, s)
+		c.Else = in.clone(t.Else, s)
+		return &c
+	case *Unary:
+		c := *t
+		c.scope, c.Rhs = s, in.clone(t.Rhs, s)
+		return &c
+	case *UnionLit:
+		c := *t
+		c.scope, c.Args = s, in.cloneList(t.Args, s)
+		return &c
+	case *Var:
+		c := *t
+		c.scope = s
+		return &c
+	}
+	panic("unreachable")
+}
+
+func (in *inliner) cloneList(list []Object, s *Scope) []Object {
+	out := make([]Object, len(list))
+	for i, o := range list {
+		out[i] = in.clone(o, s)
+	}
+	return out
+}
+
+func (in *inliner) cloneClauses(clauses []*Clause, s *Scope) []*Clause {
+	out := make([]*Clause, len(clauses))
+	for i, c := range clauses {
+		out[i] = &Clause{Test: in.clone(c.Test, s), Body: in.clone(c.Body, s)}
+	}
+	return out
+}
@@ -53,6 +53,12 @@ func TestBinary(t *testing.T) {
 func TestCall(t *testing.T) {
 	tests := []Test{
 		{src: "(fn)", pass: false},
+		{src: "((func (x:int):int (* x x)) 4)", pass: true},
+		{src: "((func (x:int):int (* x x)) true)", pass: false},
+		{src: "((func (x:int):int (* x x)))", pass: false},
+		{src: "((func:(func int):int (func (x:int):int x)) 4)", pass: false},
+		{src: "(((func:(func int):int (func (x:int):int x))) 4)", pass: true},
+		{src: "((+ 1 2) 4)", pass: false},
 		//{src: "(decl fn (a int) int (a))", pass: false},
 		//{src: "(decl fn int ((var a int) (a)))", pass: false},
 	}
@@ -65,12 +71,117 @@ func TestConstant(t *testing.T) {
 	tests := []Test{
 		{src: "42", pass: true},
 		{src: "true", pass: true},
+		{src: "0x2A", pass: true},
+		{src: "0b1_0000_0000", pass: true},
+		{src: "(u8 0xFF)", pass: true},
+		{src: "(u8 0x1_00)", pass: false},
+		{src: "(u64 0xFFFF_FFFF_FFFF_FFFF)", pass: true},
 	}
 	for i, test := range tests {
 		test_expression(t, fmt.Sprintf("constant%d", i), test)
 	}
 }
 
+func TestConstantSpelling(t *testing.T) {
+	for _, src := range []string{"0xFF", "0o17", "0b1010", "1_000", "2.5e3"} {
+		e, err := parse.ParseExpression("spelling", src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkg := ir.MakePackage(&ast.Package{}, "spelling")
+		if s := ir.MakeExpr(pkg, e).String(); s != src {
+			t.Errorf("expected constant '%s' but got '%s'", src, s)
+		}
+	}
+}
+
+func TestInvalidLiteral(t *testing.T) {
+	tests := []struct {
+		kind     token.Token
+		lit, err string
+	}{
+		{token.BOOL, "yes", "literal:1:1 invalid boolean yes\n"},
+		{token.FLOAT, "1e400", "literal:1:1 invalid float 1e400\n"},
+		{token.INTEGER, "0b12", "literal:1:1 invalid integer 0b12\n"},
+		{token.INTEGER, "012", "literal:1:1 invalid integer 012\n"},
+	}
+	for _, test := range tests {
+		pkg := ir.MakePackage(&ast.Package{}, "literal")
+		ir.MakeExpr(pkg, &ast.BasicLit{LitPos: 1, Kind: test.kind, Lit: test.lit})
+		fset := token.NewFileSet()
+		fset.Add("literal", len(test.lit))
+		err := ir.TypeCheck(pkg, fset)
+		if err == nil || err.Error() != test.err {
+			t.Errorf("%s: expected error %q, got %v", test.lit, test.err, err)
+		}
+	}
+}
+
+func TestConversion(t *testing.T) {
+	tests := []Test{
+		{src: "(func (a:i64):i32 (i32 a))", pass: true},
+		{src: "(func (a:i64):i32 a)", pass: false},
+		{src: "(func (a:u8):i64 (i64 a))", pass: true},
+		{src: "(func (a:bool):i32 (i32 a))", pass: false},
+		{src: "(func (a:int):bool (bool a))", pass: false},
+		{src: "(u8 255)", pass: true},
+		{src: "(u8 256)", pass: false},
+		{src: "(i8 -128)", pass: true},
+		{src: "(u8 1 2)", pass: false},
+		{src: "(u8)", pass: false},
+	}
+	for i, test := range tests {
+		test_expression(t, fmt.Sprintf("conversion%d", i), test)
+	}
+}
+
+func TestFloat(t *testing.T) {
+	tests := []Test{
+		{src: "1.5", pass: true},
+		{src: "(+ 1.5 2.5)", pass: true},
+		{src: "(+ 1.5 2)", pass: true},
+		{src: "(func (a:float):float (* a 2))", pass: true},
+		{src: "(func (a:float b:int):float (* a b))", pass: false},
+		{src: "(func (a:float):float (% a 2))", pass: false},
+		{src: "(func (a:float b:float):bool (<= a b))", pass: true},
+		{src: "(func (a:float):bool (== a 0))", pass: true},
+		{src: "(func (a:float):float -a)", pass: true},
+		{src: "(func (a:int):float (float a))", pass: true},
+		{src: "(func (a:float):i64 (i64 a))", pass: true},
+		{src: "(func (a:float):int a)", pass: false},
+		{src: "(func (a:int):float a)", pass: false},
+		{src: "(func:float 1)", pass: true},
+		{src: "(func:int 1.0)", pass: false},
+		{src: "(func (a:bool):float (float a))", pass: false},
+	}
+	for i, test := range tests {
+		test_expression(t, fmt.Sprintf("float%d", i), test)
+	}
+}
+
+func TestBitwise(t *testing.T) {
+	tests := []Test{
+		{src: "(& 6 3)", pass: true},
+		{src: "(| 1 2 4)", pass: true},
+		{src: "(func (a:u8 b:u8):u8 (^ a b))", pass: true},
+		{src: "(func (a:u32):u32 (<< a 3))", pass: true},
+		{src: "(func (a:i64 b:i64):i64 (>> a b))", pass: true},
+		{src: "(func (a:u8 b:int):u8 (<< a b))", pass: true},
+		{src: "(func (a:i64 b:u8):i64 (>> a b))", pass: true},
+		{src: "(>> (i8 -1) 200)", pass: true},
+		{src: "(func (a:u8 b:float):u8 (<< a b))", pass: false},
+		{src: "(func (a:u8):u8 (<< a true))", pass: false},
+		{src: "(func (a:float):float (& a 1))", pass: false},
+		{src: "(func (a:bool):bool (| a true))", pass: false},
+		{src: "(func (a:u16):u16 ~a)", pass: true},
+		{src: "(func (a:float):float ~a)", pass: false},
+		{src: "(u8 (<< 1 8))", pass: false},
+	}
+	for i, test := range tests {
+		test_expression(t, fmt.Sprintf("bitwise%d", i), test)
+	}
+}
+
 func TestFile(t *testing.T) {
 	tests := []Test{
 		{src: "(define add:int (func (a:int b:int):int (+ a b)))" +
@@ -99,10 +210,472 @@ func TestFile(t *testing.T) {
 	}
 }
 
+func TestFunctionValue(t *testing.T) {
+	tests := []Test{
+		{src: "(define inc (func (a:int):int (+ a 1)))" +
+			"(define apply (func (f:(func int):int a:int):int (f a)))" +
+			"(define main (func:int (apply inc 41)))", pass: true},
+		{src: "(define apply (func (f:(func int):int a:int):int (f a)))" +
+			"(define main (func:int (apply (func (a:int):int (* a 2)) 21)))",
+			pass: true},
+		{src: "(define inc (func (a:int):int (+ a 1)))" +
+			"(define dec (func (a:int):int (- a 1)))" +
+			"(define pick (func (up:bool):(func int):int " +
+			"(if up:(func int):int inc dec)))" +
+			"(define main (func:int (var (f:(func int):int):int " +
+			"(= f (pick true)) (f 1))))", pass: true},
+		{src: "(define inc (func (a:int):int (+ a 1)))" +
+			"(define f:(func int):int inc)" +
+			"(define main (func:int (f 1)))", pass: true},
+		{src: "(define inc (func (a:int):int (+ a 1)))" +
+			"(define apply (func (f:(func bool):int):int (f true)))" +
+			"(define main (func:int (apply inc)))", pass: false},
+		{src: "(define inc (func (a:int):int (+ a 1)))" +
+			"(define apply (func (f:(func int):int):int (f true)))" +
+			"(define main (func:int (apply inc)))", pass: false},
+		{src: "(define apply (func (f:(func int):int):int (f 1 2)))" +
+			"(define main (func:int 0))", pass: false},
+		{src: "(define apply (func (f:int):int (f 1)))" +
+			"(define main (func:int 0))", pass: false},
+		{src: "(define inc:(func int):u8 (func (a:int):int (+ a 1)))" +
+			"(define main (func:int 0))", pass: false},
+		{src: "(define inc (func (a:int):int (+ a 1)))" +
+			"(define main (func:int (+ inc 1)))", pass: false},
+	}
+	for i, test := range tests {
+		test_file(t, fmt.Sprintf("funcvalue%d", i), test)
+	}
+}
+
+func TestArray(t *testing.T) {
+	tests := []Test{
+		{src: "(define main (func:int (var (a:[3]int):int (= a [1 2 3]) " +
+			"(= a 1 (a 2)) (+ (a 0) (len a)))))", pass: true},
+		{src: "(define f (func (a:[2]u8):u8 (a 1)))" +
+			"(define main (func:u8 (f [1 255])))", pass: true},
+		{src: "(define main (func:float (var (a:[2]float):float " +
+			"(= a [1 2.5]) (a (u64 1)))))", pass: true},
+		{src: "(define main (func:int (var (m:[2][3]int):int " +
+			"(= m 1 [4 5 6]) ((m 1) 2))))", pass: true},
+		{src: "(define main (func:i8 ([(i8 1) 2 3] 2)))", pass: true},
+		{src: "(define main (func:int (len [1 2 3])))", pass: true},
+		{src: "(define main (func:int (var (a:[3]int):int (= a [1 2]) 0)))",
+			pass: false},
+		{src: "(define f (func (a:[2]u8):u8 (a 1)))" +
+			"(define main (func:u8 (f [1 256])))", pass: false},
+		{src: "(define main (func:int ([1 true] 0)))", pass: false},
+		{src: "(define main (func:int ([] 0)))", pass: false},
+		{src: "(define main (func:int ([1 2] 2)))", pass: false},
+		{src: "(define main (func:int ([1 2] -1)))", pass: false},
+		{src: "(define main (func:int ([1 2] true)))", pass: false},
+		{src: "(define main (func:int ([1 2] 0 1)))", pass: false},
+		{src: "(define main (func:int (var (a:[2]int):int (= a 0 true) 0)))",
+			pass: false},
+		{src: "(define main (func:int (var (a:int):int (= a 0 1) 0)))",
+			pass: false},
+		{src: "(define main (func:int (len 1)))", pass: false},
+	}
+	for i, test := range tests {
+		test_file(t, fmt.Sprintf("array%d", i), test)
+	}
+}
+
+func TestList(t *testing.T) {
+	tests := []Test{
+		{src: "(define main (func:int (var (xs:[]int):int " +
+			"(= xs (list 1 2 3)) (append xs 4 5) (= xs 0 (get xs 4)) " +
+			"(len (slice xs 1 (u8 3))))))", pass: true},
+		{src: "(define main (func:u8 (get (list (u8 1) 2) 1)))", pass: true},
+		{src: "(define f (func (xs:[][]float):float (get (get xs 0) 0)))" +
+			"(define main (func:float (f (list (list 1.5)))))", pass: true},
+		{src: "(define main (func:int (len (list))))", pass: false},
+		{src: "(define f (func (xs:[]u8):int (len xs)))" +
+			"(define main (func:int (f (list))))", pass: true},
+		{src: "(define main (func:int (len (list (list) (list 1)))))",
+			pass: true},
+		{src: "(define main (func:int (len (if true (list) (list 1)))))",
+			pass: true},
+		{src: "(define main (func:int (len (list 1 true))))", pass: false},
+		{src: "(define main (func:int (len (append (list 1) 2.5))))",
+			pass: false},
+		{src: "(define main (func:int (len (append 1 2))))", pass: false},
+		{src: "(define main (func:int (len (append (list 1)))))", pass: false},
+		{src: "(define main (func:int (get (list 1) -1)))", pass: false},
+		{src: "(define main (func:int (get (list 1) true)))", pass: false},
+		{src: "(define main (func:int (len (slice (list 1) 0))))", pass: false},
+		{src: "(define main (func:int (var (xs:[]int):int (= xs 0 true) 0)))",
+			pass: false},
+		{src: "(define main (func:int (var (xs:[]int):int (= xs (list 1.0)) " +
+			"0)))", pass: false},
+	}
+	for i, test := range tests {
+		test_file(t, fmt.Sprintf("list%d", i), test)
+	}
+}
+
+func TestBuiltin(t *testing.T) {
+	tests := []Test{
+		{src: "(define add (func (a:int b:int):int (+ a b)))" +
+			"(define main (func:int (fold (range 1 10) 0 add)))", pass: true},
+		{src: "(define sq (func (a:u8):u8 (* a a)))" +
+			"(define main (func:u8 (reduce (map (range (u8 1) 4) sq) " +
+			"(func (a:u8 b:u8):u8 (+ a b)))))", pass: true},
+		{src: "(define even (func (a:int):bool (== (% a 2) 0)))" +
+			"(define main (func:int (count (filter (range 0 10) even) even)))",
+			pass: true},
+		{src: "(define f (func (xs:[]int):bool (any xs " +
+			"(func (a:int):bool (all xs (func (b:int):bool (<= b a)))))))" +
+			"(define main (func:int 0))", pass: true},
+		{src: "(define half (func (a:int):float (/ (float a) 2.0)))" +
+			"(define main (func:float (fold (map (range 0 4) half) 0 " +
+			"(func (a:float b:float):float (+ a b)))))", pass: true},
+		{src: "(define map (func (a:int):int a))" +
+			"(define main (func:int (map 1)))", pass: true},
+		{src: "(define main (func:int (fold (range 1 10) 0)))", pass: false},
+		{src: "(define main (func:int (count 1 (func (a:int):bool true))))",
+			pass: false},
+		{src: "(define main (func:int (count (range 0 1.0) " +
+			"(func (a:int):bool true))))", pass: false},
+		{src: "(define main (func:int (count (range 0 (i8 1)) " +
+			"(func (a:int):bool true))))", pass: false},
+		{src: "(define main (func:bool (any (range 0 1) " +
+			"(func (a:int):int a))))", pass: false},
+		{src: "(define main (func:int (fold (range 0 1) true " +
+			"(func (a:int b:int):int a))))", pass: false},
+		{src: "(define main (func:int (var (f:int):int (= f map) 0)))",
+			pass: false},
+		{src: "(define main (func:int (= map 1)))", pass: false},
+	}
+	for i, test := range tests {
+		test_file(t, fmt.Sprintf("builtin%d", i), test)
+	}
+}
+
+func TestStruct(t *testing.T) {
+	point := "(define Point (struct (x:int y:int)))"
+	tests := []Test{
+		{src: point + "(define main (func:int (var (p:Point):int " +
+			"(= p (Point 1 2)) (= p.x 3) (+ p.x p.y))))", pass: true},
+		{src: "(define Line (struct (a:Point b:Point)))" + point +
+			"(define main (func:int (var (l:Line):int (= l.b.y 4) " +
+			"(+ l.a.x (Line (Point 1 2) l.b).b.y))))", pass: true},
+		{src: point + "(define f (func (p:Point):u8 (u8 p.x)))" +
+			"(define main (func:u8 (f (Point 1 (+ 1 1)))))", pass: true},
+		{src: "(define T (struct (xs:[]int a:[2]int f:(func int):int)))" +
+			"(define main (func:int (var (t:T):int (= t.a 1 5) " +
+			"(append t.xs 1) (+ (t.a 1) (t.f 1)))))", pass: true},
+		{src: "(define List (struct (next:[]List)))" +
+			"(define main (func:int (var (l:List):int (len l.next))))", pass: true},
+		{src: point + "(define Pair (struct (x:int y:int)))" +
+			"(define main (func:int (var (p:Point):int (= p (Pair 1 2)) 0)))",
+			pass: false},
+		{src: point + "(define main (func:int (Point 1 2).z))", pass: false},
+		{src: point + "(define main (func:int (var (p:Point):int " +
+			"(= p.z 1) 0)))", pass: false},
+		{src: point + "(define main (func:int (var (p:Point):int " +
+			"(= p.x.y 1) 0)))", pass: false},
+		{src: point + "(define main (func:int (Point 1).x))", pass: false},
+		{src: point + "(define main (func:int (Point 1 true).x))", pass: false},
+		{src: point + "(define main (func:int (var (p:Point):int p)))",
+			pass: false},
+		{src: point + "(define main (func:int Point))", pass: false},
+		{src: "(define main (func:int 1.x))", pass: false},
+		{src: "(define T (struct (t:T)))(define main (func:int 0))",
+			pass: false},
+		{src: "(define A (struct (b:B)))(define B (struct (a:[2]A)))" +
+			"(define main (func:int 0))", pass: false},
+	}
+	for i, test := range tests {
+		test_file(t, fmt.Sprintf("struct%d", i), test)
+	}
+}
+
+func TestUnion(t *testing.T) {
+	result := "(define Result (union (Ok value:int) (Err code:int msg:int) (None)))"
+	tests := []Test{
+		{src: result + "(define main (func:int (match (Ok 1):int " +
+			"((Ok v) v) ((Err c m) (+ c m)) ((None) 0))))", pass: true},
+		{src: result + "(define main (func:int (var (r:Result):int " +
+			"(= r (Err 1 2)) (match r:int ((Ok v) v) (_ 0)))))", pass: true},
+		{src: result + "(define f (func (r:Result):bool " +
+			"(match r:bool ((None) false) (_ true))))" +
+			"(define main (func:int (if (f (None)):int 1 0)))", pass: true},
+		{src: "(define T (union (Leaf) (Node kids:[]T)))" +
+			"(define main (func:int (match (Leaf):int ((Node k) (len k)) " +
+			"((Leaf) 0))))", pass: true},
+		{src: result + "(define main (func:int (match (Ok 1):int " +
+			"((Ok v) v) ((None) 0))))", pass: false},
+		{src: result + "(define main (func:int (match (Ok 1):int " +
+			"((Ok v) v) ((Ok w) w) (_ 0))))", pass: false},
+		{src: result + "(define main (func:int (match (Ok 1):int " +
+			"(_ 1) (_ 0))))", pass: false},
+		{src: result + "(define main (func:int (match (Ok 1):int " +
+			"((Ok v w) v) (_ 0))))", pass: false},
+		{src: result + "(define main (func:int (match (Ok 1):int " +
+			"((Some v) v) (_ 0))))", pass: false},
+		{src: result + "(define main (func:int (match (Ok 1):int " +
+			"((Ok v) true) (_ 0))))", pass: false},
+		{src: result + "(define main (func:int (match 1:int (_ 0))))",
+			pass: false},
+		{src: result + "(define O (union (Other)))(define main (func:int " +
+			"(match (None):int ((Other) 1) (_ 0))))", pass: false},
+		{src: result + "(define main (func:int (Ok true)))", pass: false},
+		{src: result + "(define main (func:int (var (r:Result):int " +
+			"(= r Ok) 0)))", pass: false},
+		{src: "(define U (union (A u:U)))(define main (func:int 0))",
+			pass: false},
+		{src: "(define T (union (Leaf v:int) (Node l:T r:T)))" +
+			"(define main (func:int (match (Node (Leaf 1) (Leaf 2)):int " +
+			"((Node l r) 1) (_ 0))))", pass: true},
+		{src: "(define U (union (A) (B s:S)))(define S (struct (u:U)))" +
+			"(define main (func:int 0))", pass: false},
+	}
+	for i, test := range tests {
+		test_file(t, fmt.Sprintf("union%d", i), test)
+	}
+}
+
+func TestRecursiveUnion(t *testing.T) {
+	tests := []struct{ src, err string }{
+		{"(define L (union (Cons h:int t:L) (Nil)))(define main (func:int 0))",
+			"union:1:2 first variant of union 'L' may not have a field of " +
+				"type 'L'\n"},
+		{"(define L (union (Nil) (Cons h:int t:L)))(define main (func:int 0))",
+			""},
+	}
+	for _, test := range tests {
+		f, err := parse.ParseFile(token.NewFileSet(), "union", test.src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkg := ir.MakePackage(&ast.Package{Files: []*ast.File{f}}, "union")
+		fset := token.NewFileSet()
+		fset.Add("union", len(test.src))
+		err = ir.TypeCheck(pkg, fset)
+		if (test.err == "" && err != nil) ||
+			(test.err != "" && (err == nil || err.Error() != test.err)) {
+			t.Errorf("%s: expected error %q, got %v", test.src, test.err, err)
+		}
+	}
+}
+
+func TestTypeName(t *testing.T) {
+	tests := []Test{
+		{src: "(define main (func:int (var (a:i8 b:u64 c:bool d:float):int " +
+			"(int a))))", pass: true},
+		{src: "(define P (struct (x:int)))" +
+			"(define main (func:int (var (p:P q:[2]P r:[]P):int p.x)))",
+			pass: true},
+		{src: "(define main (func:int (var (a:[0]int):int 0)))", pass: false},
+		{src: "(define main (func:int (var (a:int):int (var (b:a):int 0))))",
+			pass: false},
+		{src: "(define main (func:int (integer 1)))", pass: false},
+	}
+	for i, test := range tests {
+		test_file(t, fmt.Sprintf("typename%d", i), test)
+	}
+}
+
+func TestUnknownType(t *testing.T) {
+	tests := []struct{ src, err string }{
+		{"(define main (func:integer 0))",
+			"unknown:1:20 unknown type 'integer'\n"},
+		{"(define main (func:int (var (a:[]intt):int 0)))",
+			"unknown:1:34 unknown type 'intt'\n"},
+		{"(define P (struct (x:Q)))(define main (func:int 0))",
+			"unknown:1:22 unknown type 'Q'\n"},
+		{"(define f (func (g:(func foo):int):int 0))(define main (func:int 0))",
+			"unknown:1:26 unknown type 'foo'\n"},
+		{"(define main (func:int (var (a:int):int (var (b:a):int 0))))",
+			"unknown:1:49 'a' is not a type\n"},
+		{"(define main (func:int (var (a:intt) (+ a 1))))",
+			"unknown:1:32 unknown type 'intt'\n"},
+	}
+	for _, test := range tests {
+		f, err := parse.ParseFile(token.NewFileSet(), "unknown", test.src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkg := ir.MakePackage(&ast.Package{Files: []*ast.File{f}}, "unknown")
+		fset := token.NewFileSet()
+		fset.Add("unknown", len(test.src))
+		err = ir.TypeCheck(pkg, fset)
+		if err == nil || err.Error() != test.err {
+			t.Errorf("%s: expected error %q, got %v", test.src, test.err, err)
+		}
+	}
+}
+
+func TestCompositeTypes(t *testing.T) {
+	src := "(define P (struct (a:[2]int)))" +
+		"(define main (func:int (var (f:(func int):int l:[]int) 0)))"
+	var first []ir.Type
+	for i := 0; i < 2; i++ {
+		f, err := parse.ParseFile(token.NewFileSet(), "composite", src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkg := ir.MakePackage(&ast.Package{Files: []*ast.File{f}}, "composite")
+		fset := token.NewFileSet()
+		fset.Add("composite", len(src))
+		if err := ir.TypeCheck(pkg, fset); err != nil {
+			t.Fatal(err)
+		}
+		types := pkg.CompositeTypes()
+		if first == nil {
+			first = types
+			continue
+		}
+		if len(types) != len(first) {
+			t.Fatalf("expected %d composite types, got %d", len(first),
+				len(types))
+		}
+		for j, typ := range types {
+			if typ == first[j] {
+				t.Errorf("type %s is shared between packages", typ)
+			}
+		}
+	}
+}
+
+func TestSignatureInterning(t *testing.T) {
+	src := "(define inc (func (n:int):int (+ n 1)))" +
+		"(define apply (func (f:(func int):int n:int):int (f n)))" +
+		"(define main (func:int (var (g:(func int):int) (= g inc) " +
+		"(apply g 1))))"
+	f, err := parse.ParseFile(token.NewFileSet(), "signature", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg := ir.MakePackage(&ast.Package{Files: []*ast.File{f}}, "signature")
+	fset := token.NewFileSet()
+	fset.Add("signature", len(src))
+	if err := ir.TypeCheck(pkg, fset); err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for _, typ := range pkg.CompositeTypes() {
+		if s, ok := typ.(*ir.Signature); ok && s.String() == "(func i32):i32" {
+			n++
+		}
+	}
+	if n != 1 {
+		t.Errorf("expected one (func i32):i32 signature, got %d", n)
+	}
+}
+
+func TestEmptyList(t *testing.T) {
+	tests := []struct{ src, err string }{
+		{"(define main (func:int (list) 0))",
+			"main:1:25 cannot infer the element type of an empty list\n"},
+		{"(define main (func:int (let ((xs (list))) 0)))",
+			"main:1:35 cannot infer the element type of an empty list\n"},
+		{"(define g (func [T] (xs:[]T):int (len xs)))" +
+			"(define main (func:int (g (list))))",
+			"main:1:68 cannot infer type parameter 'T' of function 'g'\n" +
+				"main:1:71 cannot infer the element type of an empty list\n"},
+		{"(define g (func [T] (xs:[]T x:T):int (len xs)))" +
+			"(define main (func:int (g (list) (u8 1))))", ""},
+		{"(define f (func:[]int (list)))(define main (func:int (len (f))))", ""},
+	}
+	for _, test := range tests {
+		f, err := parse.ParseFile(token.NewFileSet(), "main", test.src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkg := ir.MakePackage(&ast.Package{Files: []*ast.File{f}}, "main")
+		fset := token.NewFileSet()
+		fset.Add("main", len(test.src))
+		err = ir.TypeCheck(pkg, fset)
+		if (test.err == "" && err != nil) ||
+			(test.err != "" && (err == nil || err.Error() != test.err)) {
+			t.Errorf("%s: expected error %q, got %v", test.src, test.err, err)
+		}
+	}
+}
+
+func TestMainResult(t *testing.T) {
+	tests := []struct{ src, err string }{
+		{"(define main (func:[]int (list 1)))",
+			"main:1:2 main must return an integer, float or bool but returns " +
+				"'[]i32'\n"},
+		{"(define P (struct (x:int)))(define main (func:P (P 1)))",
+			"main:1:29 main must return an integer, float or bool but returns " +
+				"'P'\n"},
+		{"(define main (func:(func):int (func:int 1)))",
+			"main:1:2 main must return an integer, float or bool but returns " +
+				"'(func):i32'\n"},
+		{"(define main (func:u8 1))", ""},
+		{"(define main (func:bool true))", ""},
+		{"(define main (func:float 1.5))", ""},
+	}
+	for _, test := range tests {
+		f, err := parse.ParseFile(token.NewFileSet(), "main", test.src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkg := ir.MakePackage(&ast.Package{Files: []*ast.File{f}}, "main")
+		fset := token.NewFileSet()
+		fset.Add("main", len(test.src))
+		err = ir.TypeCheck(pkg, fset)
+		if (test.err == "" && err != nil) ||
+			(test.err != "" && (err == nil || err.Error() != test.err)) {
+			t.Errorf("%s: expected error %q, got %v", test.src, test.err, err)
+		}
+	}
+}
+
+func TestCaptures(t *testing.T) {
+	src := "(define adder (func (n:int):(func int):int " +
+		"(func (x:int):int (+ x n))))" +
+		"(define main (func:int (var (a:int):int " +
+		"(func (x:int):(func int):int (func (y:int):int (+ a x y))) a)))"
+	f, err := parse.ParseFile(token.NewFileSet(), "captures", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg := ir.MakePackage(&ast.Package{Files: []*ast.File{f}}, "captures")
+	fset := token.NewFileSet()
+	fset.Add("captures", len(src))
+	if err := ir.TypeCheck(pkg, fset); err != nil {
+		t.Fatal(err)
+	}
+
+	adder := pkg.Scope().Lookup("adder").(*ir.Define).Body.(*ir.Function)
+	inner := adder.Body[0].(*ir.Function)
+	if len(adder.Captures()) != 0 || !adder.Params[0].Captured() {
+		t.Fatal("expected parameter 'n' of adder to be captured")
+	}
+	if len(inner.Captures()) != 1 || inner.Captures()[0] != adder.Params[0] {
+		t.Fatalf("expected closure in adder to capture 'n', got %v",
+			inner.Captures())
+	}
+
+	v := pkg.Scope().Lookup("main").(*ir.Define).Body.(*ir.Function).Body[0]
+	outer := v.(*ir.Variable).Body[0].(*ir.Function)
+	innermost := outer.Body[0].(*ir.Function)
+	if len(outer.Captures()) != 1 || len(innermost.Captures()) != 2 {
+		t.Fatalf("expected nested closures to capture 1 and 2 variables, got "+
+			"%v and %v", outer.Captures(), innermost.Captures())
+	}
+}
+
 func TestFor(t *testing.T) {
 	tests := []Test{
 		{src: "(for true :int 0)", pass: true},
 		{src: "(for (!= 1 2) :bool false)", pass: true},
+		{src: "(for true :int (break))", pass: true},
+		{src: "(for true :int (break 1) (continue))", pass: true},
+		{src: "(for true :bool (if false:bool (continue) (break true)))",
+			pass: true},
+		{src: "(for true :int (for true:bool (break true)) (break 2))",
+			pass: true},
+		{src: "(for true :int (break false))", pass: false},
+		{src: "(for true :int (for true:bool (break 1)) 0)", pass: false},
+		{src: "(break)", pass: false},
+		{src: "(continue)", pass: false},
+		{src: "(if true:int (break 1))", pass: false},
+		{src: "(for true :int (func:int (break 1)) 0)", pass: false},
 	}
 	for i, test := range tests {
 		test_expression(t, fmt.Sprintf("for%d", i), test)
@@ -126,16 +699,302 @@ func TestIf(t *testing.T) {
 	}
 }
 
+func TestCond(t *testing.T) {
+	tests := []Test{
+		{src: "(cond:int ((== 1 1) 1) (false 2))", pass: true},
+		{src: "(func (n:int):int (cond:int ((< n 0) 0) ((< n 9) n) " +
+			"(else 9)))", pass: true},
+		{src: "(func (n:u8):u8 (cond:u8 ((< n 2) 1) (else n)))", pass: true},
+		{src: "(cond:int (1 1))", pass: false},
+		{src: "(cond:int (true false))", pass: false},
+		{src: "(cond:int (true 1) (else false))", pass: false},
+		{src: "(func (n:int):int (cond:int ((< n 0) 0) ((< n 0) 1)))",
+			pass: false},
+	}
+	for i, test := range tests {
+		test_expression(t, fmt.Sprintf("cond%d", i), test)
+	}
+}
+
+func TestSwitch(t *testing.T) {
+	tests := []Test{
+		{src: "(switch 1:int (0 1) (1 2))", pass: true},
+		{src: "(func (n:u8):int (switch n:int (0 1) (255 2) " +
+			"((+ 1 2) 3) (else 4)))", pass: true},
+		{src: "(func (n:i64):int (switch n:int (-1 1) (~1 2)))", pass: true},
+		{src: "(switch true:int (1 1))", pass: false},
+		{src: "(switch 1.5:int (1 1))", pass: false},
+		{src: "(switch 1:int (1 1) (0 false))", pass: false},
+		{src: "(switch 1:int (1 1) (else true))", pass: false},
+		{src: "(switch 1:int (1 1) (true 2))", pass: false},
+		{src: "(switch 1:int (1.0 1))", pass: false},
+		{src: "(func (n:int):int (switch 1:int (n 1)))", pass: false},
+		{src: "(func (n:u8):int (switch n:int (256 1)))", pass: false},
+		{src: "(switch 1:int (1 1) (2 2) ((- 2 1) 3))", pass: false},
+	}
+	for i, test := range tests {
+		test_expression(t, fmt.Sprintf("switch%d", i), test)
+	}
+}
+
+func TestInference(t *testing.T) {
+	tests := []Test{
+		{src: "(if true 1 2)", pass: true},
+		{src: "(if true (u8 1) 2)", pass: true},
+		{src: "(if true 1 (u8 2))", pass: true},
+		{src: "(if true 1)", pass: true},
+		{src: "(if true 1 false)", pass: false},
+		{src: "(if true (i8 1) (u8 2))", pass: false},
+		{src: "(func (n:u8) (* n n))", pass: true},
+		{src: "(func () (if true 1 2))", pass: true},
+		{src: "(var (a:bool) (= a true) a)", pass: true},
+		{src: "(for false 1)", pass: true},
+		{src: "(for true (if true (break true)) false)", pass: true},
+		{src: "(for true (if true (break (u8 1))) 2)", pass: true},
+		{src: "(for true (if true (break true)) 1)", pass: false},
+		{src: "(cond (true (u8 1)) (else 2))", pass: true},
+		{src: "(cond (true 1) (false true))", pass: false},
+		{src: "(switch 1 (1 (i64 1)) (else 0))", pass: true},
+		{src: "(switch 1 (1 1) (else false))", pass: false},
+		{src: "(func:bool (if true 1 2))", pass: false},
+		{src: "(if true:int true false)", pass: false},
+	}
+	for i, test := range tests {
+		test_expression(t, fmt.Sprintf("inference%d", i), test)
+	}
+	files := []Test{
+		{src: "(define f:int (func (n:int) (if (< n 1) 0 (f (- n 1)))))" +
+			"(define main (func:int (f 3)))", pass: true},
+		{src: "(define f (func (n:int):int (if (< n 1) 0 (f (- n 1)))))" +
+			"(define main (func:int (f 3)))", pass: true},
+		{src: "(define f (func (n:int) (if (< n 1) 0 (f (- n 1)))))" +
+			"(define main (func:int (f 3)))", pass: false},
+		{src: "(define f (func (n:int) (g n)))(define g (func (n:int) (f n)))" +
+			"(define main (func:int (f 3)))", pass: false},
+		{src: "(define f:bool (func (n:int) n))(define main (func:int 0))",
+			pass: false},
+		{src: "(define O (union (Some v:int) (None)))" +
+			"(define main (func () (match (None) ((Some v) v) (_ 0))))",
+			pass: true},
+		{src: "(define O (union (Some v:int) (None)))" +
+			"(define main (func () (match (None) ((Some v) v) (_ true))))",
+			pass: false},
+	}
+	for i, test := range files {
+		test_file(t, fmt.Sprintf("inferencefile%d", i), test)
+	}
+}
+
+func TestRecursiveInference(t *testing.T) {
+	tests := []struct{ src, err string }{
+		{"(define fact (func (n:int) (if (== n 0) 1 (* n (fact (- n 1))))))" +
+			"(define main (func:int (fact 5)))",
+			"recursive:1:49 type of 'fact' must be declared since it refers " +
+				"to itself\n"},
+		{"(define f (func (n:int) (g n)))(define g (func (n:int) (f n)))" +
+			"(define main (func:int (f 3)))",
+			"recursive:1:57 type of 'f' must be declared since it refers " +
+				"to itself\n"},
+		{"(define f (func (n:int) (if (f n) 1 2)))" +
+			"(define main (func:int (f 3)))",
+			"recursive:1:30 type of 'f' must be declared since it refers " +
+				"to itself\n"},
+	}
+	for _, test := range tests {
+		f, err := parse.ParseFile(token.NewFileSet(), "recursive", test.src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkg := ir.MakePackage(&ast.Package{Files: []*ast.File{f}}, "recursive")
+		fset := token.NewFileSet()
+		fset.Add("recursive", len(test.src))
+		err = ir.TypeCheck(pkg, fset)
+		if err == nil || err.Error() != test.err {
+			t.Errorf("%s: expected error %q, got %v", test.src, test.err, err)
+		}
+	}
+}
+
+func TestGeneric(t *testing.T) {
+	const max = "(define max (func [T] (a:T b:T):T (if (> a b) a b)))"
+	const id = "(define id (func [T] (x:T) x))"
+	tests := []Test{
+		{src: max + "(define main (func:int (max 1 2)))", pass: true},
+		{src: max + "(define main (func:u8 (max (u8 1) 2)))", pass: true},
+		{src: max + "(define main (func:u8 (max 1 (u8 2))))", pass: true},
+		{src: max + "(define main (func:int (max (u8 1) (i8 2))))", pass: false},
+		{src: max + "(define main (func:int (max 1)))", pass: false},
+		{src: max + "(define main (func:int (if (max true false) 1 0)))",
+			pass: false},
+		{src: id + "(define main (func:bool (id true)))", pass: true},
+		{src: id + "(define main (func:int (id[int] 1)))", pass: true},
+		{src: id + "(define main (func:int (id[bool] 1)))", pass: false},
+		{src: id + "(define main (func:int (id[int bool] 1)))", pass: false},
+		{src: id + "(define main (func:int (id[Foo] 1)))", pass: false},
+		{src: id + "(define main (func:int (var (f:(func int):int) " +
+			"(= f id[int]) (f 1))))", pass: true},
+		{src: id + "(define main (func:int (var (f:(func int):int) " +
+			"(= f id) (f 1))))", pass: false},
+		{src: "(define size (func [T] (xs:[]T):int (len xs)))" +
+			"(define main (func:int (+ (size (list 1)) (size (list true)))))",
+			pass: true},
+		{src: "(define apply (func [A B] (f:(func A):B a:A):B (f a)))" +
+			"(define odd (func (n:int) (== (% n 2) 1)))" +
+			"(define main (func:bool (apply odd 3)))", pass: true},
+		{src: "(define zero (func [T] ():T 0))" +
+			"(define main (func:int (zero)))", pass: false},
+		{src: "(define zero (func [T] ():T 0))" +
+			"(define main (func:u8 (zero[u8])))", pass: true},
+		{src: "(define count (func [T] (xs:[]T):int (if (== (len xs) 0) 0 " +
+			"(+ 1 (count (slice xs 1 (len xs)))))))" +
+			"(define main (func:int (count (list 1 2))))", pass: true},
+		{src: "(define r (func [T] (x:T) (r x)))" +
+			"(define main (func:int (r 1)))", pass: false},
+		{src: "(define deep (func [T] (x:T):int (deep (list x))))" +
+			"(define main (func:int (deep 1)))", pass: false},
+		{src: "(define main (func:int ((func [T] (x:T) x) 1)))", pass: false},
+		{src: "(define main (func:int (main[int])))", pass: false},
+	}
+	for i, test := range tests {
+		test_file(t, fmt.Sprintf("generic%d", i), test)
+	}
+}
+
+func TestSizedInteger(t *testing.T) {
+	tests := []Test{
+		{src: "(func (a:u8 b:u8):u8 (+ a b))", pass: true},
+		{src: "(func (a:u8 b:i8):u8 (+ a b))", pass: false},
+		{src: "(func (a:i16 b:i32):bool (< a b))", pass: false},
+		{src: "(func (a:int b:i32):int (+ a b))", pass: true},
+		{src: "(func (a:u8):u8 (+ a 255))", pass: true},
+		{src: "(func (a:u8):u8 (+ a 256))", pass: false},
+		{src: "(func (a:u8):u8 (+ a -1))", pass: false},
+		{src: "(func (a:i8):i8 -a)", pass: true},
+		{src: "(func (a:i8):i16 -a)", pass: false},
+		{src: "(func (a:u64):bool (< a 18446744073709551615))", pass: true},
+		{src: "(func (a:i64):bool (< a 18446744073709551615))", pass: false},
+		{src: "(func (a:u16 b:u16):bool (== a b))", pass: true},
+		{src: "(func (a:u16):bool (== a true))", pass: false},
+		{src: "(func:u32 (+ 1 2))", pass: true},
+		{src: "(func:u32 (+ 4294967296 2))", pass: false},
+		{src: "(var (a:i64):i64 (= a 4294967296) a)", pass: true},
+		{src: "(var (a:i32):i32 (= a 4294967296) a)", pass: false},
+		{src: "(if true:u8 1 256)", pass: false},
+		{src: "(func:int (+ 2147483647 1))", pass: false},
+		{src: "(func:u8 (- 300 100))", pass: true},
+		{src: "(func:u8 (- 100 300))", pass: false},
+	}
+	for i, test := range tests {
+		test_expression(t, fmt.Sprintf("sized%d", i), test)
+	}
+}
+
+func TestSizedFile(t *testing.T) {
+	tests := []Test{
+		{src: "(define a:u8 255)(define main (func:u8 a))", pass: true},
+		{src: "(define a:u8 256)(define main (func:u8 a))", pass: false},
+		{src: "(define a 2)(define main (func:u8 a))", pass: false},
+		{src: "(define a (u8 2))(define main (func:u8 (+ a 1)))", pass: true},
+		{src: "(define fn (func (a:i64):i64 a))" +
+			"(define main (func:i64 (fn 4294967296)))", pass: true},
+		{src: "(define fn (func (a:i8):i8 a))" +
+			"(define main (func:i8 (fn 128)))", pass: false},
+	}
+	for i, test := range tests {
+		test_file(t, fmt.Sprintf("sizedfile%d", i), test)
+	}
+}
+
+func TestTailCalls(t *testing.T) {
+	tests := []struct {
+		src   string
+		calls []string // caller->callee of each tail call
+	}{
+		{"(define f (func (n:int):int (if (< n 1) 0 (f (- n 1)))))",
+			[]string{"f->f"}},
+		{"(define f (func (n:int):int (+ 1 (if (< n 1) 0 (f (- n 1))))))", nil},
+		{"(define f (func (n:int):int (f n) 0))", nil},
+		{"(define f (func (n:int):int (for true (f n))))", nil},
+		{"(define f (func (n:int):int ((func (m:int):int (f m)) n)))", nil},
+		{"(define f (func (n:int):int (var (m:int) (g m))))" +
+			"(define g (func (n:int):int (cond ((< n 1) 0) (else (f n)))))",
+			[]string{"f->g", "g->f"}},
+		{"(define f (func (n:int):int (switch n (1 (g n)) (else (len (list n))))))" +
+			"(define g (func (n:int):int (let ((m n)) (h[int] m))))" +
+			"(define h (func [T] (n:T):T (h n)))",
+			[]string{"f->g", "g->h", "h->h"}},
+	}
+	for i, test := range tests {
+		name := fmt.Sprintf("tail%d", i)
+		fset := token.NewFileSet()
+		f, err := parse.ParseFile(fset, name, test.src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkg := ir.MakePackage(&ast.Package{Files: []*ast.File{f}}, name)
+		if err := ir.TypeCheck(pkg, fset); err != nil {
+			t.Fatal(err)
+		}
+		funcs := make(map[*ir.Function]string)
+		for _, name := range pkg.Scope().Names() {
+			if d, ok := pkg.Scope().Lookup(name).(*ir.Define); ok {
+				fn := d.Body.(*ir.Function)
+				funcs[fn] = name
+				for _, inst := range fn.Instances {
+					funcs[inst] = name
+				}
+			}
+		}
+		var calls []string
+		for _, tc := range ir.TailCalls(pkg) {
+			calls = append(calls, funcs[tc.Caller]+"->"+funcs[tc.Callee])
+		}
+		if fmt.Sprint(calls) != fmt.Sprint(test.calls) {
+			t.Fatalf("%s: expected tail calls %v but got %v", name, test.calls,
+				calls)
+		}
+	}
+}
+
 func TestUnary(t *testing.T) {
 	tests := []Test{
 		{src: "-24", pass: true},
 		{src: "+(- 3 5)", pass: true},
+		{src: "!true", pass: true},
+		{src: "(not (< 1 2))", pass: true},
+		{src: "(func (done:bool):bool !done)", pass: true},
+		{src: "(func (done:bool):int (if !done:int 1 0))", pass: true},
+		{src: "!1", pass: false},
+		{src: "(func (a:int):bool !a)", pass: false},
+		{src: "-true", pass: false},
+		{src: "(func (a:bool):bool +a)", pass: false},
+		{src: "~false", pass: false},
 	}
 	for i, test := range tests {
 		test_expression(t, fmt.Sprintf("unary%d", i), test)
 	}
 }
 
+func TestLet(t *testing.T) {
+	tests := []Test{
+		{src: "(let ((a 1)) a)", pass: true},
+		{src: "(let ((a 1) (b (+ a 1))) (* a b))", pass: true},
+		{src: "(let ((a true) (a (if a:int 1 0))) (+ a 1))", pass: true},
+		{src: "(func (n:u8):u8 (let ((a n) (b (+ a n))) (= a b) a))",
+			pass: true},
+		{src: "(func (n:u8):int (let ((a n)) a))", pass: false},
+		{src: "(func:bool (let ((a 1)) (== a 1)))", pass: true},
+		{src: "(func:int (let ((a 1)) (== a 1)))", pass: false},
+		{src: "(let ((a b) (b 1)) a)", pass: false},
+		{src: "(let ((a a)) a)", pass: false},
+		{src: "(let ((a 1)) (= a true) a)", pass: false},
+		{src: "(let ((f (func (x:int):int (* x 2)))) (f 2))", pass: true},
+	}
+	for i, test := range tests {
+		test_expression(t, fmt.Sprintf("let%d", i), test)
+	}
+}
+
 func TestVar(t *testing.T) {
 	tests := []Test{
 		{src: "(var (a:int):int a)", pass: true},
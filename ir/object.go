@@ -46,7 +46,12 @@ func (o object) Scope() *Scope {
 	}
 	return o.scope
 }
-func (o object) Type() Type { return o.typ }
+func (o object) Type() Type {
+	if o.typ == nil {
+		return Unknown
+	}
+	return o.typ
+}
 func (o object) String() string {
 	if o.id != 0 {
 		return fmt.Sprintf("%s%d", o.name, o.id)
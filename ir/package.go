@@ -11,18 +11,53 @@ import (
 	"fmt"
 
 	"github.com/rthornton128/calc/ast"
+	"github.com/rthornton128/calc/token"
 )
 
 type Package struct {
 	object
-	top *Scope
+	top        *Scope
+	types      *typeTable
+	typeErrors []typeError
+}
+
+// typeError is an error found while making a type, such as an unknown type
+// name. Making the package continues with the type unknown and the error is
+// reported by TypeCheck.
+type typeError struct {
+	pos token.Pos
+	msg string
 }
 
 func MakePackage(pkg *ast.Package, name string) *Package {
-	scope := NewScope(nil)
+	scope := NewScope(universe)
 	p := &Package{
 		object: object{name: name, pos: pkg.Pos(), scope: scope},
 		top:    scope,
+		types:  newTypeTable(),
+	}
+	// types are declared first so that they may be used anywhere in the
+	// package, including by the fields of other types
+	var types []*TypeName
+	for _, f := range pkg.Files {
+		for _, d := range f.Defs {
+			switch body := d.Body.(type) {
+			case *ast.StructType:
+				tn := makeTypeName(p, d)
+				p.InsertTop(tn)
+				types = append(types, tn)
+			case *ast.UnionType:
+				tn := makeTypeName(p, d)
+				p.InsertTop(tn)
+				types = append(types, tn)
+				for i, v := range body.Variants {
+					p.InsertTop(makeConstructor(p, v, tn.Type(), i))
+				}
+			}
+		}
+	}
+	for _, tn := range types {
+		tn.resolve()
 	}
 	for _, f := range pkg.Files {
 		MakeFile(p, f)
@@ -53,17 +88,34 @@ func (p *Package) Lookup(name string) Object {
 	return p.scope.Lookup(name)
 }
 
+func (p *Package) typeError(pos token.Pos, format string, args ...interface{}) {
+	p.typeErrors = append(p.typeErrors,
+		typeError{pos: pos, msg: fmt.Sprintf(format, args...)})
+}
+
 func (p *Package) newScope() *Scope {
 	p.scope = NewScope(p.scope)
 	return p.scope
 }
 
+// CompositeTypes returns every function, array, list and named type of the
+// package in order of creation. The types a function, array or list type
+// are made of are always created before it but a named type may refer to
+// any type.
+func (p *Package) CompositeTypes() []Type {
+	return append([]Type(nil), p.types.composites...)
+}
+
 func (p *Package) String() string {
 	return fmt.Sprintf("package %s {%s}", p.name, p.scope)
 }
 
 func MakeFile(pkg *Package, f *ast.File) {
 	for _, d := range f.Defs {
-		pkg.InsertTop(MakeDefine(pkg, d))
+		switch d.Body.(type) {
+		case *ast.StructType, *ast.UnionType:
+		default:
+			pkg.InsertTop(MakeDefine(pkg, d))
+		}
 	}
 }
@@ -35,6 +35,16 @@ func (s *Scope) Lookup(name string) Object {
 	return s.parent.Lookup(name)
 }
 
+// Within returns true if s is the scope outer or is nested inside of it
+func (s *Scope) Within(outer *Scope) bool {
+	for ; s != nil; s = s.parent {
+		if s == outer {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *Scope) Names() []string {
 	names := make([]string, 0)
 	for k := range s.m {
@@ -0,0 +1,110 @@
+// Copyright (c) 2015, Rob Thornton
+// All rights reserved.
+// This source code is governed by a Simplied BSD-License. Please see the
+// LICENSE included in this distribution for a copy of the full license
+// or, if one is not included, you may also find a copy at
+// http://opensource.org/licenses/BSD-2-Clause
+
+package ir
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rthornton128/calc/ast"
+)
+
+// TypeName is the name of a type, either predeclared, such as int, or
+// declared by a define, such as (define Point (struct (x:int y:int)))
+type TypeName struct {
+	object
+	decl ast.Expr
+}
+
+func makeTypeName(pkg *Package, d *ast.DefineStmt) *TypeName {
+	return &TypeName{
+		object: object{
+			kind: ast.TypeDecl,
+			name: d.Name.Name,
+			pkg:  pkg,
+			pos:  d.Pos(),
+			typ:  pkg.types.namedType(d.Name.Name),
+		},
+		decl: d.Body,
+	}
+}
+
+// resolve sets the underlying struct or union type of the type declared by
+// tn. It is called once every type in the package has been declared.
+func (tn *TypeName) resolve() {
+	named := tn.typ.(*Named)
+	switch d := tn.decl.(type) {
+	case *ast.StructType:
+		named.underlying = &Struct{Fields: makeFields(tn.pkg, d.Fields)}
+	case *ast.UnionType:
+		u := &Union{Variants: make([]Variant, len(d.Variants))}
+		for i, v := range d.Variants {
+			u.Variants[i] = Variant{
+				Name:   v.Name.Name,
+				Fields: makeFields(tn.pkg, v.Fields),
+			}
+		}
+		named.underlying = u
+	}
+}
+
+func makeFields(pkg *Package, params []*ast.Param) []Field {
+	fields := make([]Field, len(params))
+	for i, f := range params {
+		fields[i] = Field{Name: f.Name.Name, Type: makeType(pkg, f.Type)}
+	}
+	return fields
+}
+
+func (tn *TypeName) String() string {
+	return fmt.Sprintf("type %s %s", tn.Name(), tn.typ.Underlying())
+}
+
+// StructLit constructs a value of a struct type. It uses call form with the
+// type name in place of the function name and an argument for each field,
+// in order, such as (Point 1 2).
+type StructLit struct {
+	object
+	Args []Object
+}
+
+func makeStructLit(pkg *Package, c *ast.CallExpr, t Type) *StructLit {
+	return &StructLit{
+		object: object{name: t.String(), pkg: pkg, pos: c.Pos(),
+			scope: pkg.scope, typ: t},
+		Args: MakeExprList(pkg, c.Args),
+	}
+}
+
+func (s *StructLit) String() string {
+	var out []string
+	for _, a := range s.Args {
+		out = append(out, a.String())
+	}
+	return fmt.Sprintf("%s{%s}", s.typ, strings.Join(out, " "))
+}
+
+// Selector selects the field Sel of the struct X, such as p.x
+type Selector struct {
+	object
+	X   Object
+	Sel string
+}
+
+func makeSelector(pkg *Package, s *ast.SelectorExpr) *Selector {
+	return &Selector{
+		object: object{name: s.Sel.Name, pkg: pkg, pos: s.Sel.Pos(),
+			scope: pkg.scope},
+		X:   MakeExpr(pkg, s.X),
+		Sel: s.Sel.Name,
+	}
+}
+
+func (s *Selector) String() string {
+	return fmt.Sprintf("%s.%s", s.X, s.Sel)
+}
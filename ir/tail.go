@@ -0,0 +1,92 @@
+// Copyright (c) 2015, Rob Thornton
+// All rights reserved.
+// This source code is governed by a Simplied BSD-License. Please see the
+// LICENSE included in this distribution for a copy of the full license
+// or, if one is not included, you may also find a copy at
+// http://opensource.org/licenses/BSD-2-Clause
+
+package ir
+
+import "sort"
+
+// TailCall is a call in tail position of Caller, whose result is then the
+// result of the call, to Callee. Both are functions bound by a define or
+// instances of a generic one and Callee may be Caller itself.
+type TailCall struct {
+	Call   *Call
+	Caller *Function
+	Callee *Function
+}
+
+// TailCalls returns the tail calls made by the functions bound by the
+// defines of pkg, in order of their position. An expression is in tail
+// position if it is the last expression of the body of a function or, in
+// turn, of a var or let in tail position, or a branch of an if, cond,
+// switch or match in tail position.
+func TailCalls(pkg *Package) []TailCall {
+	var calls []TailCall
+	for _, o := range pkg.scope.m {
+		d, ok := o.(*Define)
+		if !ok {
+			continue
+		}
+		f, ok := d.Body.(*Function)
+		if !ok {
+			continue
+		}
+		funcs := []*Function{f}
+		if f.IsGeneric() {
+			funcs = f.Instances
+		}
+		for _, fn := range funcs {
+			calls = tailCalls(calls, fn, fn.Body[len(fn.Body)-1])
+		}
+	}
+	sort.Slice(calls, func(i, j int) bool {
+		return calls[i].Call.Pos() < calls[j].Call.Pos()
+	})
+	return calls
+}
+
+// tailCalls appends the tail calls of f found in o, which is in tail
+// position, to calls
+func tailCalls(calls []TailCall, f *Function, o Object) []TailCall {
+	switch t := o.(type) {
+	case *Call:
+		if callee := calledFunc(t); callee != nil {
+			calls = append(calls, TailCall{Call: t, Caller: f, Callee: callee})
+		}
+	case *Cond:
+		for _, c := range t.Clauses {
+			calls = tailCalls(calls, f, c.Body)
+		}
+		if t.Else != nil {
+			calls = tailCalls(calls, f, t.Else)
+		}
+	case *If:
+		calls = tailCalls(calls, f, t.Then)
+		if t.Else != nil {
+			calls = tailCalls(calls, f, t.Else)
+		}
+	case *Match:
+		for _, a := range t.Arms {
+			calls = tailCalls(calls, f, a.Body)
+		}
+	case *Switch:
+		for _, c := range t.Clauses {
+			calls = tailCalls(calls, f, c.Body)
+		}
+		if t.Else != nil {
+			calls = tailCalls(calls, f, t.Else)
+		}
+	case *Variable:
+		calls = tailCalls(calls, f, t.Body[len(t.Body)-1])
+	}
+	return calls
+}
+
+// calledFunc returns the function called by c if it is bound by a define or
+// is an instance of a generic one, otherwise nil
+func calledFunc(c *Call) *Function {
+	return referredFunc(c.Func)
+}
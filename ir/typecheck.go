@@ -9,6 +9,8 @@ package ir
 
 import (
 	"fmt"
+	"math/big"
+	"strings"
 
 	"github.com/rthornton128/calc/ast"
 	"github.com/rthornton128/calc/token"
@@ -16,26 +18,82 @@ import (
 
 type typeChecker struct {
 	token.ErrorList
-	fset *token.FileSet
+	fset    *token.FileSet
+	types   *typeTable // of the package being checked
+	checked map[*Define]bool
+	pending map[Object]bool
+	funcs   []*Function // enclosing functions, innermost last
+	loops   []*For      // enclosing loops of the current function
+
+	// breaks holds the breaks with a value from each loop whose type is
+	// being inferred, which are converted once its type is known
+	breaks map[*For][]*Branch
+
+	// empty holds the lists of no elements, each of which must have been
+	// converted to a list type by the end of checking
+	empty []*Call
 }
 
 func TypeCheck(o Object, fs *token.FileSet) error {
-	t := &typeChecker{ErrorList: make(token.ErrorList, 0), fset: fs}
-	if pkg, ok := o.(*Package); ok {
+	t := &typeChecker{
+		ErrorList: make(token.ErrorList, 0),
+		fset:      fs,
+		checked:   make(map[*Define]bool),
+		pending:   make(map[Object]bool),
+		breaks:    make(map[*For][]*Branch),
+	}
+	pkg, ok := o.(*Package)
+	if !ok {
+		pkg = o.Package()
+	}
+	t.types = newTypeTable()
+	if pkg != nil {
+		t.types = pkg.types
+		// reported first so that the errors they cause are dropped
+		for _, e := range pkg.typeErrors {
+			t.error(e.pos, "%s", e.msg)
+		}
+	}
+	if ok {
 		for _, decl := range pkg.Scope().m {
 			t.check(decl)
 		}
+		t.checkMain(pkg)
 	} else {
 		t.check(o)
 	}
+	for _, c := range t.empty {
+		if c.Type() == UntypedList {
+			t.error(c.Pos(), "cannot infer the element type of an empty list")
+		}
+	}
 	if t.ErrorList.Count() != 0 {
 		return t.ErrorList
 	}
 	return nil
 }
 
+// checkMain checks that the result of main, if pkg declares it, is an
+// integer, float or bool, since it is printed when the program exits
+func (tc *typeChecker) checkMain(pkg *Package) {
+	d, ok := pkg.scope.m["main"].(*Define)
+	if !ok {
+		return
+	}
+	sig, ok := d.Type().(*Signature)
+	if !ok {
+		return
+	}
+	if t := sig.Result; t != Unknown && !IsInteger(t) && t != Float && t != Bool {
+		tc.error(d.Pos(), "main must return an integer, float or bool but "+
+			"returns '%s'", t)
+	}
+}
+
 func (tc *typeChecker) check(o Object) {
 	switch t := o.(type) {
+	case *ArrayLit:
+		tc.checkArrayLit(t)
 	case *Assignment:
 		o := t.Scope().Lookup(t.Lhs)
 		if o == nil {
@@ -47,69 +105,176 @@ func (tc *typeChecker) check(o Object) {
 				o.Name(), o.Kind())
 			return
 		}
+		tc.capture(o)
+		typ := o.Type()
+		for _, f := range t.Fields {
+			s, ok := typ.Underlying().(*Struct)
+			if !ok || s.Field(f) < 0 {
+				tc.error(t.Pos(), "type '%s' has no field '%s'", typ, f)
+				return
+			}
+			typ = s.Fields[s.Field(f)].Type
+		}
+		if t.Index != nil {
+			elem := elemType(typ)
+			if elem == nil {
+				tc.error(t.Pos(), "cannot index '%s' of type '%s'", t.Lhs, typ)
+				return
+			}
+			t.Index = tc.checkIndex(t.Index, typ)
+			typ = elem
+		}
 		tc.check(t.Rhs)
-		if o.Type() != t.Rhs.Type() {
+		t.Rhs = tc.convert(t.Rhs, typ)
+		if typ != t.Rhs.Type() {
 			tc.error(t.Pos(), "variable '%s' is of type '%s' but assignment of "+
-				"type '%s'", t.Name(), t.Type(), t.Rhs.Type())
+				"type '%s'", t.Lhs, typ, t.Rhs.Type())
 			return
 		}
-		t.object.typ = o.Type()
-	case *Binary:
-		tc.check(t.Lhs)
-		tc.check(t.Rhs)
-		typ := Int
-		if (t.Op == token.EQL || t.Op == token.NEQ) && t.Lhs.Type() == Bool {
-			typ = Bool
-		}
-		if t.Lhs.Type() != typ {
-			tc.error(t.Pos(), "binary expected type '%s' but lhs is type '%s'",
-				typ, t.Lhs.Type())
+		t.object.typ = typ
+	case *Branch:
+		if len(tc.loops) == 0 {
+			tc.error(t.Pos(), "%s is not in a loop", t.Name())
 			return
 		}
-		if t.Rhs.Type() != typ {
-			tc.error(t.Pos(), "binary expected type '%s' but rhs is type '%s'",
-				typ, t.Rhs.Type())
-			return
+		t.Loop = tc.loops[len(tc.loops)-1]
+		if t.Value != nil && t.Loop.typ == nil {
+			tc.check(t.Value)
+			tc.breaks[t.Loop] = append(tc.breaks[t.Loop], t)
+		} else if t.Value != nil {
+			tc.check(t.Value)
+			t.Value = tc.convert(t.Value, t.Loop.Type())
+			if t.Value.Type() != t.Loop.Type() {
+				tc.error(t.Value.Pos(), "for expects type '%s' but break value "+
+					"is type '%s'", t.Loop.Type(), t.Value.Type())
+			}
 		}
+	case *Binary:
+		tc.checkBinary(t)
 	case *Call:
-		o := t.Scope().Lookup(t.Name())
-		if o == nil {
+		if v, ok := t.Func.(*Var); ok && t.Scope().Lookup(v.Name()) == nil {
 			tc.error(t.Pos(), "calling undeclared function '%s'", t.Name())
 			return
 		}
-		if o.Kind() != ast.FuncDecl {
-			tc.error(t.Pos(), "call expects function got '%s'", o.Kind())
+		if v, ok := t.Func.(*Var); ok {
+			switch d := t.Scope().Lookup(v.Name()).(type) {
+			case *Builtin:
+				tc.checkBuiltin(t, d)
+				return
+			case *Define:
+				if f, ok := d.Body.(*Function); ok && f.IsGeneric() {
+					tc.checkGenericCall(t, d, f)
+					return
+				}
+			}
+		}
+		tc.check(t.Func)
+		if t.IsIndex() {
+			if len(t.Args) != 1 {
+				tc.error(t.Pos(), "index of '%s' expects 1 argument but received "+
+					"%d", t.Name(), len(t.Args))
+				return
+			}
+			t.Args[0] = tc.checkIndex(t.Args[0], t.Func.Type())
+			t.object.typ = elemType(t.Func.Type())
+			return
+		}
+		if t.Func.Type() == Unknown {
+			return
+		}
+		sig, ok := t.Func.Type().(*Signature)
+		if !ok {
+			tc.error(t.Pos(), "call expects function but '%s' is of type '%s'",
+				t.Name(), t.Func.Type())
 			return
 		}
-		f := o.(*Define).Body.(*Function)
 
-		if len(t.Args) != len(f.Params) {
+		if len(t.Args) != len(sig.Params) {
 			tc.error(t.Pos(), "function '%s' expects '%d' arguments but received %d",
-				t.Name(), len(f.Params), len(t.Args))
+				t.Name(), len(sig.Params), len(t.Args))
 			return
 		}
 
-		for i, a := range t.Args {
+		for _, a := range t.Args {
 			tc.check(a)
-			p := f.Scope().Lookup(f.Params[i].Name())
-			if a.Type() != p.Type() {
-				tc.error(t.Pos(), "parameter %d of function '%s' expects type '%s' "+
-					"but argument %d is of type '%s'", i, t.Name(), p.Type(), i, a.Type())
-			}
 		}
-		t.object.typ = f.Type()
+		tc.checkArgs(t, sig)
+	case *Conversion:
+		if len(t.Args) != 1 {
+			tc.error(t.Pos(), "conversion to '%s' expects 1 argument but "+
+				"received %d", t.Type(), len(t.Args))
+			return
+		}
+		tc.check(t.Args[0])
+		if !IsNumeric(t.Args[0].Type()) || !IsNumeric(t.Type()) {
+			tc.error(t.Pos(), "cannot convert type '%s' to '%s'",
+				t.Args[0].Type(), t.Type())
+			return
+		}
+		t.Args[0] = tc.convert(t.Args[0], t.Type())
+	case *Cond:
+		tc.checkCond(t)
 	case *Define:
+		if tc.checked[t] {
+			return
+		}
+		tc.checked[t] = true
+
+		// a generic function is only checked once instantiated
+		if f, ok := t.Body.(*Function); ok && f.IsGeneric() {
+			return
+		}
+
+		// defines are not nested in the function which first refers to them
+		funcs, loops := tc.funcs, tc.loops
+		tc.funcs, tc.loops = nil, nil
+		tc.pending[t] = true
 		tc.check(t.Body)
+		delete(tc.pending, t)
+		tc.funcs, tc.loops = funcs, loops
+
+		typ := t.Type()
+		if typ == Unknown || typ == UntypedInt {
+			typ = t.Body.Type()
+		}
+		if typ == UntypedInt {
+			typ = Int
+		}
+		t.Body = tc.convert(t.Body, typ)
+		if typ != t.Body.Type() {
+			tc.error(t.Pos(), "define '%s' declared as type '%s' but is of "+
+				"type '%s'", t.Name(), typ, t.Body.Type())
+		}
+		t.object.typ = typ
 	case *For:
+		tc.loops = append(tc.loops, t)
+		defer func() { tc.loops = tc.loops[:len(tc.loops)-1] }()
 		tc.check(t.Cond)
 		if t.Cond.Type() != Bool {
 			tc.error(t.Pos(), "conditional must be type 'bool', got '%s'",
 				t.Cond.Type())
 			return
 		}
-		tc.checkBody(t, t.Body)
+		if t.typ != nil {
+			tc.checkBody(t, t.Type(), t.Body)
+			return
+		}
+		tc.checkFor(t)
 	case *Function:
-		tc.checkBody(t, t.Body)
+		if t.IsGeneric() {
+			tc.error(t.Pos(), "generic functions may only be declared by define")
+			return
+		}
+		// a loop may not be left from within a function nested in it
+		loops := tc.loops
+		tc.funcs, tc.loops = append(tc.funcs, t), nil
+		if t.typ == nil {
+			t.object.typ = signatureOf(tc.types, t.Params,
+				tc.checkBody(t, nil, t.Body))
+		} else {
+			tc.checkBody(t, t.Result(), t.Body)
+		}
+		tc.funcs, tc.loops = tc.funcs[:len(tc.funcs)-1], loops
 	case *If:
 		tc.check(t.Cond)
 		if t.Cond.Type() != Bool {
@@ -117,51 +282,771 @@ func (tc *typeChecker) check(o Object) {
 				t.Cond.Type())
 			return
 		}
-		tc.check(t.Then)
-		if t.Type() != t.Then.Type() {
-			tc.error(t.Pos(), "if expects type '%s' but then clause is type '%s'",
-				t.Type(), t.Then.Type())
-
+		branches, names := []*Object{&t.Then}, []string{"then clause"}
+		if t.Else != nil {
+			branches = append(branches, &t.Else)
+			names = append(names, "else clause")
+		}
+		for _, b := range branches {
+			tc.check(*b)
+		}
+		t.object.typ = tc.checkBranches("if", t.typ, branches, names)
+	case *Instance:
+		tc.checkInstance(t)
+	case *Match:
+		tc.checkMatch(t)
+	case *Selector:
+		tc.check(t.X)
+		s, ok := t.X.Type().Underlying().(*Struct)
+		if !ok || s.Field(t.Sel) < 0 {
+			tc.error(t.Pos(), "type '%s' has no field '%s'", t.X.Type(), t.Sel)
 			return
 		}
-		if t.Else != nil {
-			tc.check(t.Else)
-			if t.Type() != t.Else.Type() {
-				tc.error(t.Pos(), "if expects type '%s' but else clause is type '%s'",
-					t.Type(), t.Else.Type())
-
-				return
+		t.object.typ = s.Fields[s.Field(t.Sel)].Type
+	case *StructLit:
+		tc.checkFields(t, "struct", t.Args,
+			t.Type().Underlying().(*Struct).Fields)
+	case *Switch:
+		tc.checkSwitch(t)
+	case *TypeName:
+		if contains(t.Type(), t.Type(), make(map[Type]bool)) {
+			tc.error(t.Pos(), "invalid recursive type '%s'", t.Name())
+		}
+		// the zero value of a union is its first variant, which would never
+		// end if the variant held the union itself
+		if u, ok := t.Type().Underlying().(*Union); ok {
+			for _, f := range u.Variants[0].Fields {
+				if f.Type == t.Type() {
+					tc.error(t.Pos(), "first variant of union '%s' may not have a "+
+						"field of type '%s'", t.Name(), t.Name())
+					break
+				}
 			}
 		}
+	case *Unary:
+		tc.checkUnary(t)
+	case *UnionLit:
+		tc.checkFields(t, "variant",
+			t.Args, t.Type().Underlying().(*Union).Variants[t.Index].Fields)
 	case *Var:
 		o := t.Scope().Lookup(t.Name())
 		if o == nil {
 			tc.error(t.Pos(), "undeclared variable '%s'", t.Name())
 			return
 		}
-		if o.Kind() == ast.FuncDecl {
-			tc.error(t.Pos(), "function '%s' used as variable; must be used "+
-				"in call form (surrounded in parentheses)", t.Name())
+		switch d := o.(type) {
+		case *Builtin:
+			tc.error(t.Pos(), "builtin '%s' may only be called", t.Name())
+			return
+		case *Constructor:
+			tc.error(t.Pos(), "variant '%s' may only be called", t.Name())
+			return
+		case *TypeName:
+			tc.error(t.Pos(), "type '%s' is not an expression", t.Name())
 			return
+		case *Define:
+			if f, ok := d.Body.(*Function); ok && f.IsGeneric() {
+				tc.error(t.Pos(), "generic function '%s' may only be called or "+
+					"instantiated", t.Name())
+				return
+			}
+			tc.check(d)
+			if tc.pending[d] && d.Type() == Unknown {
+				tc.error(t.Pos(), "type of '%s' must be declared since it refers "+
+					"to itself", t.Name())
+				return
+			}
 		}
+		tc.capture(o)
 		t.object.typ = o.Type()
 	case *Variable:
-		tc.checkBody(t, t.Body)
+		for i, init := range t.Inits {
+			tc.check(init)
+			t.Inits[i] = tc.convert(init, Int)
+			t.Params[i].object.typ = t.Inits[i].Type()
+		}
+		t.object.typ = tc.checkBody(t, t.object.typ, t.Body)
+	}
+}
+
+// checkArrayLit checks that the elements of array literal a are of the same
+// type. If every element is an untyped constant the literal is left
+// untyped until converted.
+func (tc *typeChecker) checkArrayLit(a *ArrayLit) {
+	if len(a.Elems) == 0 {
+		tc.error(a.Pos(), "array literal must have at least one element")
+		return
+	}
+	untyped := true
+	for _, e := range a.Elems {
+		tc.check(e)
+		if e.Type() != UntypedInt {
+			untyped = false
+		}
+	}
+	elem := tc.checkElems(a.Elems, "array literal")
+	for _, e := range a.Elems {
+		if _, ok := e.(*Constant); !ok {
+			untyped = false
+		}
+	}
+	a.untyped = untyped
+	a.object.typ = tc.types.arrayType(elem, int64(len(a.Elems)))
+}
+
+// checkArgs converts the arguments of call c, which have been checked, to
+// the types of the parameters of sig
+func (tc *typeChecker) checkArgs(c *Call, sig *Signature) {
+	for i, a := range c.Args {
+		a = tc.convert(a, sig.Params[i])
+		c.Args[i] = a
+		if a.Type() != sig.Params[i] {
+			tc.error(c.Pos(), "parameter %d of function '%s' expects type '%s' "+
+				"but argument %d is of type '%s'", i, c.Name(), sig.Params[i], i,
+				a.Type())
+		}
+	}
+	c.object.typ = sig.Result
+}
+
+// checkElems checks that the elements of an array literal or list, which
+// have already been checked, are of the same type and returns it. Untyped
+// constants and empty lists take the type of the first typed element, if
+// there is one, or untyped constants the default integer type.
+func (tc *typeChecker) checkElems(elems []Object, what string) Type {
+	var elem Type = UntypedInt
+	for _, e := range elems {
+		if elem == UntypedInt && e.Type() != UntypedList {
+			elem = e.Type()
+		}
+	}
+	if elem == UntypedInt {
+		elem = Int
+	}
+	for i, e := range elems {
+		e = tc.convert(e, elem)
+		elems[i] = e
+		if e.Type() != elem {
+			tc.error(e.Pos(), "element %d of %s is of type '%s' but expected "+
+				"'%s'", i, what, e.Type(), elem)
+		}
+	}
+	return elem
+}
+
+// checkFields checks the arguments constructing o, a struct or variant of a
+// union, against its fields
+func (tc *typeChecker) checkFields(o Object, what string, args []Object,
+	fields []Field) {
+	if len(args) != len(fields) {
+		tc.error(o.Pos(), "%s '%s' expects %d fields but received %d", what,
+			o.Name(), len(fields), len(args))
+		return
+	}
+	for i, a := range args {
+		tc.check(a)
+		a = tc.convert(a, fields[i].Type)
+		args[i] = a
+		if a.Type() != fields[i].Type {
+			tc.error(a.Pos(), "field '%s' of %s '%s' is of type '%s' but "+
+				"received '%s'", fields[i].Name, what, o.Name(), fields[i].Type,
+				a.Type())
+		}
+	}
+}
+
+// checkMatch checks that each arm of match m names a distinct variant of
+// the union matched, binding no more than its fields, and that every
+// variant is matched unless there is a default arm
+func (tc *typeChecker) checkMatch(m *Match) {
+	tc.check(m.X)
+	u, ok := m.X.Type().Underlying().(*Union)
+	if !ok {
+		tc.error(m.X.Pos(), "match expects a union but received type '%s'",
+			m.X.Type())
+		return
+	}
+
+	matched := make([]bool, len(u.Variants))
+	hasDefault := false
+	var branches []*Object
+	var names []string
+	for _, a := range m.Arms {
+		switch {
+		case a.IsDefault():
+			if hasDefault {
+				tc.error(a.Pos(), "duplicate default arm in match")
+			}
+			hasDefault = true
+		case a.Ctor == nil:
+			tc.error(a.Pos(), "undeclared variant '%s'", a.Name)
+			continue
+		case a.Ctor.Type() != m.X.Type():
+			tc.error(a.Pos(), "variant '%s' is of type '%s' but match is on "+
+				"type '%s'", a.Name, a.Ctor.Type(), m.X.Type())
+			continue
+		case matched[a.Ctor.Index]:
+			tc.error(a.Pos(), "duplicate arm for variant '%s' in match", a.Name)
+		case len(a.Params) != len(a.Ctor.Variant().Fields):
+			tc.error(a.Pos(), "variant '%s' has %d fields but %d are bound",
+				a.Name, len(a.Ctor.Variant().Fields), len(a.Params))
+		}
+		if !a.IsDefault() {
+			matched[a.Ctor.Index] = true
+		}
+
+		tc.check(a.Body)
+		branches = append(branches, &a.Body)
+		names = append(names, "arm '"+a.Name+"'")
+	}
+	m.object.typ = tc.checkBranches("match", m.typ, branches, names)
+
+	if hasDefault {
+		return
+	}
+	var missing []string
+	for i, v := range u.Variants {
+		if !matched[i] {
+			missing = append(missing, "'"+v.Name+"'")
+		}
+	}
+	if len(missing) != 0 {
+		tc.error(m.Pos(), "match on '%s' is not exhaustive, missing %s",
+			m.X.Type(), strings.Join(missing, ", "))
+	}
+}
+
+// checkCond checks that each test of cond c is a boolean and that no test
+// without side effects is repeated, since the clause of the repeated test
+// could never be taken
+func (tc *typeChecker) checkCond(c *Cond) {
+	seen := make(map[string]token.Pos)
+	for _, cl := range c.Clauses {
+		tc.check(cl.Test)
+		if cl.Test.Type() != Bool {
+			tc.error(cl.Test.Pos(), "conditional must be type 'bool', got '%s'",
+				cl.Test.Type())
+		} else if prev, ok := seen[cl.Test.String()]; ok {
+			tc.error(cl.Test.Pos(), "duplicate condition %s in cond; previous "+
+				"condition at %s", cl.Test, tc.fset.Position(prev))
+		} else if pure(cl.Test) {
+			seen[cl.Test.String()] = cl.Test.Pos()
+		}
+	}
+	c.object.typ = tc.checkClauses(c.typ, "cond", c.Clauses, &c.Else)
+}
+
+// pure returns true if evaluating o has no side effects, so evaluating it
+// again gives the same result
+func pure(o Object) bool {
+	switch t := o.(type) {
+	case *Binary:
+		return pure(t.Lhs) && pure(t.Rhs)
+	case *Constant, *Var:
+		return true
+	case *Conversion:
+		return len(t.Args) == 1 && pure(t.Args[0])
+	case *Selector:
+		return pure(t.X)
+	case *Unary:
+		return pure(t.Rhs)
+	}
+	return false
+}
+
+// checkSwitch checks that the tag of switch s is an integer and that its
+// case labels are distinct integer constants of the same type
+func (tc *typeChecker) checkSwitch(s *Switch) {
+	tc.check(s.Tag)
+	if !IsInteger(s.Tag.Type()) {
+		tc.error(s.Tag.Pos(), "switch expects an integer but received type "+
+			"'%s'", s.Tag.Type())
+		return
+	}
+	s.Tag = tc.convert(s.Tag, Int)
+
+	seen := make(map[string]token.Pos)
+	for _, cl := range s.Clauses {
+		tc.check(cl.Test)
+		cl.Test = tc.convert(cl.Test, s.Tag.Type())
+		k, ok := cl.Test.(*Constant)
+		if !ok || k.Type() != s.Tag.Type() {
+			tc.error(cl.Test.Pos(), "case label must be an integer constant of "+
+				"type '%s'", s.Tag.Type())
+		} else if prev, ok := seen[k.Value().String()]; ok {
+			tc.error(cl.Test.Pos(), "duplicate case %s in switch; previous case "+
+				"at %s", k.Value(), tc.fset.Position(prev))
+		} else {
+			seen[k.Value().String()] = k.Pos()
+		}
+	}
+	s.object.typ = tc.checkClauses(s.typ, "switch", s.Clauses, &s.Else)
+}
+
+// checkClauses checks the bodies of clauses, and els if it is not nil, of a
+// cond or switch, which is of type t, and returns the type of the cond or
+// switch
+func (tc *typeChecker) checkClauses(t Type, what string, clauses []*Clause,
+	els *Object) Type {
+	var branches []*Object
+	var names []string
+	for i, cl := range clauses {
+		branches = append(branches, &cl.Body)
+		names = append(names, fmt.Sprintf("clause %d", i+1))
+	}
+	if *els != nil {
+		branches = append(branches, els)
+		names = append(names, "else clause")
+	}
+	for _, b := range branches {
+		tc.check(*b)
+	}
+	return tc.checkBranches(what, t, branches, names)
+}
+
+// checkFor checks loop f, which does not declare its type. The type is
+// inferred from the last expression of its body and the value of any break
+// from it.
+func (tc *typeChecker) checkFor(f *For) {
+	for _, e := range f.Body {
+		tc.check(e)
+	}
+	for i, e := range f.Body[:len(f.Body)-1] {
+		f.Body[i] = tc.convert(e, Int)
+	}
+
+	branches := []*Object{&f.Body[len(f.Body)-1]}
+	names := []string{"last expression"}
+	for _, b := range tc.breaks[f] {
+		branches = append(branches, &b.Value)
+		names = append(names, "break value")
+	}
+	delete(tc.breaks, f)
+	f.object.typ = tc.checkBranches("for", nil, branches, names)
+}
+
+// checkBranches checks that each of branches, described by names, is of type
+// t and converts it to t. The branches are those of an if, such as its then
+// and else clauses, or a similar expression described by what. If t is nil
+// it is inferred from the branches. The type of the branches is returned.
+func (tc *typeChecker) checkBranches(what string, t Type, branches []*Object,
+	names []string) Type {
+	inferred, from := t == nil, -1
+	if inferred {
+		t, from = branchType(branches)
+	}
+	for i, b := range branches {
+		*b = tc.convert(*b, t)
+		switch typ := (*b).Type(); {
+		case typ == t:
+		// an unknown type has already been reported where it was declared
+		case !inferred && t != Unknown:
+			tc.error((*b).Pos(), "%s expects type '%s' but %s is type '%s'",
+				what, t, names[i], typ)
+		case inferred && typ != Unknown:
+			tc.error((*b).Pos(), "branches of %s disagree: %s is type '%s' but "+
+				"%s is type '%s'", what, names[from], t, names[i], typ)
+		}
+	}
+	return t
+}
+
+// branchType returns the type of the first of branches which has one, other
+// than untyped constants, empty lists and breaks, and its index. If no
+// branch has a type the default integer type and -1 are returned.
+func branchType(branches []*Object) (Type, int) {
+	for i, b := range branches {
+		if t := (*b).Type(); t != UntypedInt && t != UntypedList &&
+			t != Unknown {
+			return t, i
+		}
+	}
+	return Int, -1
+}
+
+// elemType returns the type of the elements of array or list type t, or nil
+// if t is neither
+func elemType(t Type) Type {
+	switch t := t.(type) {
+	case *Array:
+		return t.Elem
+	case *List:
+		return t.Elem
+	}
+	return nil
+}
+
+// contains returns true if a value of type t contains a value of type x,
+// other than by reference through a list or function or, for a union, a
+// field of the union's own type, which is kept on the heap. A struct which
+// contains itself would be infinitely large.
+func contains(t, x Type, seen map[Type]bool) bool {
+	if seen[t] {
+		return false
+	}
+	seen[t] = true
+	self := t
+	switch t := t.Underlying().(type) {
+	case *Array:
+		return t.Elem == x || contains(t.Elem, x, seen)
+	case *Struct:
+		for _, f := range t.Fields {
+			if f.Type == x || contains(f.Type, x, seen) {
+				return true
+			}
+		}
+	case *Union:
+		for _, v := range t.Variants {
+			for _, f := range v.Fields {
+				if f.Type != self && (f.Type == x || contains(f.Type, x, seen)) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// checkIndex checks that index, of an array or list of type t, is an
+// integer and, if it is constant, that it is in range. The length of a list
+// is only known at runtime.
+func (tc *typeChecker) checkIndex(index Object, t Type) Object {
+	tc.check(index)
+	index = tc.convert(index, Int)
+	if !IsInteger(index.Type()) {
+		tc.error(index.Pos(), "array index must be an integer but is of type "+
+			"'%s'", index.Type())
+		return index
+	}
+	if c, ok := index.(*Constant); ok {
+		a, isArray := t.(*Array)
+		if v := c.value.(intValue); v.Sign() < 0 ||
+			(isArray && (!v.IsInt64() || v.Int64() >= a.Len)) {
+			tc.error(index.Pos(), "index %s out of range for '%s'", v, t)
+		}
+	}
+	return index
+}
+
+func (tc *typeChecker) checkBinary(b *Binary) {
+	tc.check(b.Lhs)
+	tc.check(b.Rhs)
+	if isShift(b) {
+		tc.checkShift(b)
+		return
+	}
+
+	// an untyped operand takes the type of the other operand
+	switch lt, rt := b.Lhs.Type(), b.Rhs.Type(); {
+	case lt == UntypedInt && rt != UntypedInt:
+		b.Lhs = tc.convert(b.Lhs, rt)
+	case rt == UntypedInt && lt != UntypedInt:
+		b.Rhs = tc.convert(b.Rhs, lt)
+	case lt == UntypedInt && b.Type() == Bool:
+		b.Lhs = tc.convert(b.Lhs, Int)
+		b.Rhs = tc.convert(b.Rhs, Int)
+	}
+
+	typ := b.Lhs.Type()
+	switch b.Op {
+	case token.EQL, token.NEQ:
+		if typ != Bool && !IsNumeric(typ) {
+			tc.error(b.Pos(), "binary expected type 'bool' or a numeric type "+
+				"but lhs is type '%s'", typ)
+			return
+		}
+	case token.REM, token.BAND, token.BOR, token.BXOR:
+		if !IsInteger(typ) {
+			tc.error(b.Pos(), "binary expected an integer type but lhs is type "+
+				"'%s'", typ)
+			return
+		}
+	default:
+		if !IsNumeric(typ) {
+			tc.error(b.Pos(), "binary expected a numeric type but lhs is type "+
+				"'%s'", typ)
+			return
+		}
+	}
+	if b.Rhs.Type() != typ {
+		tc.error(b.Pos(), "binary expected type '%s' but rhs is type '%s'",
+			typ, b.Rhs.Type())
+		return
+	}
+	if msg := binaryError(b); msg != "" {
+		tc.error(b.Pos(), "%s", msg)
+		return
+	}
+	if b.Type() != Bool {
+		b.object.typ = typ
+	}
+}
+
+// checkShift checks shift b, whose result is of the type of the value
+// shifted while its count may be of any integer type. An untyped value
+// shifted by a typed count is left untyped until converted, as is a shift
+// of untyped constants. An untyped count of a typed value is an i64.
+func (tc *typeChecker) checkShift(b *Binary) {
+	lt, rt := b.Lhs.Type(), b.Rhs.Type()
+	if !IsInteger(lt) {
+		tc.error(b.Pos(), "binary expected an integer type but lhs is type "+
+			"'%s'", lt)
+		return
+	}
+	if !IsInteger(rt) {
+		tc.error(b.Pos(), "shift count must be an integer but is type '%s'", rt)
+		return
+	}
+	if msg := binaryError(b); msg != "" {
+		tc.error(b.Pos(), "%s", msg)
+		return
+	}
+	if lt != UntypedInt && rt == UntypedInt {
+		b.Rhs = tc.convert(b.Rhs, Int64)
+	}
+	b.object.typ = lt
+}
+
+// isShift returns true if b is a left or right shift
+func isShift(b *Binary) bool {
+	return b.Op == token.SHL || b.Op == token.SHR
+}
+
+func (tc *typeChecker) checkUnary(u *Unary) {
+	tc.check(u.Rhs)
+
+	typ := u.Rhs.Type()
+	switch u.Op {
+	case token.NOT:
+		if typ != Bool {
+			tc.error(u.Pos(), "unary '%s' expects type 'bool' but got type '%s'",
+				u.Op, typ)
+			return
+		}
+	case token.BNOT:
+		if !IsInteger(typ) {
+			tc.error(u.Pos(), "unary '%s' expects an integer type but got type "+
+				"'%s'", u.Op, typ)
+			return
+		}
+	default:
+		if !IsNumeric(typ) {
+			tc.error(u.Pos(), "unary '%s' expects a numeric type but got type "+
+				"'%s'", u.Op, typ)
+			return
+		}
 	}
+	u.object.typ = typ
 }
 
-func (tc *typeChecker) checkBody(o Object, body []Object) {
+// capture records o as captured by each enclosing function if o is a
+// parameter declared outside of that function
+func (tc *typeChecker) capture(o Object) {
+	p, ok := o.(*Param)
+	if !ok {
+		return
+	}
+	for i := len(tc.funcs) - 1; i >= 0; i-- {
+		f := tc.funcs[i]
+		if p.Scope().Within(f.Scope()) {
+			return
+		}
+		f.capture(p)
+	}
+}
+
+// checkBody checks the expressions in the body of o, the last of which must
+// be of type t, and returns t. If t is nil the type of the last expression
+// is returned instead.
+func (tc *typeChecker) checkBody(o Object, t Type, body []Object) Type {
 	for _, e := range body {
 		tc.check(e)
 	}
 
-	tail := body[len(body)-1]
-	if o.Type() != tail.Type() {
+	for i, e := range body[:len(body)-1] {
+		body[i] = tc.convert(e, Int)
+	}
+	if t == nil {
+		t = body[len(body)-1].Type()
+		if t == UntypedInt {
+			t = Int
+		}
+	}
+	tail := tc.convert(body[len(body)-1], t)
+	body[len(body)-1] = tail
+	// an unknown type has already been reported where it was declared
+	if t != tail.Type() && t != Unknown {
 		tc.error(o.Pos(), "last expression of %s is of type '%s' but expects "+
-			"type '%s'", o.Name(), tail.Type(), o.Type())
+			"type '%s'", o.Name(), tail.Type(), t)
 	}
+	return t
 }
 
+// convert gives the untyped integer expression o the numeric type t and
+// returns the result. Untyped expressions are constant and are evaluated
+// exactly, so the result is a Constant unless evaluation is impossible. An
+// error is reported if the value is not representable by t. A Branch is
+// simply given type t, as is a list of no elements if t is a list type.
+func (tc *typeChecker) convert(o Object, t Type) Object {
+	if b, ok := o.(*Branch); ok {
+		if t == UntypedInt {
+			t = Int
+		}
+		b.object.typ = t
+		return b
+	}
+	if c, ok := o.(*Call); ok && c.Type() == UntypedList {
+		if _, ok := t.(*List); ok {
+			c.object.typ = t
+		}
+		return c
+	}
+	if a, ok := o.(*ArrayLit); ok && a.untyped {
+		if at, ok := t.(*Array); ok && at.Len == int64(len(a.Elems)) &&
+			IsNumeric(at.Elem) {
+			for i, e := range a.Elems {
+				c := e.(*Constant)
+				a.Elems[i] = tc.convert(&Constant{
+					object: object{name: c.name, pos: c.pos, typ: UntypedInt},
+					value:  c.value,
+				}, at.Elem)
+			}
+			a.object.typ = t
+			return a
+		}
+	}
+	if o.Type() != UntypedInt || !IsNumeric(t) {
+		return o
+	}
+	if t == UntypedInt {
+		t = Int
+	}
+	if t == Float {
+		v, ok := untypedValue(o)
+		if !ok {
+			return tc.convert(o, Int)
+		}
+		f, _ := new(big.Float).SetInt(v).Float64()
+		return &Constant{
+			object: object{name: constantName(o, v), pos: o.Pos(), typ: Float},
+			value:  floatValue(f),
+		}
+	}
+	if v, ok := untypedValue(o); ok {
+		if !representable(v, t) {
+			tc.error(o.Pos(), "constant %s overflows '%s'", v, t)
+		}
+		return &Constant{
+			object: object{name: constantName(o, v), pos: o.Pos(), typ: t},
+			value:  intValue{v},
+		}
+	}
+	switch x := o.(type) {
+	case *Binary:
+		x.Lhs = tc.convert(x.Lhs, t)
+		switch {
+		case !isShift(x):
+			x.Rhs = tc.convert(x.Rhs, t)
+		case x.Rhs.Type() == UntypedInt:
+			x.Rhs = tc.convert(x.Rhs, Int64)
+		}
+		x.object.typ = t
+	case *Unary:
+		x.Rhs = tc.convert(x.Rhs, t)
+		x.object.typ = t
+	}
+	return o
+}
+
+// constantName returns the spelling of literal o or, if o is an expression,
+// the value v it evaluated to
+func constantName(o Object, v *big.Int) string {
+	if c, ok := o.(*Constant); ok {
+		return c.Name()
+	}
+	return v.String()
+}
+
+// maxUntypedShift limits the size of untyped constants produced by a shift.
+// Larger shifts are left to be evaluated with the type of the expression.
+const maxUntypedShift = 1024
+
+// constInt returns the value of o if it is an integer constant, an untyped
+// constant expression or a conversion of either to an integer type
+func constInt(o Object) (*big.Int, bool) {
+	switch x := o.(type) {
+	case *Constant:
+		if v, ok := x.value.(intValue); ok {
+			return v.Int, true
+		}
+		return nil, false
+	case *Conversion:
+		if len(x.Args) != 1 || !IsInteger(x.Type()) {
+			return nil, false
+		}
+		if v, ok := constInt(x.Args[0]); ok {
+			return wrap(v, x.Type()), true
+		}
+		return nil, false
+	}
+	return untypedValue(o)
+}
+
+// untypedValue returns the exact value of an untyped constant expression
+func untypedValue(o Object) (*big.Int, bool) {
+	switch x := o.(type) {
+	case *Binary:
+		l, ok := untypedValue(x.Lhs)
+		if !ok {
+			return nil, false
+		}
+		r, ok := untypedValue(x.Rhs)
+		if !ok {
+			return nil, false
+		}
+		switch x.Op {
+		case token.QUO, token.REM:
+			if r.Sign() == 0 {
+				return nil, false
+			}
+		case token.SHL, token.SHR:
+			if r.Sign() < 0 || r.Cmp(big.NewInt(maxUntypedShift)) > 0 {
+				return nil, false
+			}
+		}
+		v, _ := foldInt(x.Op, UntypedInt, l, r)
+		return v, true
+	case *Constant:
+		if v, ok := x.value.(intValue); ok {
+			return v.Int, true
+		}
+	case *Unary:
+		v, ok := untypedValue(x.Rhs)
+		if !ok {
+			return nil, false
+		}
+		switch x.Op {
+		case token.ADD:
+			return new(big.Int).Abs(v), true
+		case token.SUB:
+			return new(big.Int).Neg(v), true
+		case token.BNOT:
+			return new(big.Int).Not(v), true
+		}
+	}
+	return nil, false
+}
+
+// error reports an error at p. An error about an object of unknown type
+// follows from one already reported, such as an undeclared result type or
+// an unknown type name, so it is dropped if any error has been reported.
 func (t *typeChecker) error(p token.Pos, format string, args ...interface{}) {
+	if t.ErrorList.Count() > 0 {
+		for _, a := range args {
+			if a == Unknown {
+				return
+			}
+		}
+	}
 	t.Add(t.fset.Position(p), fmt.Sprintf(format, args...))
 }
@@ -7,29 +7,357 @@
 
 package ir
 
-type Type int
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/rthornton128/calc/ast"
+)
+
+// Type is the type of a value. Types are compared with ==: each basic type
+// is a single value, identical function, array and list types are only
+// created once and every named type is distinct, even from another with
+// the same underlying type.
+type Type interface {
+	// Underlying returns the type t is defined as, which is t itself for
+	// every type but Named
+	Underlying() Type
+	String() string
+}
+
+type basicInfo int
 
 const (
-	Unknown Type = iota
-	Bool
-	Int
+	isBoolean basicInfo = 1 << iota
+	isInteger
+	isUnsigned
+	isFloat
+	isUntyped
+
+	isNumeric = isInteger | isFloat
 )
 
-var typeStrings = []string{
-	Unknown: "unknown type",
-	Bool:    "bool",
-	Int:     "int",
+// Basic is a predeclared type, such as bool or i32
+type Basic struct {
+	name string
+	info basicInfo
+	size uint
+}
+
+var (
+	Unknown    = &Basic{name: "unknown type"}
+	Bool       = &Basic{name: "bool", info: isBoolean}
+	Float      = &Basic{name: "float", info: isFloat, size: 64}
+	Int8       = &Basic{name: "i8", info: isInteger, size: 8}
+	Int16      = &Basic{name: "i16", info: isInteger, size: 16}
+	Int32      = &Basic{name: "i32", info: isInteger, size: 32}
+	Int64      = &Basic{name: "i64", info: isInteger, size: 64}
+	Uint8      = &Basic{name: "u8", info: isInteger | isUnsigned, size: 8}
+	Uint16     = &Basic{name: "u16", info: isInteger | isUnsigned, size: 16}
+	Uint32     = &Basic{name: "u32", info: isInteger | isUnsigned, size: 32}
+	Uint64     = &Basic{name: "u64", info: isInteger | isUnsigned, size: 64}
+	UntypedInt = &Basic{name: "untyped int", info: isInteger | isUntyped,
+		size: 32}
+	UntypedList = &Basic{name: "untyped list", info: isUntyped}
+)
+
+// Int is the default integer type. The type name int is an alias for it.
+var Int = Int32
+
+// Signature is the type of a function, described by its parameter and
+// result types
+type Signature struct {
+	Params []Type
+	Result Type
+	id     int
+}
+
+// Array is the type of an array of Len elements of type Elem
+type Array struct {
+	Elem Type
+	Len  int64
+	id   int
+}
+
+// List is the type of a list whose elements are of type Elem
+type List struct {
+	Elem Type
+	id   int
+}
+
+// Struct is a type made of named fields. A struct is only declared as the
+// underlying type of a Named type.
+type Struct struct {
+	Fields []Field
+}
+
+// Field is a field of a struct type
+type Field struct {
+	Name string
+	Type Type
+}
+
+// Union is a type whose values are one of several variants, each holding
+// its own fields. Like a struct, it is only declared as the underlying type
+// of a Named type.
+type Union struct {
+	Variants []Variant
+}
+
+// Variant is a variant of a union type
+type Variant struct {
+	Name   string
+	Fields []Field
+}
+
+// Named is a type declared by a define, such as
+// (define Point (struct (x:int y:int)))
+type Named struct {
+	name       string
+	underlying Type
+	id         int
+}
+
+// TypeParam is a type parameter of a generic function, such as T in
+// (func [T] (x:T):T x). It only appears in the types of the generic function
+// itself since each of its instances replaces it with a type argument.
+type TypeParam struct {
+	name string
+}
+
+// typeTable holds the function, array, list and named types of a package
+// in order of creation. Identical function, array and list types are made
+// only once, so types may be compared with ==.
+type typeTable struct {
+	composites []Type
+	funcs      map[sigKey][]*Signature
+	arrays     map[arrayKey]*Array
+	lists      map[Type]*List
+}
+
+// sigKey groups the function types with the same result and number of
+// parameters
+type sigKey struct {
+	result Type
+	n      int
+}
+
+type arrayKey struct {
+	elem Type
+	n    int64
+}
+
+func newTypeTable() *typeTable {
+	return &typeTable{
+		funcs:  make(map[sigKey][]*Signature),
+		arrays: make(map[arrayKey]*Array),
+		lists:  make(map[Type]*List),
+	}
+}
+
+// funcType returns the function type with the given parameter and result
+// types
+func (tt *typeTable) funcType(params []Type, result Type) Type {
+	key := sigKey{result, len(params)}
+next:
+	for _, s := range tt.funcs[key] {
+		for j, p := range s.Params {
+			if p != params[j] {
+				continue next
+			}
+		}
+		return s
+	}
+	s := &Signature{Params: params, Result: result, id: len(tt.composites)}
+	tt.funcs[key] = append(tt.funcs[key], s)
+	tt.composites = append(tt.composites, s)
+	return s
+}
+
+// arrayType returns the type of an array of n elements of type elem
+func (tt *typeTable) arrayType(elem Type, n int64) Type {
+	key := arrayKey{elem, n}
+	if a, ok := tt.arrays[key]; ok {
+		return a
+	}
+	a := &Array{Elem: elem, Len: n, id: len(tt.composites)}
+	tt.arrays[key] = a
+	tt.composites = append(tt.composites, a)
+	return a
+}
+
+// listType returns the type of a list whose elements are of type elem
+func (tt *typeTable) listType(elem Type) Type {
+	if l, ok := tt.lists[elem]; ok {
+		return l
+	}
+	l := &List{Elem: elem, id: len(tt.composites)}
+	tt.lists[elem] = l
+	tt.composites = append(tt.composites, l)
+	return l
+}
+
+// namedType returns a new named type with the given name. Its underlying
+// type is set once any types it refers to have been declared, see
+// TypeName.resolve.
+func (tt *typeTable) namedType(name string) *Named {
+	n := &Named{name: name, underlying: Unknown, id: len(tt.composites)}
+	tt.composites = append(tt.composites, n)
+	return n
 }
 
-func typeFromString(name string) Type {
-	for i, s := range typeStrings {
-		if name == s {
-			return Type(i)
+// TypeID returns a number identifying the function, array, list or named
+// type t, which is unique among the types returned by CompositeTypes for
+// its package
+func TypeID(t Type) int {
+	switch t := t.(type) {
+	case *Signature:
+		return t.id
+	case *Array:
+		return t.id
+	case *List:
+		return t.id
+	case *Named:
+		return t.id
+	}
+	panic("unreachable")
+}
+
+// makeType returns the type described by the type expression e. Type names
+// are looked up in the current scope of pkg and an error is recorded, to be
+// reported by TypeCheck, if one does not name a type. If e is nil, as when a
+// type was not given, nil is returned and the type is inferred by TypeCheck.
+func makeType(pkg *Package, e ast.TypeExpr) Type {
+	switch t := e.(type) {
+	case nil:
+		return nil
+	case *ast.FuncType:
+		params := make([]Type, len(t.Params))
+		for i, p := range t.Params {
+			params[i] = makeType(pkg, p)
+		}
+		return pkg.types.funcType(params, makeType(pkg, t.Result))
+	case *ast.ArrayType:
+		elem := makeType(pkg, t.Elem)
+		if t.Len == nil {
+			return pkg.types.listType(elem)
+		}
+		n, err := strconv.ParseInt(t.Len.Lit, 0, 64)
+		if err != nil || n < 1 {
+			pkg.typeError(t.Len.Pos(), "invalid array length %s", t.Len.Lit)
+			return Unknown
+		}
+		if elem == Unknown {
+			return Unknown
+		}
+		return pkg.types.arrayType(elem, n)
+	case *ast.Ident:
+		switch o := pkg.Lookup(t.Name).(type) {
+		case *TypeName:
+			return o.Type()
+		case nil:
+			pkg.typeError(t.Pos(), "unknown type '%s'", t.Name)
+		default:
+			pkg.typeError(t.Pos(), "'%s' is not a type", t.Name)
 		}
 	}
 	return Unknown
 }
 
-func (t Type) String() string {
-	return typeStrings[t]
+// IsInteger returns true if t is a signed, unsigned or untyped integer
+func IsInteger(t Type) bool {
+	return hasInfo(t, isInteger)
+}
+
+// IsNumeric returns true if t is an integer or floating-point type
+func IsNumeric(t Type) bool {
+	return hasInfo(t, isNumeric)
+}
+
+// IsUnsigned returns true if t is an unsigned integer
+func IsUnsigned(t Type) bool {
+	return hasInfo(t, isUnsigned)
+}
+
+func hasInfo(t Type, info basicInfo) bool {
+	b, ok := t.(*Basic)
+	return ok && b.info&info != 0
+}
+
+// Size returns the width of integer type t in bits. Untyped integers have
+// the size of the default integer type.
+func (t *Basic) Size() uint {
+	return t.size
+}
+
+func (t *Basic) Underlying() Type { return t }
+func (t *Basic) String() string   { return t.name }
+
+func (t *Signature) Underlying() Type { return t }
+func (t *Signature) String() string {
+	s := "(func"
+	for _, p := range t.Params {
+		s += " " + p.String()
+	}
+	return s + "):" + t.Result.String()
+}
+
+func (t *Array) Underlying() Type { return t }
+func (t *Array) String() string {
+	return "[" + strconv.FormatInt(t.Len, 10) + "]" + t.Elem.String()
+}
+
+func (t *List) Underlying() Type { return t }
+func (t *List) String() string   { return "[]" + t.Elem.String() }
+
+// Field returns the index of the field with the given name, or -1 if the
+// struct has no such field
+func (t *Struct) Field(name string) int {
+	for i, f := range t.Fields {
+		if f.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (t *Struct) Underlying() Type { return t }
+func (t *Struct) String() string   { return "struct " + fieldString(t.Fields) }
+
+func fieldString(fields []Field) string {
+	var out []string
+	for _, f := range fields {
+		out = append(out, f.Name+":"+f.Type.String())
+	}
+	return "{" + strings.Join(out, " ") + "}"
+}
+
+// Variant returns the index of the variant with the given name, or -1 if
+// the union has no such variant
+func (t *Union) Variant(name string) int {
+	for i, v := range t.Variants {
+		if v.Name == name {
+			return i
+		}
+	}
+	return -1
 }
+
+func (t *Union) Underlying() Type { return t }
+func (t *Union) String() string {
+	var out []string
+	for _, v := range t.Variants {
+		out = append(out, v.Name+fieldString(v.Fields))
+	}
+	return fmt.Sprintf("union {%s}", strings.Join(out, " "))
+}
+
+// Name returns the name the type was declared with
+func (t *Named) Name() string { return t.name }
+
+func (t *Named) Underlying() Type { return t.underlying }
+func (t *Named) String() string   { return t.name }
+
+func (t *TypeParam) Underlying() Type { return t }
+func (t *TypeParam) String() string   { return t.name }
@@ -0,0 +1,162 @@
+// Copyright (c) 2015, Rob Thornton
+// All rights reserved.
+// This source code is governed by a Simplied BSD-License. Please see the
+// LICENSE included in this distribution for a copy of the full license
+// or, if one is not included, you may also find a copy at
+// http://opensource.org/licenses/BSD-2-Clause
+
+package ir
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rthornton128/calc/ast"
+	"github.com/rthornton128/calc/token"
+)
+
+// Constructor is declared for each variant of a union type, such as Ok in
+// (define Result (union (Ok value:int) (Err code:int))), and is called to
+// construct a value of the union
+type Constructor struct {
+	object
+	Index int // index of the variant in the union
+}
+
+func makeConstructor(pkg *Package, v *ast.Variant, t Type, i int) *Constructor {
+	return &Constructor{
+		object: object{
+			kind: ast.FuncDecl,
+			name: v.Name.Name,
+			pkg:  pkg,
+			pos:  v.Pos(),
+			typ:  t,
+		},
+		Index: i,
+	}
+}
+
+// Variant returns the variant of the union constructed by c
+func (c *Constructor) Variant() Variant {
+	return c.typ.Underlying().(*Union).Variants[c.Index]
+}
+
+func (c *Constructor) String() string {
+	return fmt.Sprintf("variant %s.%s", c.typ, c.name)
+}
+
+// UnionLit constructs a value of a union type from the fields of one of its
+// variants, such as (Ok 42)
+type UnionLit struct {
+	object
+	Index int // index of the variant in the union
+	Args  []Object
+}
+
+func makeUnionLit(pkg *Package, c *ast.CallExpr, ctor *Constructor) *UnionLit {
+	return &UnionLit{
+		object: object{name: ctor.Name(), pkg: pkg, pos: c.Pos(),
+			scope: pkg.scope, typ: ctor.Type()},
+		Index: ctor.Index,
+		Args:  MakeExprList(pkg, c.Args),
+	}
+}
+
+func (u *UnionLit) String() string {
+	var out []string
+	for _, a := range u.Args {
+		out = append(out, a.String())
+	}
+	return fmt.Sprintf("%s.%s{%s}", u.typ, u.name, strings.Join(out, " "))
+}
+
+// Match evaluates to the body of the arm matching the variant of the union
+// X. Every variant must be matched by an arm, unless one of them is a
+// default arm.
+type Match struct {
+	object
+	X    Object
+	Arms []*MatchArm
+}
+
+// MatchArm is an arm of a match. The fields of the variant it matches are
+// bound to Params, in order, which are declared in a scope of their own.
+type MatchArm struct {
+	Name   string       // name of the variant, or _ for the default arm
+	Ctor   *Constructor // nil if the default arm or Name is not a variant
+	Params []*Param
+	Body   Object
+	pos    token.Pos
+	scope  *Scope
+}
+
+func makeMatch(pkg *Package, me *ast.MatchExpr) *Match {
+	m := &Match{
+		object: object{
+			id:    pkg.getID(),
+			name:  "match",
+			pkg:   pkg,
+			pos:   me.Pos(),
+			scope: pkg.scope,
+			typ:   makeType(pkg, me.Type),
+		},
+		X: MakeExpr(pkg, me.X),
+	}
+	for _, a := range me.Arms {
+		m.Arms = append(m.Arms, makeMatchArm(pkg, a))
+	}
+	return m
+}
+
+func makeMatchArm(pkg *Package, a *ast.MatchArm) *MatchArm {
+	pkg.newScope()
+	defer pkg.closeScope()
+
+	arm := &MatchArm{Name: a.Name.Name, pos: a.Pos(), scope: pkg.scope}
+	if arm.Name != "_" {
+		arm.Ctor, _ = pkg.Lookup(arm.Name).(*Constructor)
+	}
+	for i, id := range a.Params {
+		var t Type = Unknown
+		if arm.Ctor != nil && i < len(arm.Ctor.Variant().Fields) {
+			t = arm.Ctor.Variant().Fields[i].Type
+		}
+		p := &Param{object: object{
+			id:    pkg.getID(),
+			kind:  ast.VarDecl,
+			name:  id.Name,
+			pkg:   pkg,
+			pos:   id.Pos(),
+			scope: pkg.scope,
+			typ:   t,
+		}}
+		pkg.Insert(p)
+		arm.Params = append(arm.Params, p)
+	}
+	arm.Body = MakeExpr(pkg, a.Body)
+	return arm
+}
+
+// IsDefault returns true if the arm matches any variant
+func (a *MatchArm) IsDefault() bool {
+	return a.Name == "_"
+}
+
+func (a *MatchArm) Pos() token.Pos { return a.pos }
+func (a *MatchArm) Scope() *Scope  { return a.scope }
+
+func (a *MatchArm) String() string {
+	params := make([]string, len(a.Params))
+	for i, p := range a.Params {
+		params[i] = p.String()
+	}
+	return fmt.Sprintf("%s(%s) %s", a.Name, strings.Join(params, ","), a.Body)
+}
+
+func (m *Match) String() string {
+	arms := make([]string, len(m.Arms))
+	for i, a := range m.Arms {
+		arms[i] = a.String()
+	}
+	return fmt.Sprintf("{match[%s] %s {%s}}", m.typ, m.X, strings.Join(arms, ", "))
+}
@@ -0,0 +1,36 @@
+// Copyright (c) 2015, Rob Thornton
+// All rights reserved.
+// This source code is governed by a Simplied BSD-License. Please see the
+// LICENSE included in this distribution for a copy of the full license
+// or, if one is not included, you may also find a copy at
+// http://opensource.org/licenses/BSD-2-Clause
+
+package ir
+
+import "github.com/rthornton128/calc/ast"
+
+// universe is the scope enclosing every package and holds the predeclared
+// types and the builtins. Defines in a package may shadow the names
+// declared in it.
+var universe = NewScope(nil)
+
+func init() {
+	for _, t := range []*Basic{Bool, Float, Int8, Int16, Int32, Int64, Uint8,
+		Uint16, Uint32, Uint64} {
+		universe.Insert(newTypeName(t.name, t))
+	}
+	universe.Insert(newTypeName("int", Int))
+
+	for _, name := range []string{"all", "any", "append", "count", "filter",
+		"fold", "get", "len", "list", "map", "range", "reduce", "slice"} {
+		universe.Insert(&Builtin{
+			object: object{kind: ast.FuncDecl, name: name, scope: universe},
+		})
+	}
+}
+
+func newTypeName(name string, t Type) *TypeName {
+	return &TypeName{
+		object: object{kind: ast.TypeDecl, name: name, scope: universe, typ: t},
+	}
+}
@@ -31,9 +31,14 @@ func (i *Var) String() string {
 	return i.Name()
 }
 
+// Variable declares Params, scoped to Body. A var declares the type of each
+// parameter and of its result. A let has neither, instead initialising its
+// parameters with Inits and taking its type from the last expression of
+// its body.
 type Variable struct {
 	object
 	Params []*Param
+	Inits  []Object // nil unless declared by let
 	Body   []Object
 }
 
@@ -48,7 +53,7 @@ func makeVariable(pkg *Package, ve *ast.VarExpr) *Variable {
 			name:  "var",
 			pos:   ve.Pos(),
 			scope: pkg.scope,
-			typ:   typeFromString(ve.Type.Name),
+			typ:   makeType(pkg, ve.Type),
 		},
 		Params: makeParamList(pkg, ve.Params),
 		Body:   MakeExprList(pkg, ve.Body),
@@ -57,10 +62,65 @@ func makeVariable(pkg *Package, ve *ast.VarExpr) *Variable {
 	return v
 }
 
+// makeLet makes a let as a Variable for each binding, with the rest of the
+// let as its body, so a binding may only refer to those before it. Each
+// initial value is made before the binding is declared, so it refers to
+// any outer binding of the same name.
+func makeLet(pkg *Package, le *ast.LetExpr) *Variable {
+	return makeLetBindings(pkg, le, le.Bindings)
+}
+
+func makeLetBindings(pkg *Package, le *ast.LetExpr,
+	bindings []*ast.Binding) *Variable {
+	var inits []Object
+	if len(bindings) > 0 {
+		inits = []Object{MakeExpr(pkg, bindings[0].Value)}
+	}
+
+	pkg.newScope()
+	defer pkg.closeScope()
+
+	v := &Variable{
+		object: object{
+			id:    pkg.getID(),
+			kind:  ast.VarDecl,
+			name:  "let",
+			pos:   le.Pos(),
+			scope: pkg.scope,
+		},
+		Inits: inits,
+	}
+	if len(bindings) == 0 {
+		v.Body = MakeExprList(pkg, le.Body)
+		return v
+	}
+
+	b := bindings[0]
+	p := &Param{object: object{
+		id:    pkg.getID(),
+		kind:  ast.VarDecl,
+		name:  b.Name.Name,
+		pkg:   pkg,
+		pos:   b.Pos(),
+		scope: pkg.scope,
+	}}
+	pkg.Insert(p)
+	v.Params = []*Param{p}
+	if len(bindings) == 1 {
+		v.Body = MakeExprList(pkg, le.Body)
+	} else {
+		v.Body = []Object{makeLetBindings(pkg, le, bindings[1:])}
+	}
+	return v
+}
+
 func (v *Variable) String() string {
 	params := make([]string, len(v.Params))
 	for i, p := range v.Params {
 		params[i] = v.Scope().Lookup(p.Name()).String()
+		if v.Inits != nil {
+			params[i] += "=" + v.Inits[i].String()
+		}
 	}
 
 	body := make([]string, len(v.Body))
@@ -68,6 +128,7 @@ func (v *Variable) String() string {
 		body[i] = e.String()
 	}
 
-	return fmt.Sprintf("var:%s (%s) {%s}", v.Type(), strings.Join(params, ","),
+	return fmt.Sprintf("%s:%s (%s) {%s}", v.name, v.Type(),
+		strings.Join(params, ","),
 		strings.Join(body, ","))
 }
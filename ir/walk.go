@@ -0,0 +1,197 @@
+// Copyright (c) 2015, Rob Thornton
+// All rights reserved.
+// This source code is governed by a Simplied BSD-License. Please see the
+// LICENSE included in this distribution for a copy of the full license
+// or, if one is not included, you may also find a copy at
+// http://opensource.org/licenses/BSD-2-Clause
+
+package ir
+
+// A Visitor's Visit method is called by Walk for each object encountered.
+// If it returns false, the objects that object is made of are not visited.
+type Visitor interface {
+	Visit(o Object) bool
+}
+
+// Walk visits o and, if Visit returns true, each of the objects it is made
+// of. Only the instances of a generic function are walked, since its own
+// body is never checked.
+func Walk(o Object, v Visitor) {
+	if !v.Visit(o) {
+		return
+	}
+
+	switch t := o.(type) {
+	case *ArrayLit:
+		walkList(t.Elems, v)
+	case *Assignment:
+		if t.Index != nil {
+			Walk(t.Index, v)
+		}
+		Walk(t.Rhs, v)
+	case *Binary:
+		Walk(t.Lhs, v)
+		Walk(t.Rhs, v)
+	case *Branch:
+		if t.Value != nil {
+			Walk(t.Value, v)
+		}
+	case *Call:
+		Walk(t.Func, v)
+		walkList(t.Args, v)
+	case *Cond:
+		walkClauses(t.Clauses, v)
+		if t.Else != nil {
+			Walk(t.Else, v)
+		}
+	case *Conversion:
+		walkList(t.Args, v)
+	case *Define:
+		Walk(t.Body, v)
+	case *For:
+		Walk(t.Cond, v)
+		walkList(t.Body, v)
+	case *Function:
+		if t.IsGeneric() {
+			for _, inst := range t.Instances {
+				Walk(inst, v)
+			}
+			return
+		}
+		walkList(t.Body, v)
+	case *If:
+		Walk(t.Cond, v)
+		Walk(t.Then, v)
+		if t.Else != nil {
+			Walk(t.Else, v)
+		}
+	case *Match:
+		Walk(t.X, v)
+		for _, a := range t.Arms {
+			Walk(a.Body, v)
+		}
+	case *Selector:
+		Walk(t.X, v)
+	case *StructLit:
+		walkList(t.Args, v)
+	case *Switch:
+		Walk(t.Tag, v)
+		walkClauses(t.Clauses, v)
+		if t.Else != nil {
+			Walk(t.Else, v)
+		}
+	case *Unary:
+		Walk(t.Rhs, v)
+	case *UnionLit:
+		walkList(t.Args, v)
+	case *Variable:
+		walkList(t.Inits, v)
+		walkList(t.Body, v)
+	}
+}
+
+func walkList(list []Object, v Visitor) {
+	for _, o := range list {
+		Walk(o, v)
+	}
+}
+
+func walkClauses(clauses []*Clause, v Visitor) {
+	for _, c := range clauses {
+		Walk(c.Test, v)
+		Walk(c.Body, v)
+	}
+}
+
+// inspector is a Visitor calling itself for each object visited
+type inspector func(o Object) bool
+
+func (f inspector) Visit(o Object) bool { return f(o) }
+
+// inspect walks o, calling f for each object visited
+func inspect(o Object, f func(o Object) bool) {
+	Walk(o, inspector(f))
+}
+
+// rewrite replaces each object o is directly made of with the result of
+// calling f for it. Since a generic function is made of its instances, f is
+// called for each but its result is ignored.
+func rewrite(o Object, f func(o Object) Object) {
+	switch t := o.(type) {
+	case *ArrayLit:
+		rewriteList(t.Elems, f)
+	case *Assignment:
+		if t.Index != nil {
+			t.Index = f(t.Index)
+		}
+		t.Rhs = f(t.Rhs)
+	case *Binary:
+		t.Lhs, t.Rhs = f(t.Lhs), f(t.Rhs)
+	case *Branch:
+		if t.Value != nil {
+			t.Value = f(t.Value)
+		}
+	case *Call:
+		t.Func = f(t.Func)
+		rewriteList(t.Args, f)
+	case *Cond:
+		rewriteClauses(t.Clauses, f)
+		if t.Else != nil {
+			t.Else = f(t.Else)
+		}
+	case *Conversion:
+		rewriteList(t.Args, f)
+	case *Define:
+		t.Body = f(t.Body)
+	case *For:
+		t.Cond = f(t.Cond)
+		rewriteList(t.Body, f)
+	case *Function:
+		if t.IsGeneric() {
+			for _, inst := range t.Instances {
+				f(inst)
+			}
+			return
+		}
+		rewriteList(t.Body, f)
+	case *If:
+		t.Cond, t.Then = f(t.Cond), f(t.Then)
+		if t.Else != nil {
+			t.Else = f(t.Else)
+		}
+	case *Match:
+		t.X = f(t.X)
+		for _, a := range t.Arms {
+			a.Body = f(a.Body)
+		}
+	case *Selector:
+		t.X = f(t.X)
+	case *StructLit:
+		rewriteList(t.Args, f)
+	case *Switch:
+		t.Tag = f(t.Tag)
+		rewriteClauses(t.Clauses, f)
+		if t.Else != nil {
+			t.Else = f(t.Else)
+		}
+	case *Unary:
+		t.Rhs = f(t.Rhs)
+	case *UnionLit:
+		rewriteList(t.Args, f)
+	case *Variable:
+		rewriteList(t.Inits, f)
+		rewriteList(t.Body, f)
+	}
+}
+
+func rewriteList(list []Object, f func(o Object) Object) {
+	for i, o := range list {
+		list[i] = f(o)
+	}
+}
+
+func rewriteClauses(clauses []*Clause, f func(o Object) Object) {
+	for _, c := range clauses {
+		c.Test, c.Body = f(c.Test), f(c.Body)
+	}
+}
@@ -25,6 +25,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/rthornton128/calc/ast"
@@ -141,6 +142,8 @@ type parser struct {
 	curScope *ast.Scope
 	topScope *ast.Scope
 
+	defineBody bool
+
 	pos token.Pos
 	tok token.Token
 	lit string
@@ -188,35 +191,92 @@ func (p *parser) closeScope() {
 
 /* Parsing */
 
+func (p *parser) parseArrayLit() *ast.ArrayLit {
+	al := &ast.ArrayLit{Lbrack: p.expect(token.LBRACK)}
+	for p.tok != token.RBRACK && p.tok != token.EOF {
+		al.Elems = append(al.Elems, p.parseExpression())
+	}
+	al.Rbrack = p.expect(token.RBRACK)
+	return al
+}
+
 func (p *parser) parseAssignExpr() *ast.AssignExpr {
-	return &ast.AssignExpr{
+	ae := &ast.AssignExpr{
 		Equal: p.expect(token.ASSIGN),
 		Name:  p.parseIdent(),
-		Value: p.parseExpression(),
 	}
+	for p.tok == token.PERIOD {
+		p.next()
+		ae.Fields = append(ae.Fields, p.parseIdent())
+	}
+	ae.Value = p.parseExpression()
+	if p.tok != token.RPAREN {
+		ae.Index, ae.Value = ae.Value, p.parseExpression()
+	}
+	return ae
 }
 
 func (p *parser) parseBasicLit() *ast.BasicLit {
 	pos, tok, lit := p.pos, p.tok, p.lit
+
+	var err error
+	switch tok {
+	case token.FLOAT:
+		_, err = strconv.ParseFloat(lit, 64)
+	case token.INTEGER:
+		_, err = strconv.ParseUint(lit, 0, 64)
+	}
+	switch {
+	case tok == token.INTEGER && hasLeadingZero(lit):
+		p.addError("invalid integer literal '", lit,
+			"', octal literals are written with a 0o prefix")
+	case err == nil:
+	case err.(*strconv.NumError).Err == strconv.ErrRange:
+		p.addError(strings.ToLower(tok.String()), " literal out of range '",
+			lit, "'")
+	default:
+		p.addError("invalid ", strings.ToLower(tok.String()), " literal '",
+			lit, "'")
+	}
+
 	p.next()
 	return &ast.BasicLit{LitPos: pos, Kind: tok, Lit: lit}
 }
 
+// hasLeadingZero reports whether integer literal lit is a decimal with a
+// leading zero, such as 012, which would otherwise be read as octal
+func hasLeadingZero(lit string) bool {
+	return len(lit) > 1 && lit[0] == '0' && !strings.ContainsRune("xXoObB",
+		rune(lit[1]))
+}
+
 func (p *parser) parseBinaryExpr() *ast.BinaryExpr {
 	pos := p.pos
 	op := p.tok
 	p.next()
 
+	list := p.parseExprList()
+	if len(list) < 2 {
+		// a lone operand is negated or made positive with a prefix, as in -x
+		p.errors.Add(p.file.Position(pos), "binary '", op,
+			"' expects at least two operands")
+	}
 	return &ast.BinaryExpr{
 		Op:    op,
 		OpPos: pos,
-		List:  p.parseExprList(),
+		List:  list,
 	}
 }
 
 func (p *parser) parseCallExpr() *ast.CallExpr {
+	var fun ast.Expr
+	if p.tok == token.IDENT {
+		fun = p.parseSelectors(p.parseName())
+	} else {
+		fun = p.parseExpression()
+	}
 	return &ast.CallExpr{
-		Name: p.parseIdent(),
+		Fun:  fun,
 		Args: p.parseExprList(),
 	}
 }
@@ -230,14 +290,17 @@ func (p *parser) parseDefineStmt() *ast.DefineStmt {
 		Name:   p.parseIdent(),
 	}
 
-	if p.tok == token.COLON {
-		d.Type = p.parseType()
-	}
+	d.Type = p.parseOptionalType()
+	p.defineBody = true
 	d.Body = p.parseExpression()
 	return d
 }
 
 func (p *parser) parseExpression() ast.Expr {
+	// struct types may only be declared by the body of a define
+	defineBody := p.defineBody
+	p.defineBody = false
+
 	var e ast.Expr
 	switch p.tok {
 	case token.LPAREN:
@@ -245,18 +308,41 @@ func (p *parser) parseExpression() ast.Expr {
 
 		switch p.tok {
 		case token.ADD, token.SUB, token.MUL, token.QUO, token.REM,
+			token.BAND, token.BOR, token.BXOR, token.SHL, token.SHR,
 			token.EQL, token.GTE, token.GTT, token.NEQ, token.LST, token.LTE:
 			e = p.parseBinaryExpr()
 		case token.ASSIGN:
 			e = p.parseAssignExpr()
+		case token.BREAK, token.CONTINUE:
+			e = p.parseBranchExpr()
+		case token.COND:
+			e = p.parseCondExpr()
 		case token.FOR:
 			e = p.parseFor()
 		case token.FUNC:
 			e = p.parseFuncExpr()
-		case token.IDENT:
+		case token.IDENT, token.LPAREN, token.LBRACK:
 			e = p.parseCallExpr()
 		case token.IF:
 			e = p.parseIfExpr()
+		case token.LET:
+			e = p.parseLetExpr()
+		case token.MATCH:
+			e = p.parseMatchExpr()
+		case token.NOT:
+			e = p.parseUnaryExpr()
+		case token.STRUCT:
+			if !defineBody {
+				p.addError("struct types may only be declared by define")
+			}
+			e = p.parseStructType()
+		case token.SWITCH:
+			e = p.parseSwitchExpr()
+		case token.UNION:
+			if !defineBody {
+				p.addError("union types may only be declared by define")
+			}
+			e = p.parseUnionType()
 		case token.VAR:
 			e = p.parseVarExpr()
 		default:
@@ -265,11 +351,14 @@ func (p *parser) parseExpression() ast.Expr {
 		}
 
 		p.expect(token.RPAREN)
+		e = p.parseSelectors(e)
 	case token.IDENT:
-		e = p.parseIdent()
-	case token.BOOL, token.INTEGER:
+		e = p.parseSelectors(p.parseName())
+	case token.LBRACK:
+		e = p.parseSelectors(p.parseArrayLit())
+	case token.BOOL, token.FLOAT, token.INTEGER:
 		e = p.parseBasicLit()
-	case token.ADD, token.SUB:
+	case token.ADD, token.SUB, token.BNOT, token.NOT:
 		e = p.parseUnaryExpr()
 	default:
 		p.addError("Expected expression, got '" + p.lit + "'")
@@ -279,6 +368,16 @@ func (p *parser) parseExpression() ast.Expr {
 	return e
 }
 
+// parseSelectors parses any fields selected from the expression x, such as
+// the field y of p.x.y
+func (p *parser) parseSelectors(x ast.Expr) ast.Expr {
+	for p.tok == token.PERIOD {
+		p.next()
+		x = &ast.SelectorExpr{X: x, Sel: p.parseIdent()}
+	}
+	return x
+}
+
 func (p *parser) parseExprList() []ast.Expr {
 	list := make([]ast.Expr, 0)
 	for p.tok != token.RPAREN && p.tok != token.EOF {
@@ -287,6 +386,31 @@ func (p *parser) parseExprList() []ast.Expr {
 	return list
 }
 
+// declare declares name in the current scope, reporting an error and
+// returning false if it has already been declared
+func (p *parser) declare(name *ast.Ident, kind ast.Kind) bool {
+	prev := p.curScope.Insert(&ast.Object{
+		NamePos: name.NamePos,
+		Name:    name.Name,
+		Kind:    kind,
+	})
+	if prev == nil {
+		return true
+	}
+	switch prev.Kind {
+	case ast.FuncDecl:
+		p.addError(prev.Name, " redeclared; declared as function at ",
+			p.file.Position(prev.NamePos))
+	case ast.TypeDecl:
+		p.addError(prev.Name, " redeclared; declared as type at ",
+			p.file.Position(prev.NamePos))
+	case ast.VarDecl:
+		p.addError(prev.Name, " redeclared; declared as variable at ",
+			p.file.Position(prev.NamePos))
+	}
+	return false
+}
+
 func (p *parser) parseFile() *ast.File {
 	defs := make([]*ast.DefineStmt, 0)
 	for p.tok != token.EOF {
@@ -295,28 +419,23 @@ func (p *parser) parseFile() *ast.File {
 		switch def.Body.(type) {
 		case *ast.FuncExpr:
 			def.Kind = ast.FuncDecl
+		case *ast.StructType, *ast.UnionType:
+			def.Kind = ast.TypeDecl
 		default:
 			def.Kind = ast.VarDecl
 		}
 
-		prev := p.curScope.Insert(&ast.Object{
-			NamePos: def.Name.NamePos,
-			Name:    def.Name.Name,
-			Kind:    def.Kind,
-		})
-
-		if prev != nil {
-			switch prev.Kind {
-			case ast.FuncDecl:
-				p.addError(prev.Name, " redeclared; declared as function at ",
-					p.file.Position(prev.NamePos))
-			case ast.VarDecl:
-				p.addError(prev.Name, " redeclared; declared as variable at ",
-					p.file.Position(prev.NamePos))
-			}
+		if !p.declare(def.Name, def.Kind) {
 			continue
 		}
 
+		// the variants of a union are declared as constructors alongside it
+		if u, ok := def.Body.(*ast.UnionType); ok {
+			for _, v := range u.Variants {
+				p.declare(v.Name, ast.FuncDecl)
+			}
+		}
+
 		if p.errors.Count() > 0 {
 			break
 		}
@@ -324,18 +443,68 @@ func (p *parser) parseFile() *ast.File {
 		defs = append(defs, def)
 	}
 
-	if len(defs) < 1 {
+	// a file whose first define failed to parse has already been reported
+	if len(defs) < 1 && p.errors.Count() == 0 {
 		p.addError("reached end of file without any declarations")
 	}
 
 	return &ast.File{Defs: defs}
 }
 
+func (p *parser) parseBranchExpr() *ast.BranchExpr {
+	be := &ast.BranchExpr{TokPos: p.pos, Tok: p.tok}
+	p.next()
+	if p.tok != token.RPAREN {
+		if be.Tok == token.CONTINUE {
+			p.addError("continue does not take a value")
+		}
+		be.Value = p.parseExpression()
+	}
+	return be
+}
+
+// parseClauses parses the clauses of a cond or switch expression, the last
+// of which may be an else clause, such as (else 0)
+func (p *parser) parseClauses(name string) ([]*ast.Clause, ast.Expr) {
+	var clauses []*ast.Clause
+	var els ast.Expr
+	for p.tok == token.LPAREN {
+		lparen := p.expect(token.LPAREN)
+		if els != nil {
+			p.addError("else must be the last clause of ", name)
+		}
+		if p.tok == token.ELSE {
+			p.next()
+			els = p.parseExpression()
+		} else {
+			clauses = append(clauses, &ast.Clause{
+				Lparen: lparen,
+				Test:   p.parseExpression(),
+				Body:   p.parseExpression(),
+			})
+		}
+		p.expect(token.RPAREN)
+	}
+	if len(clauses) == 0 {
+		p.addError(name, " expects at least one clause")
+	}
+	return clauses, els
+}
+
+func (p *parser) parseCondExpr() *ast.CondExpr {
+	ce := &ast.CondExpr{
+		Cond: p.expect(token.COND),
+		Type: p.parseOptionalType(),
+	}
+	ce.Clauses, ce.Else = p.parseClauses("cond")
+	return ce
+}
+
 func (p *parser) parseFor() *ast.ForExpr {
 	return &ast.ForExpr{
 		For:  p.expect(token.FOR),
 		Cond: p.parseExpression(),
-		Type: p.parseType(),
+		Type: p.parseOptionalType(),
 		Body: p.parseExprList(),
 	}
 }
@@ -344,12 +513,35 @@ func (p *parser) parseFuncExpr() *ast.FuncExpr {
 	p.openScope()
 	defer p.closeScope()
 
-	return &ast.FuncExpr{
-		Func:   p.expect(token.FUNC),
-		Params: p.parseParamList(),
-		Type:   p.parseType(),
-		Body:   p.parseExprList(),
+	fe := &ast.FuncExpr{Func: p.expect(token.FUNC)}
+	if p.tok == token.LBRACK {
+		fe.TypeParams = p.parseTypeParams()
 	}
+	fe.Params = p.parseParamList()
+	fe.Type = p.parseOptionalType()
+	fe.Body = p.parseExprList()
+	return fe
+}
+
+// parseTypeParams parses the type parameters of a generic function, such as
+// [T U]
+func (p *parser) parseTypeParams() []*ast.Ident {
+	var params []*ast.Ident
+	names := make(map[string]bool)
+	p.expect(token.LBRACK)
+	for p.tok == token.IDENT {
+		id := p.parseIdent()
+		if names[id.Name] {
+			p.addError("duplicate type parameter ", id.Name)
+		}
+		names[id.Name] = true
+		params = append(params, id)
+	}
+	p.expect(token.RBRACK)
+	if len(params) == 0 {
+		p.addError("func expects at least one type parameter")
+	}
+	return params
 }
 
 func (p *parser) parseIdent() *ast.Ident {
@@ -357,6 +549,25 @@ func (p *parser) parseIdent() *ast.Ident {
 	return &ast.Ident{NamePos: p.expect(token.IDENT), Name: name}
 }
 
+// parseName parses an identifier along with any type arguments which
+// instantiate it, such as max[int]. Type arguments must immediately follow
+// the name, otherwise the bracket begins an array literal, as in (f x [1 2]).
+func (p *parser) parseName() ast.Expr {
+	id := p.parseIdent()
+	if p.tok != token.LBRACK || p.pos != id.Pos()+token.Pos(len(id.Name)) {
+		return id
+	}
+	ie := &ast.InstanceExpr{Name: id, Lbrack: p.expect(token.LBRACK)}
+	for p.tok == token.IDENT || p.tok == token.LPAREN || p.tok == token.LBRACK {
+		ie.Types = append(ie.Types, p.parseTypeExpr())
+	}
+	p.expect(token.RBRACK)
+	if len(ie.Types) == 0 {
+		p.addError("instance of ", id.Name, " expects at least one type argument")
+	}
+	return ie
+}
+
 func (p *parser) parseIfExpr() *ast.IfExpr {
 	p.openScope()
 	defer p.closeScope()
@@ -364,7 +575,7 @@ func (p *parser) parseIfExpr() *ast.IfExpr {
 	ie := &ast.IfExpr{
 		If:   p.expect(token.IF),
 		Cond: p.parseExpression(),
-		Type: p.parseType(),
+		Type: p.parseOptionalType(),
 		Then: p.parseExpression(),
 	}
 
@@ -374,6 +585,76 @@ func (p *parser) parseIfExpr() *ast.IfExpr {
 	return ie
 }
 
+// parseLetExpr parses a let expression, such as (let ((x 1) (y x)) (+ x y)).
+// Each binding opens a scope of its own, so a name may be bound again by a
+// later binding.
+func (p *parser) parseLetExpr() *ast.LetExpr {
+	le := &ast.LetExpr{Let: p.expect(token.LET)}
+	p.expect(token.LPAREN)
+	scopes := 0
+	for p.tok == token.LPAREN {
+		p.next()
+		b := &ast.Binding{Name: p.parseIdent(), Value: p.parseExpression()}
+		p.expect(token.RPAREN)
+		p.openScope()
+		scopes++
+		p.declare(b.Name, ast.VarDecl)
+		le.Bindings = append(le.Bindings, b)
+	}
+	p.expect(token.RPAREN)
+	le.Body = p.parseExprList()
+	if len(le.Body) == 0 {
+		p.addError("let expects at least one expression in its body")
+	}
+	for ; scopes > 0; scopes-- {
+		p.closeScope()
+	}
+	return le
+}
+
+func (p *parser) parseMatchExpr() *ast.MatchExpr {
+	me := &ast.MatchExpr{
+		Match: p.expect(token.MATCH),
+		X:     p.parseExpression(),
+		Type:  p.parseOptionalType(),
+	}
+	for p.tok == token.LPAREN {
+		me.Arms = append(me.Arms, p.parseMatchArm())
+	}
+	if len(me.Arms) == 0 {
+		p.addError("match expects at least one arm")
+	}
+	return me
+}
+
+// parseMatchArm parses an arm of a match expression, either ((Name x...)
+// body) or (_ body)
+func (p *parser) parseMatchArm() *ast.MatchArm {
+	p.openScope()
+	defer p.closeScope()
+
+	arm := &ast.MatchArm{Lparen: p.expect(token.LPAREN)}
+	if p.tok == token.IDENT && p.lit == "_" {
+		arm.Name = p.parseIdent()
+	} else {
+		p.expect(token.LPAREN)
+		arm.Name = p.parseIdent()
+		for p.tok == token.IDENT {
+			id := p.parseIdent()
+			o := &ast.Object{Kind: ast.VarDecl, Name: id.Name, NamePos: id.NamePos}
+			if prev := p.curScope.Insert(o); prev != nil {
+				p.addError("duplicate parameter ", prev.Name,
+					"; previously declared at ", p.file.Position(prev.Pos()))
+			}
+			arm.Params = append(arm.Params, id)
+		}
+		p.expect(token.RPAREN)
+	}
+	arm.Body = p.parseExpression()
+	p.expect(token.RPAREN)
+	return arm
+}
+
 func (p *parser) parseParamList() []*ast.Param {
 	params := make([]*ast.Param, 0)
 	if p.tok != token.LPAREN {
@@ -400,13 +681,105 @@ func (p *parser) parseParamList() []*ast.Param {
 	return params
 }
 
-func (p *parser) parseType() *ast.Ident {
+func (p *parser) parseSwitchExpr() *ast.SwitchExpr {
+	se := &ast.SwitchExpr{
+		Switch: p.expect(token.SWITCH),
+		Tag:    p.parseExpression(),
+		Type:   p.parseOptionalType(),
+	}
+	se.Clauses, se.Else = p.parseClauses("switch")
+	return se
+}
+
+func (p *parser) parseType() ast.TypeExpr {
 	p.expect(token.COLON)
+	return p.parseTypeExpr()
+}
+
+// parseOptionalType parses a type if one is given or returns nil, in which
+// case the type is inferred
+func (p *parser) parseOptionalType() ast.TypeExpr {
+	if p.tok != token.COLON {
+		return nil
+	}
+	return p.parseType()
+}
+
+func (p *parser) parseStructType() *ast.StructType {
+	st := &ast.StructType{Struct: p.expect(token.STRUCT)}
+	p.expect(token.LPAREN)
+	names := make(map[string]bool)
+	for p.tok == token.IDENT {
+		field := &ast.Param{Name: p.parseIdent(), Type: p.parseType()}
+		if names[field.Name.Name] {
+			p.addError("duplicate field ", field.Name.Name)
+		}
+		names[field.Name.Name] = true
+		st.Fields = append(st.Fields, field)
+	}
+	p.expect(token.RPAREN)
+	return st
+}
+
+// parseUnionType parses a union type such as
+// (union (Ok value:int) (Err code:int) (None))
+func (p *parser) parseUnionType() *ast.UnionType {
+	ut := &ast.UnionType{Union: p.expect(token.UNION)}
+	for p.tok == token.LPAREN {
+		p.next()
+		v := &ast.Variant{Name: p.parseIdent()}
+		names := make(map[string]bool)
+		for p.tok == token.IDENT {
+			field := &ast.Param{Name: p.parseIdent(), Type: p.parseType()}
+			if names[field.Name.Name] {
+				p.addError("duplicate field ", field.Name.Name)
+			}
+			names[field.Name.Name] = true
+			v.Fields = append(v.Fields, field)
+		}
+		p.expect(token.RPAREN)
+		ut.Variants = append(ut.Variants, v)
+	}
+	if len(ut.Variants) == 0 {
+		p.addError("union expects at least one variant")
+	}
+	return ut
+}
+
+func (p *parser) parseTypeExpr() ast.TypeExpr {
+	switch p.tok {
+	case token.LBRACK:
+		return p.parseArrayType()
+	case token.LPAREN:
+		return p.parseFuncType()
+	}
 	return p.parseIdent()
 }
 
+func (p *parser) parseArrayType() *ast.ArrayType {
+	at := &ast.ArrayType{Lbrack: p.expect(token.LBRACK)}
+	if p.tok == token.INTEGER {
+		at.Len = p.parseBasicLit()
+	}
+	p.expect(token.RBRACK)
+	at.Elem = p.parseTypeExpr()
+	return at
+}
+
+func (p *parser) parseFuncType() *ast.FuncType {
+	p.expect(token.LPAREN)
+
+	ft := &ast.FuncType{Func: p.expect(token.FUNC)}
+	for p.tok == token.IDENT || p.tok == token.LPAREN || p.tok == token.LBRACK {
+		ft.Params = append(ft.Params, p.parseTypeExpr())
+	}
+	p.expect(token.RPAREN)
+	ft.Result = p.parseType()
+	return ft
+}
+
 func (p *parser) parseUnaryExpr() *ast.UnaryExpr {
-	pos, op := p.pos, p.lit
+	pos, op := p.pos, p.tok
 	p.next()
 	return &ast.UnaryExpr{OpPos: pos, Op: op, Value: p.parseExpression()}
 }
@@ -415,7 +788,7 @@ func (p *parser) parseVarExpr() *ast.VarExpr {
 	return &ast.VarExpr{
 		Var:    p.expect(token.VAR),
 		Params: p.parseParamList(),
-		Type:   p.parseType(),
+		Type:   p.parseOptionalType(),
 		Body:   p.parseExprList(),
 	}
 }
@@ -29,35 +29,61 @@ type Test struct {
 type Type int
 
 const (
-	ASSIGN Type = iota
+	ARM Type = iota
+	ARRAY
+	ASSIGN
 	BASIC
 	BINARY
+	BINDING
+	BRANCH
 	CALL
+	CLAUSE
+	COND
 	DEFINE
 	FILE
 	FOR
 	FUNC
 	IDENT
 	IF
+	INSTANCE
+	LET
+	MATCH
+	SELECTOR
+	STRUCT
+	SWITCH
 	UNARY
+	UNION
 	UNKNOWN
 	VAR
 )
 
 var typeStrings = []string{
-	ASSIGN:  "assignexpr",
-	BASIC:   "basiclit",
-	BINARY:  "binaryexpr",
-	CALL:    "callexpr",
-	DEFINE:  "definestmt",
-	FILE:    "file",
-	FOR:     "for",
-	FUNC:    "funcexpr",
-	IDENT:   "ident",
-	IF:      "if",
-	UNARY:   "unaryexpr",
-	UNKNOWN: "unknown",
-	VAR:     "var",
+	ARM:      "matcharm",
+	ARRAY:    "arraylit",
+	ASSIGN:   "assignexpr",
+	BASIC:    "basiclit",
+	BINARY:   "binaryexpr",
+	BINDING:  "binding",
+	BRANCH:   "branchexpr",
+	CALL:     "callexpr",
+	CLAUSE:   "clause",
+	COND:     "condexpr",
+	DEFINE:   "definestmt",
+	FILE:     "file",
+	FOR:      "for",
+	FUNC:     "funcexpr",
+	IDENT:    "ident",
+	IF:       "if",
+	INSTANCE: "instanceexpr",
+	LET:      "letexpr",
+	MATCH:    "matchexpr",
+	SELECTOR: "selectorexpr",
+	STRUCT:   "structtype",
+	SWITCH:   "switchexpr",
+	UNARY:    "unaryexpr",
+	UNION:    "uniontype",
+	UNKNOWN:  "unknown",
+	VAR:      "var",
 }
 
 func (t Type) String() string { return typeStrings[int(t)] }
@@ -71,14 +97,24 @@ type Tester struct {
 func (t *Tester) Visit(n ast.Node) bool {
 	var typ Type
 	switch n.(type) {
+	case *ast.ArrayLit:
+		typ = ARRAY
 	case *ast.AssignExpr:
 		typ = ASSIGN
 	case *ast.BasicLit:
 		typ = BASIC
 	case *ast.BinaryExpr:
 		typ = BINARY
+	case *ast.Binding:
+		typ = BINDING
+	case *ast.BranchExpr:
+		typ = BRANCH
 	case *ast.CallExpr:
 		typ = CALL
+	case *ast.Clause:
+		typ = CLAUSE
+	case *ast.CondExpr:
+		typ = COND
 	case *ast.DefineStmt:
 		typ = DEFINE
 	case *ast.File:
@@ -91,8 +127,24 @@ func (t *Tester) Visit(n ast.Node) bool {
 		typ = IDENT
 	case *ast.IfExpr:
 		typ = IF
+	case *ast.InstanceExpr:
+		typ = INSTANCE
+	case *ast.LetExpr:
+		typ = LET
+	case *ast.MatchArm:
+		typ = ARM
+	case *ast.MatchExpr:
+		typ = MATCH
+	case *ast.SelectorExpr:
+		typ = SELECTOR
+	case *ast.StructType:
+		typ = STRUCT
+	case *ast.SwitchExpr:
+		typ = SWITCH
 	case *ast.UnaryExpr:
 		typ = UNARY
+	case *ast.UnionType:
+		typ = UNION
 	case *ast.VarExpr:
 		typ = VAR
 	}
@@ -144,16 +196,79 @@ func checkTest(t *testing.T, test Test, n ast.Node, err error) {
 func TestParseBasic(t *testing.T) {
 	tests := []Test{
 		{"integer", "24", []Type{BASIC}, true},
+		{"float", "2.4e1", []Type{BASIC}, true},
+		{"hex", "0xFF", []Type{BASIC}, true},
+		{"octal", "0o17", []Type{BASIC}, true},
+		{"zero", "0", []Type{BASIC}, true},
+		{"binary", "0b1010", []Type{BASIC}, true},
+		{"separator", "1_000", []Type{BASIC}, true},
 		{"var", "a", []Type{IDENT}, true},
 	}
 	handleTests(t, tests)
 }
 
+func TestParseBinaryOperands(t *testing.T) {
+	tests := []struct{ src, err string }{
+		{"(- 5)", "operands:1:1 binary '-' expects at least two operands\n"},
+		{"(+)", "operands:1:1 binary '+' expects at least two operands\n"},
+		{"(* (- x) 2)",
+			"operands:1:4 binary '-' expects at least two operands\n"},
+	}
+	for _, test := range tests {
+		_, err := parse.ParseExpression("operands", test.src)
+		if err == nil || err.Error() != test.err {
+			t.Errorf("%s: expected error %q, got %v", test.src, test.err, err)
+		}
+	}
+}
+
+func TestParseInvalidLiteral(t *testing.T) {
+	tests := []string{
+		"0b12",
+		"0x",
+		"1__0",
+		"_1",
+		"09",
+		"012",
+		"0_12",
+		"00",
+		"18446744073709551616",
+		"1e400",
+	}
+	for _, src := range tests {
+		if _, err := parse.ParseExpression("invalid", src); err == nil {
+			t.Errorf("expected error parsing literal '%s'", src)
+		}
+	}
+}
+
+func TestParseInvalidLiteralFile(t *testing.T) {
+	tests := []struct{ src, err string }{
+		{"(define main (func:int 0b12))",
+			"invalid:1:23 invalid integer literal '0b12'\n"},
+		{"(define main (func:int (+ 0b12 1)))",
+			"invalid:1:26 invalid integer literal '0b12'\n"},
+		{"(define main (func:float (* 2.0 1e400)))",
+			"invalid:1:32 float literal out of range '1e400'\n"},
+		{"(define main (func:int 012))", "invalid:1:23 invalid integer " +
+			"literal '012', octal literals are written with a 0o prefix\n"},
+	}
+	for _, test := range tests {
+		_, err := parse.ParseFile(token.NewFileSet(), "invalid", test.src)
+		el, ok := err.(token.ErrorList)
+		if !ok || el.Count() != 1 || el.Error() != test.err {
+			t.Errorf("%s: expected error %q, got %v", test.src, test.err, err)
+		}
+	}
+}
+
 func TestParseBinary(t *testing.T) {
 	tests := []Test{
 		{"simple", "(+ 2 3)", []Type{BINARY, BASIC, BASIC}, true},
 		{"one-var", "(+ 2 b)", []Type{BINARY, BASIC, IDENT}, true},
 		{"two-vars", "(+ a b)", []Type{BINARY, IDENT, IDENT}, true},
+		{"bitwise", "(& a 0xFF)", []Type{BINARY, IDENT, BASIC}, true},
+		{"shift", "(<< 1 2 3)", []Type{BINARY, BASIC, BASIC, BASIC}, true},
 		{"single-operand", "(- 5)", []Type{}, false},
 		{"post-fix", "(3 5 +)", []Type{}, false},
 		{"infix", "(3 + 4)", []Type{}, false},
@@ -167,10 +282,33 @@ func TestParseBinary(t *testing.T) {
 	handleTests(t, tests)
 }
 
+func TestParseArray(t *testing.T) {
+	tests := []Test{
+		{"literal", "[1 (+ a 2) b]",
+			[]Type{ARRAY, BASIC, BINARY, IDENT, BASIC, IDENT}, true},
+		{"nested", "[[1] [2]]", []Type{ARRAY, ARRAY, BASIC, ARRAY, BASIC}, true},
+		{"index", "(a 0)", []Type{CALL, IDENT, BASIC}, true},
+		{"assign", "(= a [1 2])", []Type{ASSIGN, ARRAY, BASIC, BASIC}, true},
+		{"assign-index", "(= a (+ i 1) 2)",
+			[]Type{ASSIGN, BINARY, IDENT, BASIC, BASIC}, true},
+		{"array-type-param", "(func (a:[3]int m:[2][2]u8) :[3]int a)",
+			[]Type{FUNC, IDENT}, true},
+		{"array-type-no-close", "[1 2", []Type{}, false},
+		{"array-type-bad-len", "(func (a:[n]int) :int 0)", []Type{}, false},
+		{"assign-extra", "(= a 0 1 2)", []Type{}, false},
+	}
+	handleTests(t, tests)
+}
+
 func TestParseCall(t *testing.T) {
 	tests := []Test{
-		{"no-args", "(nothing)", []Type{CALL}, true},
-		{"two-args", "(add 1 2)", []Type{CALL, BASIC, BASIC}, true},
+		{"no-args", "(nothing)", []Type{CALL, IDENT}, true},
+		{"two-args", "(add 1 2)", []Type{CALL, IDENT, BASIC, BASIC}, true},
+		{"lambda", "((func (x:int):int (* x x)) 4)",
+			[]Type{CALL, FUNC, BINARY, IDENT, IDENT, BASIC}, true},
+		{"call-result", "((adder 1) 2)",
+			[]Type{CALL, CALL, IDENT, BASIC, BASIC}, true},
+		{"empty-head", "(() 2)", []Type{}, false},
 	}
 	handleTests(t, tests)
 }
@@ -190,10 +328,17 @@ func TestParseFor(t *testing.T) {
 	tests := []Test{
 		{"simple", "(for true :int 1)", []Type{FOR, BASIC, BASIC}, true},
 		{"no-cond", "(for :int 1)", []Type{}, false},
-		{"no-type", "(for true 1)", []Type{}, false},
+		{"no-type", "(for true 1)", []Type{FOR, BASIC, BASIC}, true},
 		{"no-body", "(for true :int)", []Type{}, false},
 		{"cond-with-2-expr-body", "(for (== n true) :int (+ 2 3) 52)",
 			[]Type{FOR, BINARY, IDENT, BASIC, BINARY, BASIC, BASIC, BASIC}, false},
+		{"break", "(for true :int (break))", []Type{FOR, BASIC, BRANCH}, true},
+		{"break-value", "(for true :int (break (+ 1 2)))",
+			[]Type{FOR, BASIC, BRANCH, BINARY, BASIC, BASIC}, true},
+		{"continue", "(for true :int (continue) 1)",
+			[]Type{FOR, BASIC, BRANCH, BASIC}, true},
+		{"continue-value", "(for true :int (continue 1))", []Type{}, false},
+		{"break-values", "(for true :int (break 1 2))", []Type{}, false},
 	}
 	handleTests(t, tests)
 }
@@ -208,13 +353,48 @@ func TestParseFunc(t *testing.T) {
 			[]Type{FUNC, BINARY, IDENT, IDENT}, true},
 		{"empty-params", "(func () :int a)", []Type{}, false},
 		{"empty-expr-list", "(func:int)", []Type{}, false},
+		{"inferred-type", "(func (a:int) (+ a 1))",
+			[]Type{FUNC, BINARY, IDENT, BASIC}, true},
+		{"inferred-type-no-params", "(func () (f))", []Type{FUNC, CALL, IDENT},
+			true},
 		{"duplicate-param", "(func (dup:int dup:int) :int 0)", []Type{}, false},
 		{"no-open", "func:int 0)", []Type{}, false},
+		{"func-type-param", "(func (f:(func int):int) :int (f 1))",
+			[]Type{FUNC, CALL, IDENT, BASIC}, true},
+		{"func-type-result", "(func:(func int (func):bool):int f)",
+			[]Type{FUNC, IDENT}, true},
+		{"func-type-no-result", "(func (f:(func int)) :int 0)", []Type{}, false},
+		{"list-type-param", "(func (xs:[]int) :[][]int (f xs))",
+			[]Type{FUNC, CALL, IDENT, IDENT}, true},
+		{"list-type-func-param", "(func (f:(func []int):int) :int 0)",
+			[]Type{FUNC, BASIC}, true},
+		{"list-type-no-close", "(func (xs:[int) :int 0)", []Type{}, false},
 		//{"nested-decl", "(func:int () (func:int))", []Type{}, false},
 	}
 	handleTests(t, tests)
 }
 
+func TestParseGeneric(t *testing.T) {
+	tests := []Test{
+		{"type-param", "(func [T] (x:T):T x)", []Type{FUNC, IDENT}, true},
+		{"type-params", "(func [T U] (x:T f:(func T):U) (f x))",
+			[]Type{FUNC, CALL, IDENT, IDENT}, true},
+		{"list-type-param", "(func [T] (xs:[]T):int (len xs))",
+			[]Type{FUNC, CALL, IDENT, IDENT}, true},
+		{"no-type-params", "(func [] (x:int) x)", []Type{}, false},
+		{"duplicate-type-param", "(func [T T] (x:T) x)", []Type{}, false},
+		{"non-ident-type-param", "(func [1] (x:int) x)", []Type{}, false},
+		{"instance", "(id[int] 1)", []Type{CALL, INSTANCE, BASIC}, true},
+		{"instance-types", "(pair[int []bool] 1 [])",
+			[]Type{CALL, INSTANCE, BASIC, ARRAY}, true},
+		{"instance-value", "(f id[u8])", []Type{CALL, IDENT, INSTANCE}, true},
+		{"array-arg", "(f x [1])", []Type{CALL, IDENT, IDENT, ARRAY, BASIC},
+			true},
+		{"no-type-args", "(id[] 1)", []Type{}, false},
+	}
+	handleTests(t, tests)
+}
+
 func TestParseDeclFile(t *testing.T) {
 	tests := []Test{
 		{"simple", "(define main (func:int 0))",
@@ -229,11 +409,36 @@ func TestParseDeclFile(t *testing.T) {
 	handleFileTests(t, tests)
 }
 
+func TestParseCond(t *testing.T) {
+	tests := []Test{
+		{"cond", "(cond:int ((< n 2) 1) (b 2))",
+			[]Type{COND, CLAUSE, BINARY, IDENT, BASIC, BASIC, CLAUSE, IDENT,
+				BASIC}, true},
+		{"cond-else", "(cond:int (a 1) (else 2))",
+			[]Type{COND, CLAUSE, IDENT, BASIC, BASIC}, true},
+		{"switch", "(switch n:int (0 1) (-1 (f n)) (else 2))",
+			[]Type{SWITCH, IDENT, CLAUSE, BASIC, BASIC, CLAUSE, UNARY, BASIC,
+				CALL, IDENT, IDENT, BASIC}, true},
+		{"cond-no-type", "(cond (a 1))", []Type{COND, CLAUSE, IDENT, BASIC},
+			true},
+		{"cond-no-clauses", "(cond:int)", []Type{}, false},
+		{"cond-only-else", "(cond:int (else 1))", []Type{}, false},
+		{"cond-else-not-last", "(cond:int (else 1) (a 2))", []Type{}, false},
+		{"cond-no-body", "(cond:int (a))", []Type{}, false},
+		{"switch-no-type", "(switch n (0 1))",
+			[]Type{SWITCH, IDENT, CLAUSE, BASIC, BASIC}, true},
+		{"switch-no-clauses", "(switch n:int)", []Type{}, false},
+		{"else-expr", "(else 1)", []Type{}, false},
+	}
+	handleTests(t, tests)
+}
+
 func TestParseIf(t *testing.T) {
 	tests := []Test{
 		{"then-only", "(if false :int 3)", []Type{IF, BASIC, BASIC}, true},
 		{"then-else", "(if false :int 3 4)", []Type{IF, BASIC, BASIC, BASIC}, true},
 		{"no-type", "(if false :int 0 1)", []Type{}, false},
+		{"inferred-type", "(if false 3 4)", []Type{IF, BASIC, BASIC, BASIC}, true},
 		{"integer-cond", "(if 1 :int 3)", []Type{IF, BASIC, BASIC}, true},
 		{"var-cond", "(if asdf :int 3)", []Type{IF, IDENT, BASIC}, true},
 		{"var-keyword", "(if var :int 3)", []Type{}, false},
@@ -246,6 +451,59 @@ func TestParseIf(t *testing.T) {
 	handleTests(t, tests)
 }
 
+func TestParseStruct(t *testing.T) {
+	tests := []Test{
+		{"selector", "p.x", []Type{SELECTOR, IDENT}, true},
+		{"nested", "l.a.x", []Type{SELECTOR, SELECTOR, IDENT}, true},
+		{"call-result", "(f 1).x", []Type{SELECTOR, CALL, IDENT, BASIC}, true},
+		{"call-field", "(p.f 1)", []Type{CALL, SELECTOR, IDENT, BASIC}, true},
+		{"assign-field", "(= p.x 1)", []Type{ASSIGN, BASIC}, true},
+		{"assign-field-index", "(= p.xs 0 1)", []Type{ASSIGN, BASIC, BASIC},
+			true},
+		{"struct-expr", "(struct (x:int))", []Type{}, false},
+		{"no-field", "p.", []Type{}, false},
+	}
+	handleTests(t, tests)
+	tests = []Test{
+		{"struct", "(define Point (struct (x:int y:int)))",
+			[]Type{FILE, DEFINE, STRUCT}, true},
+		{"empty", "(define Empty (struct ()))", []Type{FILE, DEFINE, STRUCT}, true},
+		{"duplicate-field", "(define P (struct (x:int x:int)))", []Type{},
+			false},
+		{"redeclared-type", "(define P (struct ()))(define P:int 0)", []Type{},
+			false},
+	}
+	handleFileTests(t, tests)
+}
+
+func TestParseUnion(t *testing.T) {
+	tests := []Test{
+		{"match", "(match r:int ((Ok v) v) ((None) 0))",
+			[]Type{MATCH, IDENT, ARM, IDENT, ARM, BASIC}, true},
+		{"default", "(match (f 1):int ((Err c m) (+ c m)) (_ 0))",
+			[]Type{MATCH, CALL, IDENT, BASIC, ARM, BINARY, IDENT, IDENT, ARM,
+				BASIC}, true},
+		{"no-arms", "(match r:int)", []Type{}, false},
+		{"no-type", "(match r ((Ok v) v))", []Type{MATCH, IDENT, ARM, IDENT},
+			true},
+		{"duplicate-binding", "(match r:int ((P x x) x))", []Type{}, false},
+		{"bad-binding", "(match r:int ((P 1) 0))", []Type{}, false},
+		{"union-expr", "(union (A))", []Type{}, false},
+	}
+	handleTests(t, tests)
+	tests = []Test{
+		{"union", "(define R (union (Ok value:int) (Err code:int) (None)))",
+			[]Type{FILE, DEFINE, UNION}, true},
+		{"no-variants", "(define R (union))", []Type{}, false},
+		{"duplicate-field", "(define R (union (A x:int x:int)))", []Type{},
+			false},
+		{"duplicate-variant", "(define R (union (A) (A)))", []Type{}, false},
+		{"redeclared-variant", "(define R (union (A)))(define A:int 0)",
+			[]Type{}, false},
+	}
+	handleFileTests(t, tests)
+}
+
 func TestParseUnary(t *testing.T) {
 	var tests = []Test{
 		{"negate-integer", "-24", []Type{UNARY, BASIC}, true},
@@ -253,6 +511,11 @@ func TestParseUnary(t *testing.T) {
 		{"negate-call", "-(foo)", []Type{UNARY, CALL, IDENT}, true},
 		{"positive-binary", "+(+ 2 3)", []Type{UNARY, BINARY, BASIC, BASIC}, true},
 		{"positive-decl", "+(define foo:int 42)", []Type{}, false},
+		{"not-var", "!done", []Type{UNARY, IDENT}, true},
+		{"not-keyword", "not done", []Type{UNARY, IDENT}, true},
+		{"not-call-form", "(not (== a b))", []Type{UNARY, BINARY, IDENT, IDENT},
+			true},
+		{"bit-not", "~0xFF", []Type{UNARY, BASIC}, true},
 	}
 	handleTests(t, tests)
 }
@@ -264,11 +527,30 @@ func TestParseVar(t *testing.T) {
 		{"with-assign", "(var (a:int) :int(= a 5))",
 			[]Type{VAR, ASSIGN, BASIC}, true},
 		{"no-type", "(var (a):int)", []Type{}, false},
+		{"inferred-type", "(var (a:int) (= a 5) a)",
+			[]Type{VAR, ASSIGN, BASIC, IDENT}, true},
 		{"redeclare", "(var (a:int a:bool) :int)", []Type{}, false},
 	}
 	handleTests(t, tests)
 }
 
+func TestParseLet(t *testing.T) {
+	tests := []Test{
+		{"simple", "(let ((a 1)) a)", []Type{LET, BINDING, BASIC, IDENT}, true},
+		{"sequential", "(let ((a 1) (b (+ a 1))) (= a b) b)",
+			[]Type{LET, BINDING, BASIC, BINDING, BINARY, IDENT, BASIC, ASSIGN,
+				IDENT, IDENT}, true},
+		{"rebind", "(let ((a 1) (a true)) a)",
+			[]Type{LET, BINDING, BASIC, BINDING, BASIC, IDENT}, true},
+		{"no-bindings", "(let () 1)", []Type{LET, BASIC}, true},
+		{"no-body", "(let ((a 1)))", []Type{}, false},
+		{"no-value", "(let ((a)) a)", []Type{}, false},
+		{"typed", "(let ((a:int 1)) a)", []Type{}, false},
+		{"unparenthesised", "(let (a 1) a)", []Type{}, false},
+	}
+	handleTests(t, tests)
+}
+
 func TestParseFile(t *testing.T) {
 	test := Test{"bad-ext", "", []Type{}, false}
 
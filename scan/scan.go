@@ -11,6 +11,7 @@ package scan
 import (
 	"bufio"
 	"io"
+	"strings"
 	"unicode"
 
 	"github.com/rthornton128/calc/token"
@@ -56,8 +57,14 @@ func (s *Scanner) Scan() (lit string, tok token.Token, pos token.Pos) {
 		tok = token.LPAREN
 	case ')':
 		tok = token.RPAREN
+	case '[':
+		tok = token.LBRACK
+	case ']':
+		tok = token.RBRACK
 	case ':':
 		tok = token.COLON
+	case '.':
+		tok = token.PERIOD
 	case '+':
 		tok = token.ADD
 	case '-':
@@ -68,18 +75,35 @@ func (s *Scanner) Scan() (lit string, tok token.Token, pos token.Pos) {
 		tok = token.QUO
 	case '%':
 		tok = token.REM
+	case '^':
+		tok = token.BXOR
+	case '~':
+		tok = token.BNOT
+	case '_':
+		// a lone underscore is the blank identifier, as in the default arm
+		// of a match, but identifiers may not otherwise contain one
+		tok = token.ILLEGAL
+		if !unicode.IsLetter(s.ch) && !unicode.IsDigit(s.ch) && s.ch != '_' {
+			tok = token.IDENT
+		}
 	case '<':
 		tok = s.selectToken('=', token.LTE, token.LST)
+		if tok == token.LST {
+			tok = s.selectToken('<', token.SHL, token.LST)
+		}
 	case '>':
 		tok = s.selectToken('=', token.GTE, token.GTT)
+		if tok == token.GTT {
+			tok = s.selectToken('>', token.SHR, token.GTT)
+		}
 	case '=':
 		tok = s.selectToken('=', token.EQL, token.ASSIGN)
 	case '!':
-		tok = s.selectToken('=', token.NEQ, token.ILLEGAL)
+		tok = s.selectToken('=', token.NEQ, token.NOT)
 	case '&':
-		tok = s.selectToken('&', token.AND, token.ILLEGAL)
+		tok = s.selectToken('&', token.AND, token.BAND)
 	case '|':
-		tok = s.selectToken('|', token.OR, token.ILLEGAL)
+		tok = s.selectToken('|', token.OR, token.BOR)
 	case ';':
 		s.skipComment()
 		s.next()
@@ -115,15 +139,51 @@ func (s *Scanner) scanIdentifier() (string, token.Token, token.Pos) {
 	return str, token.Lookup(str), s.file.Pos(start)
 }
 
+// scanNumber scans an integer or floating-point literal. Integers may have
+// a 0x, 0o or 0b prefix and underscores may be used to separate digits. The
+// literal is validated by the parser.
 func (s *Scanner) scanNumber() (string, token.Token, token.Pos) {
 	start := s.offset
-	var str string
+	tok := token.INTEGER
+	str := s.scanDigits()
+
+	if str == "0" && strings.ContainsRune("xXoObB", s.ch) {
+		for unicode.IsLetter(s.ch) || unicode.IsDigit(s.ch) || s.ch == '_' {
+			str += string(s.ch)
+			s.next()
+		}
+		return str, tok, s.file.Pos(start)
+	}
+	if s.ch == '.' {
+		tok = token.FLOAT
+		str += string(s.ch)
+		s.next()
+		str += s.scanDigits()
+	}
+	if s.ch == 'e' || s.ch == 'E' {
+		tok = token.FLOAT
+		str += string(s.ch)
+		s.next()
+		if s.ch == '+' || s.ch == '-' {
+			str += string(s.ch)
+			s.next()
+		}
+		exp := s.scanDigits()
+		if exp == "" {
+			tok = token.ILLEGAL
+		}
+		str += exp
+	}
+	return str, tok, s.file.Pos(start)
+}
 
-	for unicode.IsDigit(s.ch) {
+func (s *Scanner) scanDigits() string {
+	var str string
+	for unicode.IsDigit(s.ch) || s.ch == '_' {
 		str += string(s.ch)
 		s.next()
 	}
-	return str, token.INTEGER, s.file.Pos(start)
+	return str
 }
 
 func (s *Scanner) selectToken(r rune, a, b token.Token) token.Token {
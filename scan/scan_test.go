@@ -28,12 +28,13 @@ func test_handler(t *testing.T, src string, expected []token.Token) {
 }
 
 func TestIdentifier(t *testing.T) {
-	src := "a A z23 Zasdf"
+	src := "a A z23 Zasdf _"
 	expected := []token.Token{
 		token.IDENT,
 		token.IDENT,
 		token.IDENT,
 		token.IDENT,
+		token.IDENT,
 		token.EOF,
 	}
 
@@ -50,6 +51,39 @@ func TestNumber(t *testing.T) {
 	test_handler(t, src, expected)
 }
 
+func TestFloat(t *testing.T) {
+	src := "1.5 0.25 3. 1e10 2.5E-3 6e+2 7e 8.e1"
+	expected := []token.Token{
+		token.FLOAT,
+		token.FLOAT,
+		token.FLOAT,
+		token.FLOAT,
+		token.FLOAT,
+		token.FLOAT,
+		token.ILLEGAL,
+		token.FLOAT,
+		token.EOF,
+	}
+
+	test_handler(t, src, expected)
+}
+
+func TestPrefixedInteger(t *testing.T) {
+	src := "0xFF 0o17 0b1010 1_000_000 0x_dead_BEEF 0b12 1_0.5"
+	expected := []token.Token{
+		token.INTEGER,
+		token.INTEGER,
+		token.INTEGER,
+		token.INTEGER,
+		token.INTEGER,
+		token.INTEGER,
+		token.FLOAT,
+		token.EOF,
+	}
+
+	test_handler(t, src, expected)
+}
+
 func TestScan(t *testing.T) {
 	src := "(+ 2 (- 4 1) (* 6 5) (% 10 2) (/ 9 3)); comment"
 	expected := []token.Token{
@@ -82,6 +116,57 @@ func TestScan(t *testing.T) {
 	test_handler(t, src, expected)
 }
 
+func TestScanBitwise(t *testing.T) {
+	src := "& | ^ ~ << >> <<= >>> <<<"
+	expected := []token.Token{
+		token.BAND,
+		token.BOR,
+		token.BXOR,
+		token.BNOT,
+		token.SHL,
+		token.SHR,
+		token.SHL,
+		token.ASSIGN,
+		token.SHR,
+		token.GTT,
+		token.SHL,
+		token.LST,
+		token.EOF,
+	}
+	test_handler(t, src, expected)
+}
+
+func TestScanNot(t *testing.T) {
+	src := "!a not b !=notnot"
+	expected := []token.Token{
+		token.NOT,
+		token.IDENT,
+		token.NOT,
+		token.IDENT,
+		token.NEQ,
+		token.IDENT,
+		token.EOF,
+	}
+	test_handler(t, src, expected)
+}
+
+func TestScanBrackets(t *testing.T) {
+	src := "xs:[]int [[]]"
+	expected := []token.Token{
+		token.IDENT,
+		token.COLON,
+		token.LBRACK,
+		token.RBRACK,
+		token.IDENT,
+		token.LBRACK,
+		token.LBRACK,
+		token.RBRACK,
+		token.RBRACK,
+		token.EOF,
+	}
+	test_handler(t, src, expected)
+}
+
 func TestScanAllTokens(t *testing.T) {
 	src := "()+-*/% 1 12\t 12345 123456789 | a as ! != < <=! = == > >= & &&" +
 		"| || : \\ \r ;"
@@ -97,21 +182,21 @@ func TestScanAllTokens(t *testing.T) {
 		token.INTEGER,
 		token.INTEGER,
 		token.INTEGER,
-		token.ILLEGAL,
+		token.BOR,
 		token.IDENT,
 		token.IDENT,
-		token.ILLEGAL,
+		token.NOT,
 		token.NEQ,
 		token.LST,
 		token.LTE,
-		token.ILLEGAL,
+		token.NOT,
 		token.ASSIGN,
 		token.EQL,
 		token.GTT,
 		token.GTE,
-		token.ILLEGAL,
+		token.BAND,
 		token.AND,
-		token.ILLEGAL,
+		token.BOR,
 		token.OR,
 		token.COLON,
 		token.ILLEGAL,
@@ -17,6 +17,7 @@ const (
 
 	lit_start
 	BOOL
+	FLOAT
 	IDENT
 	INTEGER
 	lit_end
@@ -24,7 +25,10 @@ const (
 	op_start
 	LPAREN
 	RPAREN
+	LBRACK
+	RBRACK
 	COLON
+	PERIOD
 
 	ADD
 	SUB
@@ -32,10 +36,18 @@ const (
 	QUO
 	REM
 
+	BAND
+	BOR
+	BXOR
+	BNOT
+	SHL
+	SHR
+
 	ASSIGN
 
 	AND
 	OR
+	NOT
 
 	EQL
 	NEQ
@@ -46,10 +58,19 @@ const (
 	op_end
 
 	key_start
+	BREAK
+	COND
+	CONTINUE
 	DEFINE
+	ELSE
 	FOR
 	FUNC
 	IF
+	LET
+	MATCH
+	STRUCT
+	SWITCH
+	UNION
 	VAR
 	key_end
 
@@ -57,33 +78,53 @@ const (
 )
 
 var tok_strings = map[Token]string{
-	EOF:     "EOF",
-	ILLEGAL: "Illegal",
-	BOOL:    "Boolean",
-	IDENT:   "Identifier",
-	INTEGER: "Integer",
-	LPAREN:  "(",
-	RPAREN:  ")",
-	COLON:   ":",
-	ADD:     "+",
-	SUB:     "-",
-	MUL:     "*",
-	QUO:     "/",
-	REM:     "%",
-	ASSIGN:  "=",
-	AND:     "&&",
-	OR:      "||",
-	EQL:     "==",
-	NEQ:     "!=",
-	LST:     "<",
-	GTT:     ">",
-	LTE:     "<=",
-	GTE:     ">=",
-	DEFINE:  "define",
-	FOR:     "for",
-	FUNC:    "func",
-	IF:      "if",
-	VAR:     "var",
+	EOF:      "EOF",
+	ILLEGAL:  "Illegal",
+	BOOL:     "Boolean",
+	FLOAT:    "Float",
+	IDENT:    "Identifier",
+	INTEGER:  "Integer",
+	LPAREN:   "(",
+	RPAREN:   ")",
+	LBRACK:   "[",
+	RBRACK:   "]",
+	COLON:    ":",
+	PERIOD:   ".",
+	ADD:      "+",
+	SUB:      "-",
+	MUL:      "*",
+	QUO:      "/",
+	REM:      "%",
+	BAND:     "&",
+	BOR:      "|",
+	BXOR:     "^",
+	BNOT:     "~",
+	SHL:      "<<",
+	SHR:      ">>",
+	ASSIGN:   "=",
+	AND:      "&&",
+	OR:       "||",
+	NOT:      "!",
+	EQL:      "==",
+	NEQ:      "!=",
+	LST:      "<",
+	GTT:      ">",
+	LTE:      "<=",
+	GTE:      ">=",
+	BREAK:    "break",
+	COND:     "cond",
+	CONTINUE: "continue",
+	DEFINE:   "define",
+	ELSE:     "else",
+	FOR:      "for",
+	FUNC:     "func",
+	IF:       "if",
+	LET:      "let",
+	MATCH:    "match",
+	STRUCT:   "struct",
+	SWITCH:   "switch",
+	UNION:    "union",
+	VAR:      "var",
 }
 
 func (t Token) IsLiteral() bool {
@@ -102,6 +143,9 @@ func Lookup(str string) Token {
 	if str == "true" || str == "false" {
 		return BOOL
 	}
+	if str == "not" {
+		return NOT
+	}
 	for t, s := range tok_strings {
 		if s == str {
 			return t
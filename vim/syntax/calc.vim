@@ -14,27 +14,34 @@ endif
 syn case match
 
 " keywords
-syn keyword calcStatement define
-syn keyword calcConditional if
-syn keyword calcExpression func var
+syn keyword calcStatement break continue define
+syn keyword calcConditional cond else if match switch
+syn keyword calcExpression func let struct union var
 syn keyword calcRepeat for
+syn keyword calcOperator not
+syn keyword calcBuiltin all any append count filter fold get len list map
+syn keyword calcBuiltin range reduce slice
 
 hi def link calcStatement Statement
 hi def link calcConditional Conditional
 hi def link calcExpression Keyword
 hi def link calcRepeat Repeat
+hi def link calcOperator Operator
+hi def link calcBuiltin Function
 
 " Predeclared types
-syn keyword calcType bool int
+syn keyword calcType bool float int i8 i16 i32 i64 u8 u16 u32 u64
 
 hi def link calcType Type
 
 " Basic literals
 syn keyword calcBoolean false true
-syn match calcInteger /\d*/
+syn match calcInteger /\d[0-9_]*\|0[xX][0-9a-fA-F_]\+\|0[oO][0-7_]\+\|0[bB][01_]\+/
+syn match calcFloat /\d\+\.\d*\([eE][+-]\=\d\+\)\=\|\d\+[eE][+-]\=\d\+/
 
 hi def link calcBoolean Boolean
 hi def link calcInteger Number
+hi def link calcFloat Float
 
 " Comments
 syn keyword calcTODO contained TODO FIXME BUG
@@ -66,6 +73,8 @@ syn match calcOperator ">"
 syn match calcOperator ">="
 
 syn match calcSpecial ":"
+syn match calcSpecial "\["
+syn match calcSpecial "\]"
 
 hi def link calcOperator Operator
 hi def link calcSpecial Special